@@ -0,0 +1,126 @@
+// filter.go - Gossip message deduplication.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gossip
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/epochtime"
+	"golang.org/x/crypto/blake2b"
+)
+
+const filterKeyLength = 32
+
+// GossipFilter is a Bloom filter of the message hashes seen in the current
+// epoch, used to suppress duplicate gossip messages relayed by multiple
+// peers.  The filter is cleared at each epoch boundary.  It is safe for
+// concurrent use.
+//
+// As with any Bloom filter, a new message may be reported as seen with
+// a small probability, but a seen message is never reported as new.
+type GossipFilter struct {
+	sync.Mutex
+
+	clock epochtime.EpochClock
+	epoch uint64
+
+	key  [filterKeyLength]byte
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+// NewGossipFilter creates a GossipFilter sized such that its false positive
+// rate does not exceed falsePositiveRate until expectedItems hashes have
+// been added in an epoch.
+func NewGossipFilter(expectedItems int, falsePositiveRate float64) *GossipFilter {
+	if expectedItems <= 0 || falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		panic("gossip: invalid filter parameters")
+	}
+
+	// m = -n ln(p) / ln(2)^2, k = m/n ln(2).
+	n := float64(expectedItems)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	f := &GossipFilter{
+		clock: new(epochtime.Clock),
+		bits:  make([]uint64, (uint64(m)+63)/64),
+		m:     uint64(m),
+		k:     k,
+	}
+	f.epoch, _, _ = f.clock.Now()
+	f.resetLocked()
+	return f
+}
+
+// Seen adds msgHash to the filter, and returns true iff it was new, that is
+// it had not already been seen this epoch.
+func (f *GossipFilter) Seen(msgHash [DocumentHashLength]byte) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if now, _, _ := f.clock.Now(); now != f.epoch {
+		f.epoch = now
+		f.resetLocked()
+	}
+
+	// The bit indexes are derived from a keyed hash, so that peers can not
+	// craft message hashes that collide with those of other messages.
+	h, err := blake2b.New(16, f.key[:])
+	if err != nil {
+		panic("gossip: BUG: failed to initialize hash: " + err.Error())
+	}
+	h.Write(msgHash[:])
+	digest := h.Sum(nil)
+	h1 := binary.LittleEndian.Uint64(digest[0:8])
+	h2 := binary.LittleEndian.Uint64(digest[8:16]) | 1
+
+	isNew := false
+	for i := 0; i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		word, bit := idx/64, uint64(1)<<(idx%64)
+		if f.bits[word]&bit == 0 {
+			isNew = true
+			f.bits[word] |= bit
+		}
+	}
+	return isNew
+}
+
+// Reset clears the filter.
+func (f *GossipFilter) Reset() {
+	f.Lock()
+	defer f.Unlock()
+
+	f.resetLocked()
+}
+
+func (f *GossipFilter) resetLocked() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+	if _, err := rand.Reader.Read(f.key[:]); err != nil {
+		panic("gossip: failed to generate filter key: " + err.Error())
+	}
+}
@@ -0,0 +1,77 @@
+// filter_test.go - Gossip message deduplication tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gossip
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/stretchr/testify/require"
+)
+
+func testMessageHash(i int) [DocumentHashLength]byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(i))
+	return sha256.Sum256(b[:])
+}
+
+func TestGossipFilter(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		nrItems           = 10000
+		falsePositiveRate = 0.01
+	)
+	f := NewGossipFilter(nrItems, falsePositiveRate)
+
+	// Every hash is new on first sight, bar false positives.
+	falsePositives := 0
+	for i := 0; i < nrItems; i++ {
+		if !f.Seen(testMessageHash(i)) {
+			falsePositives++
+		}
+	}
+	require.True(falsePositives < falsePositiveRate*nrItems, "%d false positives", falsePositives)
+
+	// And is never new again.
+	for i := 0; i < nrItems; i++ {
+		require.False(f.Seen(testMessageHash(i)), "duplicate %d", i)
+	}
+
+	f.Reset()
+	require.True(f.Seen(testMessageHash(0)), "Seen() after Reset()")
+}
+
+func TestGossipFilterEpochReset(t *testing.T) {
+	require := require.New(t)
+
+	clock := epochtime.NewFakeEpochClock(1)
+	f := NewGossipFilter(100, 0.01)
+	f.clock = clock
+	f.epoch = 1
+
+	h := testMessageHash(0)
+	require.True(f.Seen(h))
+	require.False(f.Seen(h))
+
+	// The filter is cleared at the epoch boundary.
+	clock.Advance(epochtime.Period)
+	require.True(f.Seen(h))
+	require.False(f.Seen(h))
+}
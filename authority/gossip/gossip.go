@@ -0,0 +1,79 @@
+// gossip.go - Authority gossip signatures.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package gossip provides the lightweight signatures used to authenticate
+// partial documents exchanged between directory authorities.
+package gossip
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+)
+
+// DocumentHashLength is the length of a document hash in bytes.
+const DocumentHashLength = 32
+
+// gossipContext domain separates gossip signatures from every other use of
+// an authority's signing key, in particular full PKI document signatures.
+var gossipContext = []byte("katzenpost-authority-gossip-v0")
+
+var (
+	// ErrInvalidKey is the error returned when a nil key is provided.
+	ErrInvalidKey = errors.New("gossip: invalid key")
+
+	// ErrInvalidSignature is the error returned when a gossip signature
+	// fails to verify.
+	ErrInvalidSignature = errors.New("gossip: invalid signature")
+)
+
+func message(documentHash *[DocumentHashLength]byte, epoch uint64, identity []byte) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], epoch)
+
+	b := make([]byte, 0, 1+len(gossipContext)+DocumentHashLength+len(tmp)+len(identity))
+	b = append(b, uint8(len(gossipContext)))
+	b = append(b, gossipContext...)
+	b = append(b, documentHash[:]...)
+	b = append(b, tmp[:]...)
+	b = append(b, identity...)
+	return b
+}
+
+// SignGossip returns the gossip signature made with signingKey over the
+// document hash, the epoch and the signer's identity.
+func SignGossip(signingKey *eddsa.PrivateKey, documentHash [DocumentHashLength]byte, epoch uint64) ([]byte, error) {
+	if signingKey == nil {
+		return nil, ErrInvalidKey
+	}
+	return signingKey.Sign(message(&documentHash, epoch, signingKey.Identity())), nil
+}
+
+// VerifyGossip verifies that rawSig is a gossip signature made by the private
+// component of publicKey over the document hash and epoch.
+func VerifyGossip(rawSig []byte, publicKey *eddsa.PublicKey, documentHash [DocumentHashLength]byte, epoch uint64) error {
+	if publicKey == nil {
+		return ErrInvalidKey
+	}
+	if len(rawSig) != eddsa.SignatureSize {
+		return ErrInvalidSignature
+	}
+	if !publicKey.Verify(rawSig, message(&documentHash, epoch, publicKey.Identity())) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
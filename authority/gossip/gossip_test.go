@@ -0,0 +1,110 @@
+// gossip_test.go - Authority gossip signature tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gossip
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGossipSignatureVectors(t *testing.T) {
+	require := require.New(t)
+
+	gossipTests := []struct {
+		signingKey   string
+		documentHash string
+		epoch        uint64
+		signature    string
+	}{
+		{
+			signingKey:   "e818ee275ddb72b8e63758dfba3a90e0f5687dd59f28c2812e9861ba19fa33bffb731cf47b3732b24a5f9c00a0304b66d461b23e7292c5eb406ec09adc2d95e0",
+			documentHash: "9956bc9faeeb6189a12fdcd54ea46e217e60dba0e890b1d616a14b343a33928a",
+			epoch:        14387,
+			signature:    "25116d531c415429b61581feb03f8037a218c6ed5d2b4af7e583016d956cffc608c57072e26431490df6ff3c3b463afd66cc7337c1c9178d9b46b48aa667f902",
+		},
+		{
+			signingKey:   "f99bdb809088a609c270f612a0e44844d03d86a63e109966ca66f6678cc02a1065efbc72434d921af5285c0fd28af6ed8592c0ac44c834d9d98f5589ea21c03f",
+			documentHash: "f9fc625c1b3a6c678e0eb2fc596036c03dc414e2f7f41bb4a52268719c8c96ea",
+			epoch:        14387,
+			signature:    "6f2a434e645a2a23f26d0f7b290cd7982f56d6091ce717d6b5b43762317ae28748b9e5cffb45b8e9279f2ae1067e663bf3cfa4496a3fd5f2aed49d7338c25400",
+		},
+	}
+
+	for i, v := range gossipTests {
+		rawKey, err := hex.DecodeString(v.signingKey)
+		require.NoError(err)
+		signingKey := new(eddsa.PrivateKey)
+		require.NoError(signingKey.FromBytes(rawKey))
+
+		var documentHash [DocumentHashLength]byte
+		rawHash, err := hex.DecodeString(v.documentHash)
+		require.NoError(err)
+		copy(documentHash[:], rawHash)
+
+		sig, err := SignGossip(signingKey, documentHash, v.epoch)
+		require.NoErrorf(err, "vector %d: SignGossip()", i)
+		require.Equalf(v.signature, hex.EncodeToString(sig), "vector %d: signature mismatch", i)
+
+		err = VerifyGossip(sig, signingKey.PublicKey(), documentHash, v.epoch)
+		require.NoErrorf(err, "vector %d: VerifyGossip()", i)
+	}
+}
+
+func TestGossipSignature(t *testing.T) {
+	require := require.New(t)
+
+	signingKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	otherKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+
+	var documentHash [DocumentHashLength]byte
+	_, err = rand.Reader.Read(documentHash[:])
+	require.NoError(err)
+	const epoch = 14387
+
+	sig, err := SignGossip(signingKey, documentHash, epoch)
+	require.NoError(err, "SignGossip()")
+	require.Len(sig, eddsa.SignatureSize, "SignGossip() length")
+
+	err = VerifyGossip(sig, signingKey.PublicKey(), documentHash, epoch)
+	require.NoError(err, "VerifyGossip()")
+
+	err = VerifyGossip(sig, signingKey.PublicKey(), documentHash, epoch+1)
+	require.Equal(ErrInvalidSignature, err, "VerifyGossip(): wrong epoch")
+
+	err = VerifyGossip(sig, otherKey.PublicKey(), documentHash, epoch)
+	require.Equal(ErrInvalidSignature, err, "VerifyGossip(): wrong key")
+
+	otherHash := documentHash
+	otherHash[0] ^= 0xff
+	err = VerifyGossip(sig, signingKey.PublicKey(), otherHash, epoch)
+	require.Equal(ErrInvalidSignature, err, "VerifyGossip(): wrong document hash")
+
+	err = VerifyGossip(sig[:eddsa.SignatureSize-1], signingKey.PublicKey(), documentHash, epoch)
+	require.Equal(ErrInvalidSignature, err, "VerifyGossip(): truncated signature")
+
+	// A gossip signature is not a signature over the bare document hash.
+	require.False(signingKey.PublicKey().Verify(sig, documentHash[:]), "gossip signature is not domain separated")
+
+	_, err = SignGossip(nil, documentHash, epoch)
+	require.Equal(ErrInvalidKey, err, "SignGossip(nil)")
+}
@@ -0,0 +1,254 @@
+// testharness.go - Simulated authority voting rounds.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package testharness provides an in-process simulation of a directory
+// authority voting round, for use in tests.
+package testharness
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/authority/gossip"
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+)
+
+const (
+	numMixes     = 9
+	numProviders = 2
+)
+
+// ErrNoConsensus is the error returned when no authority collected a
+// threshold number of signatures over the document.
+var ErrNoConsensus = errors.New("testharness: no consensus")
+
+// vote is a message gossiped between authorities, carrying the sender's
+// signature over its certified document.
+type vote struct {
+	from         int
+	documentHash [gossip.DocumentHashLength]byte
+	gossipSig    []byte
+	signature    *cert.Signature
+}
+
+// fakeNetwork delivers broadcast votes to the inbox of every other online
+// authority.
+type fakeNetwork struct {
+	sync.Mutex
+
+	inboxes [][]*vote
+	offline []bool
+}
+
+func (n *fakeNetwork) broadcast(v *vote) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.offline[v.from] {
+		return
+	}
+	for i := range n.inboxes {
+		if i == v.from || n.offline[i] {
+			continue
+		}
+		n.inboxes[i] = append(n.inboxes[i], v)
+	}
+}
+
+func (n *fakeNetwork) receive(idx int) []*vote {
+	n.Lock()
+	defer n.Unlock()
+
+	votes := n.inboxes[idx]
+	n.inboxes[idx] = nil
+	return votes
+}
+
+type mockAuthority struct {
+	idx int
+	key *eddsa.PrivateKey
+
+	rawCert      []byte
+	documentHash [gossip.DocumentHashLength]byte
+}
+
+// SimulatedAuthority is a set of in-process mock authorities sharing a fake
+// network, that vote on the PKI document for the next epoch.
+type SimulatedAuthority struct {
+	// Clock is the source of the current epoch, the authorities vote on
+	// the document for the epoch after it.  As certificate expiration is
+	// checked against the wall clock, it should not be set to the far
+	// past.
+	Clock *epochtime.FakeEpochClock
+
+	threshold   int
+	authorities []*mockAuthority
+	network     *fakeNetwork
+}
+
+// NewSimulatedAuthority creates n mock authorities, of which threshold must
+// sign the document for a voting round to reach consensus.  The clock is set
+// to the current epoch.
+func NewSimulatedAuthority(n int, threshold int) *SimulatedAuthority {
+	if threshold <= 0 || threshold > n {
+		panic("testharness: invalid threshold")
+	}
+	now, _, _ := epochtime.Now()
+	s := &SimulatedAuthority{
+		Clock:     epochtime.NewFakeEpochClock(now),
+		threshold: threshold,
+		network: &fakeNetwork{
+			inboxes: make([][]*vote, n),
+			offline: make([]bool, n),
+		},
+	}
+	for i := 0; i < n; i++ {
+		s.authorities = append(s.authorities, &mockAuthority{
+			idx: i,
+			key: testpki.DeriveKeypair(fmt.Sprintf("authority%d", i)),
+		})
+	}
+	return s
+}
+
+// SetOffline marks the authority idx as offline, such that it neither sends
+// nor receives votes.
+func (s *SimulatedAuthority) SetOffline(idx int, offline bool) {
+	s.network.Lock()
+	defer s.network.Unlock()
+
+	s.network.offline[idx] = offline
+}
+
+// Verifiers returns the verifiers of every authority's signature.
+func (s *SimulatedAuthority) Verifiers() []cert.Verifier {
+	verifiers := make([]cert.Verifier, 0, len(s.authorities))
+	for _, a := range s.authorities {
+		verifiers = append(verifiers, a.key.PublicKey())
+	}
+	return verifiers
+}
+
+// RunRound runs a full voting round for the epoch after the clock's current
+// epoch.  Each online authority signs its document, gossips its signature,
+// and verifies and collects the signatures of the others.  The consensus
+// document, signed by every authority that agreed upon it, is returned.
+func (s *SimulatedAuthority) RunRound() ([]byte, error) {
+	now, _, _ := s.Clock.Now()
+	epoch := now + 1
+	expiration := epochtime.Epoch.Add(time.Duration(epoch+1) * epochtime.Period).Unix()
+
+	doc := testpki.NewTestDocument(epoch, numMixes, numProviders)
+	doc.IssuedAtNanos = time.Now().UnixNano()
+	online := s.online()
+
+	// Each authority signs its document and gossips the signature.
+	for _, a := range online {
+		payload, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if a.rawCert, err = cert.Sign(a.key, payload, expiration); err != nil {
+			return nil, err
+		}
+		a.documentHash = sha256.Sum256(payload)
+		gossipSig, err := gossip.SignGossip(a.key, a.documentHash, epoch)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := cert.GetSignature(a.key.Identity(), a.rawCert)
+		if err != nil {
+			return nil, err
+		}
+		s.network.broadcast(&vote{
+			from:         a.idx,
+			documentHash: a.documentHash,
+			gossipSig:    gossipSig,
+			signature:    sig,
+		})
+	}
+
+	// Each authority verifies the votes it received, and adds the
+	// signatures over the same document to its own.
+	var wg sync.WaitGroup
+	for _, a := range online {
+		wg.Add(1)
+		go func(a *mockAuthority) {
+			defer wg.Done()
+			s.collectVotes(a, epoch)
+		}(a)
+	}
+	wg.Wait()
+
+	for _, a := range online {
+		if _, _, _, err := cert.VerifyThreshold(s.Verifiers(), s.threshold, a.rawCert); err == nil {
+			return a.rawCert, nil
+		}
+	}
+	return nil, ErrNoConsensus
+}
+
+func (s *SimulatedAuthority) online() []*mockAuthority {
+	s.network.Lock()
+	defer s.network.Unlock()
+
+	var online []*mockAuthority
+	for i, a := range s.authorities {
+		if !s.network.offline[i] {
+			online = append(online, a)
+		}
+	}
+	return online
+}
+
+func (s *SimulatedAuthority) collectVotes(a *mockAuthority, epoch uint64) {
+	for _, v := range s.network.receive(a.idx) {
+		peerKey := s.authorities[v.from].key.PublicKey()
+		if err := gossip.VerifyGossip(v.gossipSig, peerKey, v.documentHash, epoch); err != nil {
+			continue
+		}
+		if v.documentHash != a.documentHash {
+			continue
+		}
+		rawCert, err := cert.AddSignature(peerKey, *v.signature, a.rawCert)
+		if err != nil {
+			continue
+		}
+		a.rawCert = rawCert
+	}
+}
+
+// Document returns the PKI document certified by rawCert, without verifying
+// any of its signatures.
+func Document(rawCert []byte) (*pki.Document, error) {
+	payload, err := cert.GetCertified(rawCert)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(pki.Document)
+	if err := json.Unmarshal(payload, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
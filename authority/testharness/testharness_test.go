@@ -0,0 +1,61 @@
+// testharness_test.go - Simulated authority voting round tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package testharness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulatedAuthority(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		n         = 5
+		threshold = 3
+	)
+	s := NewSimulatedAuthority(n, threshold)
+	now, _, _ := s.Clock.Now()
+
+	rawCert, err := s.RunRound()
+	require.NoError(err, "RunRound()")
+	_, good, _, err := cert.VerifyThreshold(s.Verifiers(), threshold, rawCert)
+	require.NoError(err, "VerifyThreshold()")
+	require.Len(good, n)
+	doc, err := Document(rawCert)
+	require.NoError(err, "Document()")
+	require.Equal(now+1, doc.Epoch)
+	require.NoError(doc.VerifyFreshness(time.Now()), "VerifyFreshness()")
+
+	// With two authorities offline the threshold is still met.
+	s.SetOffline(0, true)
+	s.SetOffline(3, true)
+	rawCert, err = s.RunRound()
+	require.NoError(err, "RunRound(), 2 offline")
+	_, good, bad, err := cert.VerifyThreshold(s.Verifiers(), threshold, rawCert)
+	require.NoError(err, "VerifyThreshold(), 2 offline")
+	require.Len(good, threshold)
+	require.Len(bad, n-threshold)
+
+	// With three offline it is not.
+	s.SetOffline(4, true)
+	_, err = s.RunRound()
+	require.Equal(ErrNoConsensus, err)
+}
@@ -0,0 +1,156 @@
+// main.go - Authority key ceremony tool.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Command keygen generates authority signing keys and self-signed
+// certificates, and produces genesis document skeletons.
+//
+// Generate a key pair and certificate in the directory dir:
+//
+//	keygen -dir dir
+//
+// Produce a genesis document skeleton for the authorities whose PEM encoded
+// public keys are given:
+//
+//	keygen -genesis -out genesis.json authority1.public.pem authority2.public.pem
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+)
+
+const (
+	privateKeyFile = "identity.private.pem"
+	publicKeyFile  = "identity.public.pem"
+	certFile       = "identity.cert"
+)
+
+// Genesis is the genesis document skeleton.
+type Genesis struct {
+	// Authorities are the identity keys of the directory authorities.
+	Authorities []*eddsa.PublicKey
+
+	// Document is the initial PKI document, with an empty topology.
+	Document *pki.Document
+}
+
+func main() {
+	dir := flag.String("dir", ".", "directory to write the key pair and certificate to")
+	lifetime := flag.Duration("lifetime", 365*24*time.Hour, "certificate lifetime")
+	genesis := flag.Bool("genesis", false, "produce a genesis document from the public key files given as arguments")
+	out := flag.String("out", "genesis.json", "genesis document output file")
+	force := flag.Bool("force", false, "overwrite existing files")
+	flag.Parse()
+
+	var err error
+	if *genesis {
+		err = writeGenesis(*out, flag.Args(), *force)
+	} else {
+		err = generate(*dir, *lifetime, *force)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "keygen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// checkOverwrite returns an error if any of the files exist, unless force is
+// set.
+func checkOverwrite(force bool, files ...string) error {
+	if force {
+		return nil
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			return fmt.Errorf("refusing to overwrite '%v' without -force", f)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func generate(dir string, lifetime time.Duration, force bool) error {
+	privFile := filepath.Join(dir, privateKeyFile)
+	pubFile := filepath.Join(dir, publicKeyFile)
+	cFile := filepath.Join(dir, certFile)
+	if err := checkOverwrite(force, privFile, pubFile, cFile); err != nil {
+		return err
+	}
+
+	// eddsa.Load only generates a key if the private key file is absent.
+	if err := os.Remove(privFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	key, err := eddsa.Load(privFile, pubFile, rand.Reader)
+	if err != nil {
+		return err
+	}
+	defer key.Reset()
+
+	rawCert, err := cert.Sign(key, key.PublicKey().Bytes(), time.Now().Add(lifetime).Unix())
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(cFile, rawCert, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Generated identity key %v\n", key.PublicKey())
+	return nil
+}
+
+func writeGenesis(out string, pubFiles []string, force bool) error {
+	if len(pubFiles) == 0 {
+		return fmt.Errorf("no authority public key files given")
+	}
+	if err := checkOverwrite(force, out); err != nil {
+		return err
+	}
+
+	g := &Genesis{}
+	for _, f := range pubFiles {
+		pubKey := new(eddsa.PublicKey)
+		if err := pubKey.FromPEMFile(f); err != nil {
+			return fmt.Errorf("failed to load '%v': %v", f, err)
+		}
+		g.Authorities = append(g.Authorities, pubKey)
+	}
+	epoch, _, _ := epochtime.Now()
+	g.Document = &pki.Document{
+		Epoch:         epoch,
+		GenesisEpoch:  epoch,
+		IssuedAtNanos: time.Now().UnixNano(),
+		Topology:      [][]*pki.MixDescriptor{},
+		Providers:     []*pki.MixDescriptor{},
+	}
+
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, append(b, '\n'), 0600)
+}
@@ -0,0 +1,106 @@
+// main_test.go - Authority key ceremony tool tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/stretchr/testify/require"
+)
+
+func buildKeygen(t *testing.T, dir string) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not available")
+	}
+	bin := filepath.Join(dir, "keygen")
+	out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput()
+	require.NoError(t, err, "go build: %s", out)
+	return bin
+}
+
+func TestKeygen(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "keygen")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+	bin := buildKeygen(t, tmpDir)
+
+	// Generate the keys of two authorities.
+	var pubFiles []string
+	var pubKeys []*eddsa.PublicKey
+	for _, name := range []string{"authority1", "authority2"} {
+		dir := filepath.Join(tmpDir, name)
+		require.NoError(os.Mkdir(dir, 0700))
+		out, err := exec.Command(bin, "-dir", dir).CombinedOutput()
+		require.NoError(err, "keygen: %s", out)
+
+		privKey, err := eddsa.Load(filepath.Join(dir, privateKeyFile), "", nil)
+		require.NoError(err, "Load()")
+		pubKey := new(eddsa.PublicKey)
+		pubFile := filepath.Join(dir, publicKeyFile)
+		require.NoError(pubKey.FromPEMFile(pubFile), "FromPEMFile()")
+		require.Equal(privKey.PublicKey().Bytes(), pubKey.Bytes())
+
+		// The certificate is self-signed, and certifies the public key.
+		rawCert, err := ioutil.ReadFile(filepath.Join(dir, certFile))
+		require.NoError(err)
+		certified, err := cert.Verify(pubKey, rawCert)
+		require.NoError(err, "cert.Verify()")
+		require.Equal(pubKey.Bytes(), certified)
+
+		// Existing keys are not overwritten without -force.
+		out, err = exec.Command(bin, "-dir", dir).CombinedOutput()
+		require.Error(err, "keygen without -force: %s", out)
+		pubKey2 := new(eddsa.PublicKey)
+		require.NoError(pubKey2.FromPEMFile(pubFile))
+		require.Equal(pubKey.Bytes(), pubKey2.Bytes())
+		out, err = exec.Command(bin, "-dir", dir, "-force").CombinedOutput()
+		require.NoError(err, "keygen -force: %s", out)
+		require.NoError(pubKey2.FromPEMFile(pubFile))
+		require.NotEqual(pubKey.Bytes(), pubKey2.Bytes())
+
+		pubFiles = append(pubFiles, pubFile)
+		pubKeys = append(pubKeys, pubKey2)
+	}
+
+	// Produce the genesis document.
+	genesisFile := filepath.Join(tmpDir, "genesis.json")
+	args := append([]string{"--genesis", "-out", genesisFile}, pubFiles...)
+	out, err := exec.Command(bin, args...).CombinedOutput()
+	require.NoError(err, "keygen --genesis: %s", out)
+	b, err := ioutil.ReadFile(genesisFile)
+	require.NoError(err)
+	var g Genesis
+	require.NoError(json.Unmarshal(b, &g), "json.Unmarshal()")
+	require.Len(g.Authorities, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		require.Equal(pubKey.Bytes(), g.Authorities[i].Bytes())
+	}
+	require.NotNil(g.Document)
+	require.Equal(g.Document.Epoch, g.Document.GenesisEpoch)
+
+	out, err = exec.Command(bin, args...).CombinedOutput()
+	require.Error(err, "keygen --genesis without -force: %s", out)
+}
@@ -0,0 +1,268 @@
+// aggregate.go - Ed25519 certificate signature aggregation.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/edwards25519"
+)
+
+// aggregationContext is the domain separation string used when deriving
+// the per signature aggregation coefficients.
+const aggregationContext = "katzenpost-cert-ed25519-half-aggregation-v0"
+
+var (
+	// ErrNoSignatures is the error returned when there are no signatures
+	// to aggregate.
+	ErrNoSignatures = errors.New("no signatures to aggregate")
+
+	// ErrCertificateMismatch is the error returned when the certificates
+	// to be aggregated do not certify the same data.
+	ErrCertificateMismatch = errors.New("certificates do not certify the same data")
+
+	// ErrInvalidAggregatedSignature is the error returned when an
+	// aggregated certificate is malformed or fails to verify.
+	ErrInvalidAggregatedSignature = errors.New("invalid aggregated signature")
+)
+
+// AggregatedCertificate is a certificate whose Ed25519 signatures have been
+// half-aggregated: each signature's 32 byte commitment R is kept, while the
+// 32 byte scalars S are collapsed into a single scalar, saving 32 bytes per
+// signer.
+type AggregatedCertificate struct {
+	// Version is the certificate format version.
+	Version uint32
+
+	// Expiration is seconds since Unix epoch.
+	Expiration int64
+
+	// KeyType indicates the type of key
+	// that is certified by this certificate.
+	KeyType string
+
+	// Certified is the data that is certified by
+	// this certificate.
+	Certified []byte
+
+	// MaxSigners is the maximum number of signatures the
+	// certificate may carry, zero meaning unlimited.
+	MaxSigners uint8 `cbor:",omitempty"`
+
+	// SignedAt is seconds since Unix epoch at which the
+	// certificate was created.
+	SignedAt int64 `cbor:",omitempty"`
+
+	// Identities are the Ed25519 public keys of the signers,
+	// sorted in ascending order.
+	Identities [][]byte
+
+	// Commitments are the R components of the signatures, in the
+	// same order as Identities.
+	Commitments [][]byte
+
+	// Scalar is the aggregated S component of the signatures.
+	Scalar []byte
+}
+
+func (a *AggregatedCertificate) certificate() *certificate {
+	return &certificate{
+		Version:    a.Version,
+		Expiration: a.Expiration,
+		KeyType:    a.KeyType,
+		Certified:  a.Certified,
+		MaxSigners: a.MaxSigners,
+		SignedAt:   a.SignedAt,
+	}
+}
+
+// aggregationCoefficients returns the per signature coefficients, which
+// bind every signature to the full set of signers, commitments and the
+// message.
+func (a *AggregatedCertificate) aggregationCoefficients(mesg []byte) []*[32]byte {
+	transcript := new(bytes.Buffer)
+	transcript.WriteString(aggregationContext)
+	for i := range a.Identities {
+		transcript.Write(a.Commitments[i])
+		transcript.Write(a.Identities[i])
+	}
+	transcript.Write(mesg)
+
+	z := make([]*[32]byte, len(a.Identities))
+	for i := range z {
+		var idx [4]byte
+		binary.BigEndian.PutUint32(idx[:], uint32(i))
+		z[i] = edwards25519.ScFromHash(transcript.Bytes(), idx[:])
+	}
+	return z
+}
+
+// AggregateSignatures collapses the Ed25519 signatures attached to the
+// given certificates, which must all certify the same data, into a single
+// CBOR encoded AggregatedCertificate.  Every signature is verified prior
+// to aggregation, and duplicate signatures by the same signer are only
+// included once.
+func AggregateSignatures(rawCerts [][]byte) ([]byte, error) {
+	var mesg []byte
+	agg := new(AggregatedCertificate)
+	sigs := []Signature{}
+	seen := make(map[string]bool)
+	for _, rawCert := range rawCerts {
+		cert := certificate{}
+		err := cbor.Unmarshal(rawCert, &cert)
+		if err != nil {
+			return nil, ErrImpossibleDecode
+		}
+		err = cert.sanityCheck()
+		if err != nil {
+			return nil, err
+		}
+		certMesg, err := cert.message()
+		if err != nil {
+			return nil, err
+		}
+		if mesg == nil {
+			mesg = certMesg
+			agg.Version = cert.Version
+			agg.Expiration = cert.Expiration
+			agg.KeyType = cert.KeyType
+			agg.Certified = cert.Certified
+			agg.MaxSigners = cert.MaxSigners
+			agg.SignedAt = cert.SignedAt
+		} else if !bytes.Equal(mesg, certMesg) {
+			return nil, ErrCertificateMismatch
+		}
+
+		for _, sig := range cert.Signatures {
+			if seen[string(sig.Identity)] {
+				continue
+			}
+			if len(sig.Identity) != ed25519.PublicKeySize || len(sig.Payload) != ed25519.SignatureSize {
+				return nil, ErrBadSignature
+			}
+			if !ed25519.Verify(ed25519.PublicKey(sig.Identity), mesg, sig.Payload) {
+				return nil, ErrBadSignature
+			}
+			seen[string(sig.Identity)] = true
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) == 0 {
+		return nil, ErrNoSignatures
+	}
+	if agg.MaxSigners != 0 && len(sigs) > int(agg.MaxSigners) {
+		return nil, ErrMaxSignersReached
+	}
+
+	sort.Sort(byIdentity(sigs))
+	for _, sig := range sigs {
+		agg.Identities = append(agg.Identities, sig.Identity)
+		agg.Commitments = append(agg.Commitments, sig.Payload[:32])
+	}
+	z := agg.aggregationCoefficients(mesg)
+	var s, si [32]byte
+	for i, sig := range sigs {
+		copy(si[:], sig.Payload[32:])
+		edwards25519.ScMulAdd(&s, z[i], &si, &s)
+	}
+	agg.Scalar = s[:]
+	return cbor.Marshal(agg)
+}
+
+// VerifyAggregated verifies that the CBOR encoded AggregatedCertificate
+// rawAggCert is signed by exactly the given set of public keys.
+func VerifyAggregated(rawAggCert []byte, publicKeys []*eddsa.PublicKey) error {
+	agg := new(AggregatedCertificate)
+	err := cbor.Unmarshal(rawAggCert, agg)
+	if err != nil {
+		return ErrImpossibleDecode
+	}
+	cert := agg.certificate()
+	err = cert.sanityCheck()
+	if err != nil {
+		return err
+	}
+	n := len(agg.Identities)
+	if n == 0 || len(agg.Commitments) != n || len(agg.Scalar) != 32 {
+		return ErrInvalidAggregatedSignature
+	}
+	if agg.MaxSigners != 0 && n > int(agg.MaxSigners) {
+		return ErrMaxSignersReached
+	}
+
+	expected := make(map[string]bool)
+	for _, pk := range publicKeys {
+		expected[string(pk.Bytes())] = true
+	}
+	for i, id := range agg.Identities {
+		if !expected[string(id)] {
+			return ErrBadSignature
+		}
+		if i > 0 && bytes.Compare(agg.Identities[i-1], id) >= 0 {
+			return ErrInvalidAggregatedSignature
+		}
+	}
+	if len(expected) != n {
+		return ErrIdentitySignatureNotFound
+	}
+
+	var s [32]byte
+	copy(s[:], agg.Scalar)
+	if !edwards25519.ScMinimal(&s) {
+		return ErrInvalidAggregatedSignature
+	}
+	mesg, err := cert.message()
+	if err != nil {
+		return err
+	}
+
+	// Check [8]([S]B - sum([z_i]R_i + [z_i * k_i]A_i)) == 0.
+	var zero [32]byte
+	var acc, r, a, tmp edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&acc, &s)
+	z := agg.aggregationCoefficients(mesg)
+	for i := 0; i < n; i++ {
+		var rBytes, aBytes, zk [32]byte
+		copy(rBytes[:], agg.Commitments[i])
+		copy(aBytes[:], agg.Identities[i])
+		if len(agg.Commitments[i]) != 32 || !r.FromBytes(&rBytes) {
+			return ErrInvalidAggregatedSignature
+		}
+		if len(agg.Identities[i]) != 32 || !a.FromBytes(&aBytes) {
+			return ErrInvalidAggregatedSignature
+		}
+		k := edwards25519.ScFromHash(rBytes[:], aBytes[:], mesg)
+		edwards25519.ScMulAdd(&zk, z[i], k, &zero)
+
+		geScalarMultVartime(&tmp, z[i], &r)
+		edwards25519.GeNeg(&tmp, &tmp)
+		edwards25519.GeAddExtended(&acc, &acc, &tmp)
+		geScalarMultVartime(&tmp, &zk, &a)
+		edwards25519.GeNeg(&tmp, &tmp)
+		edwards25519.GeAddExtended(&acc, &acc, &tmp)
+	}
+	if !geIsSmallOrder(&acc) {
+		return ErrBadSignature
+	}
+	return nil
+}
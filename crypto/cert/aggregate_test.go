@@ -0,0 +1,108 @@
+// aggregate_test.go - Certificate signature aggregation tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateSignatures(t *testing.T) {
+	require := require.New(t)
+
+	const nrSigners = 10
+
+	ephemeralPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	expiration := time.Now().AddDate(0, 0, 1).Unix()
+
+	signers := make([]*eddsa.PrivateKey, nrSigners)
+	publicKeys := make([]*eddsa.PublicKey, nrSigners)
+	rawCerts := make([][]byte, nrSigners)
+	for i := range signers {
+		signers[i], err = eddsa.NewKeypair(rand.Reader)
+		require.NoError(err)
+		publicKeys[i] = signers[i].PublicKey()
+		rawCerts[i], err = Sign(signers[i], ephemeralPrivKey.PublicKey().Bytes(), expiration)
+		require.NoError(err)
+	}
+
+	// A single certificate carrying every signature.
+	multiCert := rawCerts[0]
+	for _, signer := range signers[1:] {
+		multiCert, err = SignMulti(signer, multiCert)
+		require.NoError(err)
+	}
+
+	aggCert, err := AggregateSignatures([][]byte{multiCert})
+	require.NoError(err, "AggregateSignatures(multi)")
+	require.NoError(VerifyAggregated(aggCert, publicKeys), "VerifyAggregated(multi)")
+	require.True(len(aggCert) < len(multiCert), "aggregated certificate is not smaller")
+	t.Logf("multi-signed certificate: %d bytes, aggregated: %d bytes", len(multiCert), len(aggCert))
+
+	// Individually signed certificates aggregate to the same thing.
+	aggCert2, err := AggregateSignatures(rawCerts)
+	require.NoError(err, "AggregateSignatures(individual)")
+	require.Equal(aggCert, aggCert2)
+
+	// Duplicate signatures are only included once.
+	aggCert2, err = AggregateSignatures(append([][]byte{multiCert}, rawCerts...))
+	require.NoError(err, "AggregateSignatures(duplicates)")
+	require.Equal(aggCert, aggCert2)
+
+	// The verifier must supply exactly the signing keys.
+	err = VerifyAggregated(aggCert, publicKeys[1:])
+	require.Equal(ErrBadSignature, err, "VerifyAggregated(missing key)")
+	otherKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	err = VerifyAggregated(aggCert, append(publicKeys, otherKey.PublicKey()))
+	require.Equal(ErrIdentitySignatureNotFound, err, "VerifyAggregated(extra key)")
+
+	// Tampering with the certificate is detected.
+	agg := new(AggregatedCertificate)
+	require.NoError(cbor.Unmarshal(aggCert, agg))
+	agg.Certified = []byte("tampered")
+	tampered, err := cbor.Marshal(agg)
+	require.NoError(err)
+	require.Equal(ErrBadSignature, VerifyAggregated(tampered, publicKeys), "VerifyAggregated(tampered certified)")
+
+	require.NoError(cbor.Unmarshal(aggCert, agg))
+	agg.Scalar[0] ^= 0x01
+	tampered, err = cbor.Marshal(agg)
+	require.NoError(err)
+	require.Equal(ErrBadSignature, VerifyAggregated(tampered, publicKeys), "VerifyAggregated(tampered scalar)")
+
+	require.NoError(cbor.Unmarshal(aggCert, agg))
+	agg.Commitments[0], agg.Commitments[1] = agg.Commitments[1], agg.Commitments[0]
+	tampered, err = cbor.Marshal(agg)
+	require.NoError(err)
+	require.Equal(ErrBadSignature, VerifyAggregated(tampered, publicKeys), "VerifyAggregated(swapped commitments)")
+
+	// Certificates must certify the same data.
+	otherCert, err := Sign(signers[0], []byte("other data"), expiration)
+	require.NoError(err)
+	_, err = AggregateSignatures([][]byte{multiCert, otherCert})
+	require.Equal(ErrCertificateMismatch, err)
+
+	_, err = AggregateSignatures(nil)
+	require.Equal(ErrNoSignatures, err)
+}
@@ -0,0 +1,115 @@
+// cache.go - Certificate verification cache.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+type cacheEntry struct {
+	key        string
+	certified  []byte
+	expiration int64
+	err        error
+}
+
+// CertCache is a bounded cache of certificate verification results, that
+// evicts the least recently used entry when full.  It is safe for concurrent
+// use.
+type CertCache struct {
+	sync.Mutex
+
+	clock    epochtime.EpochClock
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+func cacheKey(verifier Verifier, rawCert []byte) string {
+	h := sha256.Sum256(rawCert)
+	return string(h[:]) + string(verifier.Identity())
+}
+
+// Verify is equivalent to the package level Verify, except that the result
+// is cached, keyed by the certificate digest and the verifier's identity.
+// Certificates that were valid when cached are still checked for expiration
+// on every call.
+func (c *CertCache) Verify(verifier Verifier, rawCert []byte) ([]byte, error) {
+	key := cacheKey(verifier, rawCert)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		ent := elem.Value.(*cacheEntry)
+		if ent.err == nil && time.Unix(ent.expiration, 0).Before(now(c.clock)) {
+			ent.certified = nil
+			ent.err = ErrCertificateExpired
+		}
+		if ent.err != nil {
+			return nil, ent.err
+		}
+		return append([]byte{}, ent.certified...), nil
+	}
+
+	ent := &cacheEntry{key: key}
+	cert, err := verify(c.clock, verifier, rawCert)
+	if err != nil {
+		ent.err = err
+	} else {
+		ent.certified = cert.Certified
+		ent.expiration = cert.Expiration
+	}
+
+	if c.lru.Len() >= c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	c.entries[key] = c.lru.PushFront(ent)
+
+	if ent.err != nil {
+		return nil, ent.err
+	}
+	return append([]byte{}, ent.certified...), nil
+}
+
+// Len returns the number of cached verification results.
+func (c *CertCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+// NewCertCache creates a new CertCache holding at most capacity results.
+func NewCertCache(capacity int) *CertCache {
+	if capacity <= 0 {
+		panic("cert: invalid CertCache capacity")
+	}
+	return &CertCache{
+		clock:    systemClock,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
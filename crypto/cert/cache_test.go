@@ -0,0 +1,142 @@
+// cache_test.go - Certificate verification cache tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertCache(t *testing.T) {
+	assert := assert.New(t)
+
+	ephemeralPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	signingPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+	toSign := ephemeralPrivKey.PublicKey().Bytes()
+	certificate, err := Sign(signingPrivKey, toSign, expiration)
+	assert.NoError(err)
+
+	cache := NewCertCache(8)
+	for i := 0; i < 2; i++ {
+		mesg, err := cache.Verify(signingPrivKey.PublicKey(), certificate)
+		assert.NoError(err)
+		assert.Equal(toSign, mesg)
+	}
+	assert.Equal(1, cache.Len())
+
+	// A modified certificate must not hit the cached entry for the original.
+	idx := bytes.Index(certificate, toSign)
+	assert.True(idx > 0)
+	modified := append([]byte{}, certificate...)
+	modified[idx] ^= 0xff
+	mesg, err := cache.Verify(signingPrivKey.PublicKey(), modified)
+	assert.Equal(ErrBadSignature, err)
+	assert.Nil(mesg)
+	assert.Equal(2, cache.Len())
+
+	// Neither must a different verifier.
+	mesg, err = cache.Verify(ephemeralPrivKey.PublicKey(), certificate)
+	assert.Equal(ErrIdentitySignatureNotFound, err)
+	assert.Nil(mesg)
+	assert.Equal(3, cache.Len())
+
+	mesg, err = cache.Verify(signingPrivKey.PublicKey(), certificate)
+	assert.NoError(err)
+	assert.Equal(toSign, mesg)
+}
+
+func TestCertCacheEviction(t *testing.T) {
+	require := require.New(t)
+
+	signingPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+	certs := make([][]byte, 3)
+	for i := range certs {
+		certs[i], err = Sign(signingPrivKey, []byte{byte(i + 1)}, expiration)
+		require.NoError(err)
+	}
+
+	cache := NewCertCache(2)
+	_, err = cache.Verify(signingPrivKey.PublicKey(), certs[0])
+	require.NoError(err)
+	_, err = cache.Verify(signingPrivKey.PublicKey(), certs[1])
+	require.NoError(err)
+
+	// Touch certs[0] so that certs[1] is the least recently used.
+	_, err = cache.Verify(signingPrivKey.PublicKey(), certs[0])
+	require.NoError(err)
+	_, err = cache.Verify(signingPrivKey.PublicKey(), certs[2])
+	require.NoError(err)
+
+	require.Equal(2, cache.Len())
+	isCached := func(rawCert []byte) bool {
+		_, ok := cache.entries[cacheKey(signingPrivKey.PublicKey(), rawCert)]
+		return ok
+	}
+	require.True(isCached(certs[0]))
+	require.False(isCached(certs[1]))
+	require.True(isCached(certs[2]))
+}
+
+func benchmarkCert(b *testing.B) (Verifier, []byte) {
+	signingPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+	certificate, err := Sign(signingPrivKey, signingPrivKey.PublicKey().Bytes(), expiration)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return signingPrivKey.PublicKey(), certificate
+}
+
+func BenchmarkVerify(b *testing.B) {
+	verifier, certificate := benchmarkCert(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Verify(verifier, certificate); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCertCacheVerify(b *testing.B) {
+	verifier, certificate := benchmarkCert(b)
+	cache := NewCertCache(16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.Verify(verifier, certificate); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
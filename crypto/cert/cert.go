@@ -19,17 +19,24 @@ package cert
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"sort"
 	"time"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/epochtime"
 )
 
 const (
 	// CertVersion is the certificate format version.
-	CertVersion = 0
+	CertVersion = 1
+
+	// legacyCertVersion is the format version predating the MaxSigners
+	// and SignedAt fields.  Certificates with neither field set are still
+	// created and accepted in this format, for compatibility.
+	legacyCertVersion = 0
 )
 
 var (
@@ -71,6 +78,15 @@ var (
 
 	// ErrThresholdNotMet indicates that there were not enough valid signatures to meet the threshold.
 	ErrThresholdNotMet = errors.New("threshold failure")
+
+	// ErrCertificateStale indicates that the given certificate was signed too long ago.
+	ErrCertificateStale = errors.New("certificate stale")
+
+	// ErrMalleableSignature indicates that an Ed25519 signature's S scalar is not in canonical form.
+	ErrMalleableSignature = errors.New("malleable signature")
+
+	// ErrMaxSignersReached indicates that the certificate already carries the maximum number of signatures it permits.
+	ErrMaxSignersReached = errors.New("certificate maximum signers reached")
 )
 
 // Verifier is used to verify signatures.
@@ -121,6 +137,14 @@ type certificate struct {
 
 	// Signatures are the signature of the certificate.
 	Signatures []Signature
+
+	// MaxSigners is the maximum number of signatures the
+	// certificate may carry, zero meaning unlimited.
+	MaxSigners uint8 `cbor:",omitempty"`
+
+	// SignedAt is seconds since Unix epoch at which the
+	// certificate was created.
+	SignedAt int64 `cbor:",omitempty"`
 }
 
 func (c *certificate) message() ([]byte, error) {
@@ -133,11 +157,36 @@ func (c *certificate) message() ([]byte, error) {
 	if err != nil {
 		return nil, ErrImpossibleOutOfMemory
 	}
-	_, err = message.Write([]byte(c.KeyType))
+	if c.Version == legacyCertVersion {
+		_, err = message.Write([]byte(c.KeyType))
+		if err != nil {
+			return nil, ErrImpossibleOutOfMemory
+		}
+		_, err = message.Write(c.Certified)
+		if err != nil {
+			return nil, ErrImpossibleOutOfMemory
+		}
+		return message.Bytes(), nil
+	}
+
+	// Every field is either length prefixed or fixed width, so that
+	// bytes may not be moved from one field to another without
+	// invalidating the signatures.
+	for _, v := range [][]byte{[]byte(c.KeyType), c.Certified} {
+		err = binary.Write(message, binary.LittleEndian, uint32(len(v)))
+		if err != nil {
+			return nil, ErrImpossibleOutOfMemory
+		}
+		_, err = message.Write(v)
+		if err != nil {
+			return nil, ErrImpossibleOutOfMemory
+		}
+	}
+	err = message.WriteByte(c.MaxSigners)
 	if err != nil {
 		return nil, ErrImpossibleOutOfMemory
 	}
-	_, err = message.Write(c.Certified)
+	err = binary.Write(message, binary.LittleEndian, c.SignedAt)
 	if err != nil {
 		return nil, ErrImpossibleOutOfMemory
 	}
@@ -145,29 +194,66 @@ func (c *certificate) message() ([]byte, error) {
 }
 
 func (c *certificate) sanityCheck() error {
-	if c.Version != CertVersion {
+	return c.sanityCheckWithClock(systemClock)
+}
+
+func (c *certificate) sanityCheckWithClock(clock epochtime.EpochClock) error {
+	switch c.Version {
+	case CertVersion:
+	case legacyCertVersion:
+		if c.MaxSigners != 0 || c.SignedAt != 0 {
+			return ErrVersionMismatch
+		}
+	default:
 		return ErrVersionMismatch
 	}
-	if time.Unix(c.Expiration, 0).Before(time.Now()) {
+	if time.Unix(c.Expiration, 0).Before(now(clock)) {
 		return ErrCertificateExpired
 	}
 	if len(c.KeyType) == 0 {
 		return ErrInvalidKeyType
 	}
+	if err := checkCertType(c.KeyType); err != nil {
+		return err
+	}
 	if len(c.Certified) == 0 || c.Certified == nil {
 		return ErrInvalidCertified
 	}
+	if c.MaxSigners != 0 && len(c.Signatures) > int(c.MaxSigners) {
+		return ErrMaxSignersReached
+	}
 	return nil
 }
 
+func (c *certificate) isFull() bool {
+	return c.MaxSigners != 0 && len(c.Signatures) >= int(c.MaxSigners)
+}
+
 // Sign uses the given Signer to create a certificate which
 // certifies the given data.
 func Sign(signer Signer, data []byte, expiration int64) ([]byte, error) {
+	return SignWithMaxSigners(signer, data, expiration, 0)
+}
+
+// SignWithMaxSigners is like Sign but the resulting certificate will
+// accept at most maxSigners signatures, including the one made by
+// signer.  A maxSigners of zero means the number of signatures is
+// unlimited.
+func SignWithMaxSigners(signer Signer, data []byte, expiration int64, maxSigners uint8) ([]byte, error) {
+	return sign(signer, data, expiration, maxSigners, time.Now().Unix())
+}
+
+func sign(signer Signer, data []byte, expiration int64, maxSigners uint8, signedAt int64) ([]byte, error) {
 	cert := certificate{
 		Version:    CertVersion,
 		Expiration: expiration,
 		KeyType:    signer.KeyType(),
 		Certified:  data,
+		MaxSigners: maxSigners,
+		SignedAt:   signedAt,
+	}
+	if cert.MaxSigners == 0 && cert.SignedAt == 0 {
+		cert.Version = legacyCertVersion
 	}
 	err := cert.sanityCheck()
 	if err != nil {
@@ -234,6 +320,74 @@ func GetSignature(identity []byte, rawCert []byte) (*Signature, error) {
 	return nil, ErrIdentitySignatureNotFound
 }
 
+// Fingerprint returns the SHA-256 digest of the canonical CBOR encoding of
+// the certificate without its signatures.  The fingerprint is stable across
+// additional signatures being added to the certificate, and is suitable for
+// use as a map key or log tag.
+func Fingerprint(rawCert []byte) ([32]byte, error) {
+	cert := certificate{}
+	err := cbor.Unmarshal(rawCert, &cert)
+	if err != nil {
+		return [32]byte{}, ErrImpossibleDecode
+	}
+	cert.Signatures = nil
+	encMode, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		return [32]byte{}, ErrImpossibleEncode
+	}
+	b, err := encMode.Marshal(&cert)
+	if err != nil {
+		return [32]byte{}, ErrImpossibleEncode
+	}
+	return sha256.Sum256(b), nil
+}
+
+// VerifyFreshness returns nil iff the certificate was signed no more than
+// maxAge ago.  It does not verify any of the certificate's signatures, so
+// SignedAt is only authenticated once the certificate is also verified.
+func VerifyFreshness(rawCert []byte, maxAge time.Duration) error {
+	return VerifyFreshnessWithClock(systemClock, rawCert, maxAge)
+}
+
+// VerifyFreshnessWithClock is VerifyFreshness, using clock as the source of
+// the current time.
+func VerifyFreshnessWithClock(clock epochtime.EpochClock, rawCert []byte, maxAge time.Duration) error {
+	cert := certificate{}
+	err := cbor.Unmarshal(rawCert, &cert)
+	if err != nil {
+		return ErrImpossibleDecode
+	}
+	err = cert.sanityCheckWithClock(clock)
+	if err != nil {
+		return err
+	}
+	if now(clock).Sub(time.Unix(cert.SignedAt, 0)) > maxAge {
+		return ErrCertificateStale
+	}
+	return nil
+}
+
+// StripSignatures returns the certificate with all of its signatures
+// removed.  The certified data is preserved, but the result will fail
+// verification.
+func StripSignatures(rawCert []byte) ([]byte, error) {
+	cert := certificate{}
+	err := cbor.Unmarshal(rawCert, &cert)
+	if err != nil {
+		return nil, ErrImpossibleDecode
+	}
+	err = cert.sanityCheck()
+	if err != nil {
+		return nil, err
+	}
+	cert.Signatures = []Signature{}
+	out, err := cbor.Marshal(&cert)
+	if err != nil {
+		return nil, ErrImpossibleEncode
+	}
+	return out, nil
+}
+
 type byIdentity []Signature
 
 func (d byIdentity) Len() int {
@@ -251,6 +405,13 @@ func (d byIdentity) Less(i, j int) bool {
 // SignMulti uses the given signer to create a signature
 // and appends it to the certificate and returns it.
 func SignMulti(signer Signer, rawCert []byte) ([]byte, error) {
+	return SignMultiBatch([]Signer{signer}, rawCert)
+}
+
+// SignMultiBatch is like calling SignMulti with each of the given signers
+// in turn, but decodes and encodes the certificate only once.  Either all
+// of the signatures are added, or an error is returned.
+func SignMultiBatch(signers []Signer, rawCert []byte) ([]byte, error) {
 	// decode certificate
 	cert := new(certificate)
 	err := cbor.Unmarshal(rawCert, &cert)
@@ -261,8 +422,8 @@ func SignMulti(signer Signer, rawCert []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if signer.KeyType() != cert.KeyType {
-		return nil, ErrKeyTypeMismatch
+	if cert.MaxSigners != 0 && len(cert.Signatures)+len(signers) > int(cert.MaxSigners) {
+		return nil, ErrMaxSignersReached
 	}
 
 	// sign the certificate's message contents
@@ -270,19 +431,23 @@ func SignMulti(signer Signer, rawCert []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	signature := Signature{
-		Identity: signer.Identity(),
-		Payload:  signer.Sign(mesg),
-	}
+	for _, signer := range signers {
+		if signer.KeyType() != cert.KeyType {
+			return nil, ErrKeyTypeMismatch
+		}
+		signature := Signature{
+			Identity: signer.Identity(),
+			Payload:  signer.Sign(mesg),
+		}
 
-	// dedup
-	for _, sig := range cert.Signatures {
-		if bytes.Equal(sig.Identity, signature.Identity) || bytes.Equal(sig.Payload, signature.Payload) {
-			return nil, ErrDuplicateSignature
+		// dedup, including against the signatures added by this batch
+		for _, sig := range cert.Signatures {
+			if bytes.Equal(sig.Identity, signature.Identity) || bytes.Equal(sig.Payload, signature.Payload) {
+				return nil, ErrDuplicateSignature
+			}
 		}
+		cert.Signatures = append(cert.Signatures, signature)
 	}
-
-	cert.Signatures = append(cert.Signatures, signature)
 	sort.Sort(byIdentity(cert.Signatures))
 
 	// serialize certificate
@@ -307,6 +472,9 @@ func AddSignature(verifier Verifier, signature Signature, rawCert []byte) ([]byt
 	if err != nil {
 		return nil, err
 	}
+	if cert.isFull() {
+		return nil, ErrMaxSignersReached
+	}
 
 	// dedup
 	for _, sig := range cert.Signatures {
@@ -320,6 +488,9 @@ func AddSignature(verifier Verifier, signature Signature, rawCert []byte) ([]byt
 	if err != nil {
 		return nil, err
 	}
+	if err = checkMalleability(cert.KeyType, signature.Payload); err != nil {
+		return nil, err
+	}
 	if verifier.Verify(signature.Payload, mesg) {
 		cert.Signatures = append(cert.Signatures, signature)
 		sort.Sort(byIdentity(cert.Signatures))
@@ -337,13 +508,27 @@ func AddSignature(verifier Verifier, signature Signature, rawCert []byte) ([]byt
 // Verify is used to verify one of the signatures attached to the certificate.
 // It returns the certified data if the signature is valid.
 func Verify(verifier Verifier, rawCert []byte) ([]byte, error) {
+	return VerifyWithClock(systemClock, verifier, rawCert)
+}
+
+// VerifyWithClock is Verify, using clock as the source of the current time
+// when checking if the certificate has expired.
+func VerifyWithClock(clock epochtime.EpochClock, verifier Verifier, rawCert []byte) ([]byte, error) {
+	cert, err := verify(clock, verifier, rawCert)
+	if err != nil {
+		return nil, err
+	}
+	return cert.Certified, nil
+}
+
+func verify(clock epochtime.EpochClock, verifier Verifier, rawCert []byte) (*certificate, error) {
 	cert := new(certificate)
 	err := cbor.Unmarshal(rawCert, &cert)
 	if err != nil {
 		return nil, err
 	}
 
-	err = cert.sanityCheck()
+	err = cert.sanityCheckWithClock(clock)
 	if err != nil {
 		return nil, err
 	}
@@ -354,8 +539,11 @@ func Verify(verifier Verifier, rawCert []byte) ([]byte, error) {
 			if err != nil {
 				return nil, err
 			}
+			if err = checkMalleability(cert.KeyType, sig.Payload); err != nil {
+				return nil, err
+			}
 			if verifier.Verify(sig.Payload, mesg) {
-				return cert.Certified, nil
+				return cert, nil
 			}
 			return nil, ErrBadSignature
 		}
@@ -366,10 +554,16 @@ func Verify(verifier Verifier, rawCert []byte) ([]byte, error) {
 // VerifyAll returns the certified data if all of the given verifiers
 // can verify the certificate. Otherwise nil is returned along with an error.
 func VerifyAll(verifiers []Verifier, rawCert []byte) ([]byte, error) {
+	return VerifyAllWithClock(systemClock, verifiers, rawCert)
+}
+
+// VerifyAllWithClock is VerifyAll, using clock as the source of the current
+// time when checking if the certificate has expired.
+func VerifyAllWithClock(clock epochtime.EpochClock, verifiers []Verifier, rawCert []byte) ([]byte, error) {
 	var err error
 	certified := []byte{}
 	for _, verifier := range verifiers {
-		certified, err = Verify(verifier, rawCert)
+		certified, err = VerifyWithClock(clock, verifier, rawCert)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,134 @@
+// cert_prop_test.go - Certificate encoding property tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	propTestIterations  = 1000
+	propShortIterations = 100
+
+	maxPropKeyTypeLen   = 32
+	maxPropCertifiedLen = 1 << 20
+	maxPropSignatures   = 10
+	maxPropIdentityLen  = 64
+	maxPropSignatureLen = 128
+)
+
+// randomCertificate is a certificate with random field values, that is
+// generated by testing/quick.
+type randomCertificate struct {
+	certificate
+}
+
+func randomBytes(r *rand.Rand, minLen, maxLen int) []byte {
+	b := make([]byte, minLen+r.Intn(maxLen-minLen+1))
+	r.Read(b)
+	return b
+}
+
+func randomKeyType(r *rand.Rand) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789-"
+	b := make([]byte, 1+r.Intn(maxPropKeyTypeLen))
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// Generate implements quick.Generator.  Every generated certificate passes
+// sanityCheck.
+func (randomCertificate) Generate(r *rand.Rand, size int) reflect.Value {
+	now := time.Now().Unix()
+	c := certificate{
+		Version:    CertVersion,
+		Expiration: now + 1 + r.Int63n(math.MaxInt64-now),
+		KeyType:    randomKeyType(r),
+		Certified:  randomBytes(r, 1, maxPropCertifiedLen),
+		SignedAt:   r.Int63n(now),
+	}
+	nrSignatures := r.Intn(maxPropSignatures + 1)
+	for i := 0; i < nrSignatures; i++ {
+		c.Signatures = append(c.Signatures, Signature{
+			Identity: randomBytes(r, 0, maxPropIdentityLen),
+			Payload:  randomBytes(r, 0, maxPropSignatureLen),
+		})
+	}
+	if r.Intn(2) == 0 {
+		c.MaxSigners = uint8(nrSignatures + r.Intn(256-nrSignatures))
+	}
+	return reflect.ValueOf(randomCertificate{c})
+}
+
+func TestCertificateEncodingProperties(t *testing.T) {
+	require := require.New(t)
+
+	iterations := propTestIterations
+	if testing.Short() {
+		iterations = propShortIterations
+	}
+	roundTrip := func(rc randomCertificate) bool {
+		c := &rc.certificate
+		rawCert, err := cbor.Marshal(c)
+		if err != nil {
+			t.Logf("Marshal(): %v", err)
+			return false
+		}
+
+		// Decoding is the inverse of encoding.
+		var decoded certificate
+		if err = cbor.Unmarshal(rawCert, &decoded); err != nil {
+			t.Logf("Unmarshal(): %v", err)
+			return false
+		}
+		if !reflect.DeepEqual(c, &decoded) {
+			t.Logf("decoded certificate differs")
+			return false
+		}
+
+		// Re-encoding is idempotent.
+		reencoded, err := cbor.Marshal(&decoded)
+		if err != nil || !reflect.DeepEqual(rawCert, reencoded) {
+			t.Logf("re-encoded certificate differs: %v", err)
+			return false
+		}
+
+		// The accessors agree with the encoded certificate.
+		certified, err := GetCertified(rawCert)
+		if err != nil || !reflect.DeepEqual(c.Certified, certified) {
+			t.Logf("GetCertified(): %v", err)
+			return false
+		}
+		sigs, err := GetSignatures(rawCert)
+		if err != nil || len(sigs) != len(c.Signatures) {
+			t.Logf("GetSignatures(): %v", err)
+			return false
+		}
+		return true
+	}
+	require.NoError(quick.Check(roundTrip, &quick.Config{MaxCount: iterations}))
+}
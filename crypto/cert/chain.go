@@ -0,0 +1,62 @@
+// chain.go - Certificate chains.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+)
+
+var (
+	// ErrEmptyChain is the error returned when verifying an empty
+	// certificate chain.
+	ErrEmptyChain = errors.New("empty certificate chain")
+
+	// ErrBrokenChain is the error returned when a certificate in a chain
+	// is not signed by the key certified by its predecessor.
+	ErrBrokenChain = errors.New("broken certificate chain")
+)
+
+// CertChain is a chain of certificates, ordered from the certificate signed
+// by the root key to the leaf.  Each certificate other than the leaf
+// certifies the Ed25519 public key that signs the next certificate.
+type CertChain [][]byte
+
+// VerifyChain verifies the chain, starting with the first certificate being
+// signed by rootKey, and returns the data certified by the leaf certificate.
+func VerifyChain(chain CertChain, rootKey *eddsa.PublicKey) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, ErrEmptyChain
+	}
+	signer := rootKey
+	for i, rawCert := range chain {
+		certified, err := Verify(signer, rawCert)
+		if err != nil {
+			return nil, fmt.Errorf("%w: certificate %d: %v", ErrBrokenChain, i, err)
+		}
+		if i == len(chain)-1 {
+			return certified, nil
+		}
+		signer = new(eddsa.PublicKey)
+		if err := signer.FromBytes(certified); err != nil {
+			return nil, fmt.Errorf("%w: certificate %d: invalid certified key: %v", ErrBrokenChain, i, err)
+		}
+	}
+	panic("cert: BUG: unreachable")
+}
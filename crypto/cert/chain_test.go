@@ -0,0 +1,67 @@
+// chain_test.go - Certificate chain tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyChain(t *testing.T) {
+	require := require.New(t)
+
+	root, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	intermediate, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	node, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	other, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+
+	expiration := time.Now().AddDate(0, 1, 0).Unix()
+	rootCert, err := Sign(root, intermediate.PublicKey().Bytes(), expiration)
+	require.NoError(err)
+	intermediateCert, err := Sign(intermediate, node.PublicKey().Bytes(), expiration)
+	require.NoError(err)
+	leafCert, err := Sign(node, []byte("leaf payload"), expiration)
+	require.NoError(err)
+
+	chain := CertChain{rootCert, intermediateCert, leafCert}
+	certified, err := VerifyChain(chain, root.PublicKey())
+	require.NoError(err)
+	require.Equal([]byte("leaf payload"), certified)
+
+	// The chain must start at the root key.
+	_, err = VerifyChain(chain, other.PublicKey())
+	require.True(errors.Is(err, ErrBrokenChain), "wrong root: %v", err)
+
+	// A certificate that isn't signed by the key certified by its
+	// predecessor breaks the chain.
+	badCert, err := Sign(other, node.PublicKey().Bytes(), expiration)
+	require.NoError(err)
+	_, err = VerifyChain(CertChain{rootCert, badCert, leafCert}, root.PublicKey())
+	require.True(errors.Is(err, ErrBrokenChain), "broken link: %v", err)
+
+	_, err = VerifyChain(CertChain{}, root.PublicKey())
+	require.Equal(ErrEmptyChain, err)
+}
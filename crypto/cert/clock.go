@@ -0,0 +1,31 @@
+// clock.go - Certificate clock.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+// systemClock is the clock used by the functions that don't take one.
+var systemClock epochtime.EpochClock = new(epochtime.Clock)
+
+// now returns the current time according to clock.
+func now(clock epochtime.EpochClock) time.Time {
+	return epochtime.WallTime(clock)
+}
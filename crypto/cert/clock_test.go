@@ -0,0 +1,71 @@
+// clock_test.go - Certificate clock tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWithClock(t *testing.T) {
+	require := require.New(t)
+
+	signingKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	otherKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	verifiers := []Verifier{signingKey.PublicKey(), otherKey.PublicKey()}
+
+	epoch, _, _ := epochtime.Now()
+	clock := epochtime.NewFakeEpochClock(epoch + 1)
+	signedAt := epochtime.WallTime(clock)
+	expiry := signedAt.Add(24 * time.Hour)
+	rawCert, err := sign(signingKey, []byte("hello"), expiry.Unix(), 0, signedAt.Unix())
+	require.NoError(err, "sign()")
+	rawCert, err = SignMulti(otherKey, rawCert)
+	require.NoError(err, "SignMulti()")
+	require.NoError(VerifyFreshnessWithClock(clock, rawCert, time.Hour))
+
+	// The certificate is valid up to and including its expiry.
+	clock.Advance(expiry.Sub(signedAt) - time.Nanosecond)
+	_, err = VerifyWithClock(clock, signingKey.PublicKey(), rawCert)
+	require.NoError(err, "VerifyWithClock() before expiry")
+	clock.Advance(time.Nanosecond)
+	_, err = VerifyWithClock(clock, signingKey.PublicKey(), rawCert)
+	require.NoError(err, "VerifyWithClock() at expiry")
+	_, err = VerifyAllWithClock(clock, verifiers, rawCert)
+	require.NoError(err, "VerifyAllWithClock() at expiry")
+	require.Equal(ErrCertificateStale, VerifyFreshnessWithClock(clock, rawCert, 24*time.Hour-time.Nanosecond))
+	cache := NewCertCache(1)
+	cache.clock = clock
+	_, err = cache.Verify(signingKey.PublicKey(), rawCert)
+	require.NoError(err, "CertCache.Verify() at expiry")
+
+	// And expired immediately after, including cached verification results.
+	clock.Advance(time.Nanosecond)
+	_, err = VerifyWithClock(clock, signingKey.PublicKey(), rawCert)
+	require.Equal(ErrCertificateExpired, err, "VerifyWithClock() after expiry")
+	_, err = VerifyAllWithClock(clock, verifiers, rawCert)
+	require.Equal(ErrCertificateExpired, err, "VerifyAllWithClock() after expiry")
+	_, err = cache.Verify(signingKey.PublicKey(), rawCert)
+	require.Equal(ErrCertificateExpired, err, "CertCache.Verify() after expiry")
+}
@@ -0,0 +1,121 @@
+// composite.go - Composite threshold signer.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"bytes"
+)
+
+// ThresholdSignaturePayload is a message, along with the partial signatures
+// made by each of a CompositeSigner's signers and the number of them that
+// must be valid.
+type ThresholdSignaturePayload struct {
+	// Message is the signed message.
+	Message []byte
+
+	// Threshold is the number of valid signatures required.
+	Threshold int
+
+	// Signatures are the partial signatures.
+	Signatures []Signature
+}
+
+// CompositeSigner signs messages with several signers, such as the shares
+// of a key split across multiple HSMs.
+type CompositeSigner struct {
+	signers   []Signer
+	threshold int
+}
+
+// NewCompositeSigner creates a CompositeSigner, whose signatures are only
+// valid if at least threshold of signers' partial signatures are.
+func NewCompositeSigner(signers []Signer, threshold int) (*CompositeSigner, error) {
+	if threshold <= 0 || threshold > len(signers) {
+		return nil, ErrInvalidThreshold
+	}
+	for i, s := range signers {
+		if s.KeyType() != signers[0].KeyType() {
+			return nil, ErrKeyTypeMismatch
+		}
+		for _, other := range signers[:i] {
+			if bytes.Equal(s.Identity(), other.Identity()) {
+				return nil, ErrDuplicateSignature
+			}
+		}
+	}
+	return &CompositeSigner{
+		signers:   append([]Signer{}, signers...),
+		threshold: threshold,
+	}, nil
+}
+
+// Sign signs msg with every signer, and returns the payload carrying all of
+// the partial signatures.
+func (c *CompositeSigner) Sign(msg []byte) ThresholdSignaturePayload {
+	payload := ThresholdSignaturePayload{
+		Message:    append([]byte{}, msg...),
+		Threshold:  c.threshold,
+		Signatures: make([]Signature, 0, len(c.signers)),
+	}
+	for _, s := range c.signers {
+		payload.Signatures = append(payload.Signatures, Signature{
+			Identity: s.Identity(),
+			Payload:  s.Sign(msg),
+		})
+	}
+	return payload
+}
+
+// VerifyComposite returns nil iff at least threshold of the partial
+// signatures are valid signatures of payload.Message made by distinct
+// verifiers.  The threshold is the verifier's policy, so a payload claiming
+// a lower threshold is rejected, and one claiming a higher threshold must
+// meet it.  Verifiers that share an identity are only counted once.
+func VerifyComposite(payload ThresholdSignaturePayload, verifiers []Verifier, threshold int) error {
+	if threshold <= 0 || payload.Threshold < threshold {
+		return ErrInvalidThreshold
+	}
+	threshold = payload.Threshold
+
+	count, distinct := 0, 0
+	for i, verifier := range verifiers {
+		isDuplicate := false
+		for _, other := range verifiers[:i] {
+			if bytes.Equal(verifier.Identity(), other.Identity()) {
+				isDuplicate = true
+				break
+			}
+		}
+		if isDuplicate {
+			continue
+		}
+		distinct++
+		for _, sig := range payload.Signatures {
+			if bytes.Equal(sig.Identity, verifier.Identity()) && verifier.Verify(sig.Payload, payload.Message) {
+				count++
+				break
+			}
+		}
+	}
+	if threshold > distinct {
+		return ErrInvalidThreshold
+	}
+	if count < threshold {
+		return ErrThresholdNotMet
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// composite_test.go - Composite threshold signer tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositeSigner(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		n         = 5
+		threshold = 3
+	)
+	signers := make([]Signer, 0, n)
+	verifiers := make([]Verifier, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := eddsa.NewKeypair(rand.Reader)
+		require.NoError(err)
+		signers = append(signers, key)
+		verifiers = append(verifiers, key.PublicKey())
+	}
+
+	_, err := NewCompositeSigner(signers, n+1)
+	require.Equal(ErrInvalidThreshold, err)
+	_, err = NewCompositeSigner(append(signers[:1:1], signers[0]), 1)
+	require.Equal(ErrDuplicateSignature, err)
+
+	c, err := NewCompositeSigner(signers, threshold)
+	require.NoError(err, "NewCompositeSigner()")
+	msg := []byte("hello world")
+	payload := c.Sign(msg)
+	require.Equal(msg, payload.Message)
+	require.Equal(threshold, payload.Threshold)
+	require.Len(payload.Signatures, n)
+	require.NoError(VerifyComposite(payload, verifiers, threshold), "all signatures")
+
+	// Three of the five partial signatures suffice.
+	combined := ThresholdSignaturePayload{
+		Message:    msg,
+		Threshold:  threshold,
+		Signatures: payload.Signatures[1:4],
+	}
+	require.NoError(VerifyComposite(combined, verifiers, threshold), "3 signatures")
+
+	// Two do not, and neither do three if one is invalid or duplicated.
+	combined.Signatures = payload.Signatures[:2]
+	require.Equal(ErrThresholdNotMet, VerifyComposite(combined, verifiers, threshold), "2 signatures")
+	bad := payload.Signatures[2]
+	bad.Payload = append([]byte{}, bad.Payload...)
+	bad.Payload[0] ^= 0xff
+	combined.Signatures = []Signature{payload.Signatures[0], payload.Signatures[1], bad}
+	require.Equal(ErrThresholdNotMet, VerifyComposite(combined, verifiers, threshold), "invalid signature")
+	combined.Signatures = []Signature{payload.Signatures[0], payload.Signatures[1], payload.Signatures[1]}
+	require.Equal(ErrThresholdNotMet, VerifyComposite(combined, verifiers, threshold), "duplicate signature")
+
+	// The signatures must be over the message.
+	combined = payload
+	combined.Message = []byte("goodbye world")
+	require.Equal(ErrThresholdNotMet, VerifyComposite(combined, verifiers, threshold), "altered message")
+
+	// The threshold can't exceed the number of verifiers.
+	combined = payload
+	combined.Threshold = n + 1
+	require.Equal(ErrInvalidThreshold, VerifyComposite(combined, verifiers, threshold), "excessive threshold")
+	require.Equal(ErrInvalidThreshold, VerifyComposite(payload, verifiers, n+1), "excessive required threshold")
+	require.Equal(ErrInvalidThreshold, VerifyComposite(payload, verifiers, 0), "zero required threshold")
+
+	// The payload can't lower the verifier's threshold.
+	combined = payload
+	combined.Threshold = 1
+	combined.Signatures = payload.Signatures[:1]
+	require.Equal(ErrInvalidThreshold, VerifyComposite(combined, verifiers, threshold), "lowered threshold")
+
+	// But it may raise it.
+	combined = payload
+	combined.Threshold = threshold + 1
+	combined.Signatures = payload.Signatures[:threshold]
+	require.Equal(ErrThresholdNotMet, VerifyComposite(combined, verifiers, threshold), "raised threshold")
+	combined.Signatures = payload.Signatures[:threshold+1]
+	require.NoError(VerifyComposite(combined, verifiers, threshold), "raised threshold")
+
+	// Repeating a verifier doesn't count its signature twice.
+	combined = payload
+	combined.Signatures = payload.Signatures[:2]
+	repeated := append([]Verifier{verifiers[0], verifiers[0], verifiers[1]}, verifiers[1:]...)
+	require.Equal(ErrThresholdNotMet, VerifyComposite(combined, repeated, threshold), "repeated verifier")
+	require.Equal(ErrInvalidThreshold, VerifyComposite(payload, []Verifier{verifiers[0], verifiers[0], verifiers[0]}, threshold), "repeated verifiers")
+}
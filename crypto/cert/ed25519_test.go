@@ -18,9 +18,12 @@ package cert
 
 import (
 	"bytes"
+	"encoding/binary"
+	"math/big"
 	"testing"
 	"time"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/katzenpost/core/crypto/eddsa"
 	"github.com/katzenpost/core/crypto/rand"
 	"github.com/stretchr/testify/assert"
@@ -295,3 +298,308 @@ func TestEd25519AddSignature(t *testing.T) {
 
 	assert.Equal(certificate2, certificate3)
 }
+
+func TestEd25519MaxSigners(t *testing.T) {
+	assert := assert.New(t)
+
+	ephemeralPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+
+	signingPrivKey1, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	signingPrivKey2, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	signingPrivKey3, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	signingPrivKey4, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+
+	rawCert, err := SignWithMaxSigners(signingPrivKey1, ephemeralPrivKey.PublicKey().Bytes(), expiration, 3)
+	assert.NoError(err)
+
+	rawCert, err = SignMulti(signingPrivKey2, rawCert)
+	assert.NoError(err)
+
+	rawCert, err = SignMulti(signingPrivKey3, rawCert)
+	assert.NoError(err)
+
+	full, err := SignMulti(signingPrivKey4, rawCert)
+	assert.Equal(ErrMaxSignersReached, err)
+	assert.Nil(full)
+
+	sig := Signature{
+		Identity: signingPrivKey4.Identity(),
+		Payload:  []byte("not checked before the signer limit"),
+	}
+	full, err = AddSignature(signingPrivKey4.PublicKey(), sig, rawCert)
+	assert.Equal(ErrMaxSignersReached, err)
+	assert.Nil(full)
+
+	// MaxSigners must survive serialization and remain covered by the signatures.
+	decoded := new(certificate)
+	err = cbor.Unmarshal(rawCert, decoded)
+	assert.NoError(err)
+	assert.Equal(uint8(3), decoded.MaxSigners)
+	assert.Len(decoded.Signatures, 3)
+
+	verifiers := []Verifier{signingPrivKey1.PublicKey(), signingPrivKey2.PublicKey(), signingPrivKey3.PublicKey()}
+	mesg, err := VerifyAll(verifiers, rawCert)
+	assert.NoError(err)
+	assert.Equal(ephemeralPrivKey.PublicKey().Bytes(), mesg)
+
+	decoded.MaxSigners = 0
+	stripped, err := cbor.Marshal(decoded)
+	assert.NoError(err)
+	_, err = Verify(signingPrivKey1.PublicKey(), stripped)
+	assert.Equal(ErrBadSignature, err)
+
+	// Moving the trailing fields into the certified data, in either
+	// certificate format, must not preserve the signatures.
+	decoded.MaxSigners = 3
+	var trailer [9]byte
+	trailer[0] = decoded.MaxSigners
+	binary.LittleEndian.PutUint64(trailer[1:], uint64(decoded.SignedAt))
+	for _, version := range []uint32{CertVersion, legacyCertVersion} {
+		moved := *decoded
+		moved.Version = version
+		moved.Certified = append(append([]byte{}, decoded.Certified...), trailer[:]...)
+		moved.MaxSigners = 0
+		moved.SignedAt = 0
+		rawMoved, err := cbor.Marshal(&moved)
+		assert.NoError(err)
+		_, err = Verify(signingPrivKey1.PublicKey(), rawMoved)
+		assert.Equal(ErrBadSignature, err, "version %d", version)
+	}
+
+	// Legacy certificates may not carry the newer fields.
+	decoded.Version = legacyCertVersion
+	legacy, err := cbor.Marshal(decoded)
+	assert.NoError(err)
+	_, err = Verify(signingPrivKey1.PublicKey(), legacy)
+	assert.Equal(ErrVersionMismatch, err)
+}
+
+func TestEd25519UnlimitedSigners(t *testing.T) {
+	assert := assert.New(t)
+
+	ephemeralPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+
+	signingPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	certificate, err := Sign(signingPrivKey, ephemeralPrivKey.PublicKey().Bytes(), expiration)
+	assert.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		signingPrivKey, err = eddsa.NewKeypair(rand.Reader)
+		assert.NoError(err)
+		certificate, err = SignMulti(signingPrivKey, certificate)
+		assert.NoError(err)
+	}
+
+	sigs, err := GetSignatures(certificate)
+	assert.NoError(err)
+	assert.Len(sigs, 6)
+}
+
+func TestEd25519Fingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	ephemeralPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	signingPrivKey1, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	signingPrivKey2, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+
+	certificate, err := Sign(signingPrivKey1, ephemeralPrivKey.PublicKey().Bytes(), expiration)
+	assert.NoError(err)
+	fingerprint, err := Fingerprint(certificate)
+	assert.NoError(err)
+
+	// Additional signatures do not change the fingerprint.
+	certificate2, err := SignMulti(signingPrivKey2, certificate)
+	assert.NoError(err)
+	fingerprint2, err := Fingerprint(certificate2)
+	assert.NoError(err)
+	assert.Equal(fingerprint, fingerprint2)
+
+	// The same payload signed by a different key has the same fingerprint.
+	certificate3, err := Sign(signingPrivKey2, ephemeralPrivKey.PublicKey().Bytes(), expiration)
+	assert.NoError(err)
+	fingerprint3, err := Fingerprint(certificate3)
+	assert.NoError(err)
+	assert.Equal(fingerprint, fingerprint3)
+
+	// A different payload has a different fingerprint.
+	certificate4, err := Sign(signingPrivKey1, signingPrivKey2.PublicKey().Bytes(), expiration)
+	assert.NoError(err)
+	fingerprint4, err := Fingerprint(certificate4)
+	assert.NoError(err)
+	assert.NotEqual(fingerprint, fingerprint4)
+
+	_, err = Fingerprint([]byte("not a certificate"))
+	assert.Equal(ErrImpossibleDecode, err)
+}
+
+func TestEd25519SignedAt(t *testing.T) {
+	assert := assert.New(t)
+
+	signingKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	expiration := time.Now().AddDate(0, 1, 0).Unix()
+
+	rawCert, err := Sign(signingKey, []byte("hello"), expiration)
+	assert.NoError(err)
+	assert.NoError(VerifyFreshness(rawCert, time.Minute))
+	_, err = Verify(signingKey.PublicKey(), rawCert)
+	assert.NoError(err)
+
+	// A certificate signed long ago is stale.
+	oldCert, err := sign(signingKey, []byte("hello"), expiration, 0, time.Now().AddDate(-1, 0, 0).Unix())
+	assert.NoError(err)
+	assert.Equal(ErrCertificateStale, VerifyFreshness(oldCert, time.Hour))
+	assert.NoError(VerifyFreshness(oldCert, 2*365*24*time.Hour))
+
+	// SignedAt is covered by the signature.
+	cert := new(certificate)
+	assert.NoError(cbor.Unmarshal(oldCert, cert))
+	cert.SignedAt = time.Now().Unix()
+	forged, err := cbor.Marshal(cert)
+	assert.NoError(err)
+	assert.NoError(VerifyFreshness(forged, time.Minute))
+	_, err = Verify(signingKey.PublicKey(), forged)
+	assert.Equal(ErrBadSignature, err)
+
+	// Nor can SignedAt be moved into, or taken from, the certified data.
+	assert.NoError(cbor.Unmarshal(rawCert, cert))
+	var signedAt [8]byte
+	binary.LittleEndian.PutUint64(signedAt[:], uint64(cert.SignedAt))
+	cert.Certified = append(cert.Certified, signedAt[:]...)
+	cert.SignedAt = 0
+	forged, err = cbor.Marshal(cert)
+	assert.NoError(err)
+	_, err = Verify(signingKey.PublicKey(), forged)
+	assert.Equal(ErrBadSignature, err)
+
+	cert.Certified = cert.Certified[:len(cert.Certified)-len(signedAt)-1]
+	cert.SignedAt = time.Now().Unix()
+	forged, err = cbor.Marshal(cert)
+	assert.NoError(err)
+	_, err = Verify(signingKey.PublicKey(), forged)
+	assert.Equal(ErrBadSignature, err)
+}
+
+func TestEd25519MalleableSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	signingKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+	expiration := time.Now().AddDate(0, 1, 0).Unix()
+	rawCert, err := Sign(signingKey, []byte("hello"), expiration)
+	assert.NoError(err)
+	_, err = Verify(signingKey.PublicKey(), rawCert)
+	assert.NoError(err)
+
+	// Replace S with S + l, which is equivalent modulo the group order.
+	cert := new(certificate)
+	assert.NoError(cbor.Unmarshal(rawCert, cert))
+	sig := cert.Signatures[0].Payload
+	l, ok := new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+	assert.True(ok)
+	s := new(big.Int).SetBytes(reverseBytes(sig[32:]))
+	s.Add(s, l)
+	sPlusL := make([]byte, 32)
+	copy(sPlusL[32-len(s.Bytes()):], s.Bytes())
+	malleable := append(append([]byte{}, sig[:32]...), reverseBytes(sPlusL)...)
+	cert.Signatures[0].Payload = malleable
+	forged, err := cbor.Marshal(cert)
+	assert.NoError(err)
+	_, err = Verify(signingKey.PublicKey(), forged)
+	assert.Equal(ErrMalleableSignature, err)
+
+	// Nor may the malleable signature be added to a certificate.
+	cert.Signatures = nil
+	unsigned, err := cbor.Marshal(cert)
+	assert.NoError(err)
+	_, err = AddSignature(signingKey.PublicKey(), Signature{Identity: signingKey.Identity(), Payload: malleable}, unsigned)
+	assert.Equal(ErrMalleableSignature, err)
+	signed, err := AddSignature(signingKey.PublicKey(), Signature{Identity: signingKey.Identity(), Payload: sig}, unsigned)
+	assert.NoError(err)
+	_, err = Verify(signingKey.PublicKey(), signed)
+	assert.NoError(err)
+}
+
+func reverseBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, v := range b {
+		r[len(b)-1-i] = v
+	}
+	return r
+}
+
+func TestEd25519SignMultiBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	ephemeralPrivKey, err := eddsa.NewKeypair(rand.Reader)
+	assert.NoError(err)
+
+	signingPrivKeys := make([]*eddsa.PrivateKey, 4)
+	for i := range signingPrivKeys {
+		signingPrivKeys[i], err = eddsa.NewKeypair(rand.Reader)
+		assert.NoError(err)
+	}
+	k0, k1, k2, k3 := signingPrivKeys[0], signingPrivKeys[1], signingPrivKeys[2], signingPrivKeys[3]
+
+	// expiration in six months
+	expiration := time.Now().AddDate(0, 6, 0).Unix()
+
+	certificate, err := Sign(k0, ephemeralPrivKey.PublicKey().Bytes(), expiration)
+	assert.NoError(err)
+
+	sequential, err := SignMulti(k1, certificate)
+	assert.NoError(err)
+	sequential, err = SignMulti(k2, sequential)
+	assert.NoError(err)
+	sequential, err = SignMulti(k3, sequential)
+	assert.NoError(err)
+
+	batch, err := SignMultiBatch([]Signer{k1, k2, k3}, certificate)
+	assert.NoError(err)
+	assert.Equal(sequential, batch)
+
+	batch, err = SignMultiBatch([]Signer{k3, k1, k2}, certificate)
+	assert.NoError(err)
+	assert.Equal(sequential, batch)
+
+	for _, k := range signingPrivKeys {
+		_, err = Verify(k.PublicKey(), batch)
+		assert.NoError(err)
+	}
+
+	// Duplicates, within the batch or with the existing signatures, are
+	// rejected.
+	_, err = SignMultiBatch([]Signer{k1, k2, k1}, certificate)
+	assert.Equal(ErrDuplicateSignature, err)
+	_, err = SignMultiBatch([]Signer{k1, k0}, certificate)
+	assert.Equal(ErrDuplicateSignature, err)
+
+	// The batch must fit within the maximum number of signers.
+	certificate, err = SignWithMaxSigners(k0, ephemeralPrivKey.PublicKey().Bytes(), expiration, 3)
+	assert.NoError(err)
+	_, err = SignMultiBatch([]Signer{k1, k2, k3}, certificate)
+	assert.Equal(ErrMaxSignersReached, err)
+	batch, err = SignMultiBatch([]Signer{k1, k2}, certificate)
+	assert.NoError(err)
+	_, err = Verify(k2.PublicKey(), batch)
+	assert.NoError(err)
+}
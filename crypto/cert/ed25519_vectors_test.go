@@ -76,7 +76,8 @@ func TestEd25519SingleSignatureCertificateVectors(t *testing.T) {
 		assert.NoError(err)
 		signingKey := new(eddsa.PrivateKey)
 		signingKey.FromBytes(signingKeyRaw)
-		certificate, err := Sign(signingKey, toSign, expiration)
+		// The vectors predate SignedAt, so it is omitted.
+		certificate, err := sign(signingKey, toSign, expiration, 0, 0)
 		assert.NoError(err)
 		payload, err := hex.DecodeString(test.want.payload)
 		assert.NoError(err)
@@ -149,7 +150,8 @@ func TestEd25519MultipleSignatureCertificateVectors(t *testing.T) {
 
 		toSign, err := hex.DecodeString(test.in.toSign)
 		assert.NoError(err)
-		certificate, err := Sign(sigKeys[0], toSign, expiration)
+		// The vectors predate SignedAt, so it is omitted.
+		certificate, err := sign(sigKeys[0], toSign, expiration, 0, 0)
 		assert.NoError(err)
 		for _, signingKey := range sigKeys[1:] {
 			certificate, err = SignMulti(signingKey, certificate)
@@ -0,0 +1,74 @@
+// edwards25519.go - edwards25519 group helpers.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"crypto/ed25519"
+
+	"github.com/katzenpost/core/crypto/edwards25519"
+)
+
+// checkMalleability returns ErrMalleableSignature iff sig is an Ed25519
+// signature whose S scalar is not reduced modulo the group order, as such a
+// signature is one of several valid encodings of the same signature.
+func checkMalleability(keyType string, sig []byte) error {
+	if keyType != "ed25519" || len(sig) != ed25519.SignatureSize {
+		return nil
+	}
+	var s [32]byte
+	copy(s[:], sig[32:])
+	if !edwards25519.ScMinimal(&s) {
+		return ErrMalleableSignature
+	}
+	return nil
+}
+
+// The group arithmetic here is only ever applied to public values (signatures
+// and public keys) when aggregating and verifying signatures, and is
+// therefore NOT constant time.
+
+// geDouble sets r = 2p.
+func geDouble(r, p *edwards25519.ExtendedGroupElement) {
+	var sum edwards25519.CompletedGroupElement
+	p.Double(&sum)
+	sum.ToExtended(r)
+}
+
+// geScalarMultVartime sets r = [a]p.
+func geScalarMultVartime(r *edwards25519.ExtendedGroupElement, a *[32]byte, p *edwards25519.ExtendedGroupElement) {
+	var acc edwards25519.ExtendedGroupElement
+	acc.Zero()
+	for i := 255; i >= 0; i-- {
+		geDouble(&acc, &acc)
+		if (a[i/8]>>uint(i%8))&1 == 1 {
+			edwards25519.GeAddExtended(&acc, &acc, p)
+		}
+	}
+	*r = acc
+}
+
+// geIsSmallOrder returns true iff [8]p is the identity element.
+func geIsSmallOrder(p *edwards25519.ExtendedGroupElement) bool {
+	var r edwards25519.ExtendedGroupElement
+	geDouble(&r, p)
+	geDouble(&r, &r)
+	geDouble(&r, &r)
+
+	var b [32]byte
+	r.ToBytes(&b)
+	return b == [32]byte{1}
+}
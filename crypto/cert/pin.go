@@ -0,0 +1,96 @@
+// pin.go - Certificate pinning.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/crypto/eddsa"
+)
+
+// PinMode is the policy a PinRegistry applies to a certificate's signers.
+type PinMode int
+
+const (
+	// PinModeStrict requires that every signer of a certificate is pinned.
+	PinModeStrict PinMode = iota
+
+	// PinModeAny requires that at least one signer of a certificate is
+	// pinned.
+	PinModeAny
+)
+
+// PinRegistry verifies certificates against a set of pinned signing keys.
+// It is safe for concurrent use.
+type PinRegistry struct {
+	pins map[[eddsa.PublicKeySize]byte]*eddsa.PublicKey
+	mode PinMode
+}
+
+// VerifyPinned decodes the certificate and checks its signers against the
+// pinned keys according to the registry's PinMode.  It returns true iff the
+// certificate satisfies the pinning policy and every signature made by a
+// pinned key is valid.  A certificate that is merely not signed by the
+// required pinned keys returns false with a nil error.
+func (r *PinRegistry) VerifyPinned(rawCert []byte) (bool, error) {
+	cert := new(certificate)
+	err := cbor.Unmarshal(rawCert, cert)
+	if err != nil {
+		return false, ErrImpossibleDecode
+	}
+	err = cert.sanityCheck()
+	if err != nil {
+		return false, err
+	}
+	mesg, err := cert.message()
+	if err != nil {
+		return false, err
+	}
+
+	nrPinned := 0
+	for _, sig := range cert.Signatures {
+		var id [eddsa.PublicKeySize]byte
+		if len(sig.Identity) != len(id) {
+			return false, ErrBadSignature
+		}
+		copy(id[:], sig.Identity)
+		pin, ok := r.pins[id]
+		if !ok {
+			if r.mode == PinModeStrict {
+				return false, nil
+			}
+			continue
+		}
+		if !pin.Verify(sig.Payload, mesg) {
+			return false, ErrBadSignature
+		}
+		nrPinned++
+	}
+	return nrPinned > 0, nil
+}
+
+// NewPinRegistry returns a new PinRegistry pinning the given keys, with the
+// given PinMode.
+func NewPinRegistry(pins []*eddsa.PublicKey, mode PinMode) *PinRegistry {
+	r := &PinRegistry{
+		pins: make(map[[eddsa.PublicKeySize]byte]*eddsa.PublicKey),
+		mode: mode,
+	}
+	for _, pin := range pins {
+		r.pins[pin.ByteArray()] = pin
+	}
+	return r
+}
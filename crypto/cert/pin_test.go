@@ -0,0 +1,81 @@
+// pin_test.go - Certificate pinning tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinRegistry(t *testing.T) {
+	require := require.New(t)
+
+	pinned, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	unpinned, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	ephemeral, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+
+	expiration := time.Now().AddDate(0, 1, 0).Unix()
+	pinnedOnly, err := Sign(pinned, ephemeral.PublicKey().Bytes(), expiration)
+	require.NoError(err)
+	unpinnedOnly, err := Sign(unpinned, ephemeral.PublicKey().Bytes(), expiration)
+	require.NoError(err)
+	both, err := SignMulti(pinned, unpinnedOnly)
+	require.NoError(err)
+
+	strict := NewPinRegistry([]*eddsa.PublicKey{pinned.PublicKey()}, PinModeStrict)
+	ok, err := strict.VerifyPinned(pinnedOnly)
+	require.NoError(err)
+	require.True(ok, "strict: pinned signer")
+	ok, err = strict.VerifyPinned(unpinnedOnly)
+	require.NoError(err)
+	require.False(ok, "strict: non-pinned signer")
+	ok, err = strict.VerifyPinned(both)
+	require.NoError(err)
+	require.False(ok, "strict: pinned and non-pinned signers")
+
+	anyPins := NewPinRegistry([]*eddsa.PublicKey{pinned.PublicKey()}, PinModeAny)
+	ok, err = anyPins.VerifyPinned(pinnedOnly)
+	require.NoError(err)
+	require.True(ok, "any: pinned signer")
+	ok, err = anyPins.VerifyPinned(unpinnedOnly)
+	require.NoError(err)
+	require.False(ok, "any: non-pinned signer")
+	ok, err = anyPins.VerifyPinned(both)
+	require.NoError(err)
+	require.True(ok, "any: pinned and non-pinned signers")
+
+	// A pinned identity with an invalid signature is an error.
+	cert := new(certificate)
+	require.NoError(cbor.Unmarshal(pinnedOnly, cert))
+	cert.Signatures[0].Payload[0] ^= 0xff
+	forged, err := cbor.Marshal(cert)
+	require.NoError(err)
+	ok, err = anyPins.VerifyPinned(forged)
+	require.Equal(ErrBadSignature, err)
+	require.False(ok)
+
+	_, err = strict.VerifyPinned([]byte("not a certificate"))
+	require.Equal(ErrImpossibleDecode, err)
+}
@@ -0,0 +1,124 @@
+// stream.go - Streaming certificate signature decoding.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	cborMajorArray      = 4 << 5
+	cborNull            = 0xf6
+	cborBreak           = 0xff
+	cborIndefiniteArray = cborMajorArray | 31
+)
+
+// streamingCertificate is a certificate with the signatures left encoded.
+type streamingCertificate struct {
+	Version    uint32
+	Expiration int64
+	KeyType    string
+	Certified  []byte
+	Signatures cbor.RawMessage
+	MaxSigners uint8 `cbor:",omitempty"`
+	SignedAt   int64 `cbor:",omitempty"`
+}
+
+// DecodeSignaturesStream calls fn with each of the certificate's
+// signatures in turn, decoding them one at a time rather than into a
+// slice.  Decoding stops at the first error returned by fn, which is
+// returned.
+func DecodeSignaturesStream(rawCert []byte, fn func(sig Signature) error) error {
+	sc := new(streamingCertificate)
+	if err := cbor.Unmarshal(rawCert, sc); err != nil {
+		return ErrImpossibleDecode
+	}
+	cert := &certificate{
+		Version:    sc.Version,
+		Expiration: sc.Expiration,
+		KeyType:    sc.KeyType,
+		Certified:  sc.Certified,
+		MaxSigners: sc.MaxSigners,
+		SignedAt:   sc.SignedAt,
+	}
+	if err := cert.sanityCheck(); err != nil {
+		return err
+	}
+
+	b := []byte(sc.Signatures)
+	if len(b) == 0 || (len(b) == 1 && b[0] == cborNull) {
+		return nil
+	}
+	n, hdrLen, indefinite, err := parseArrayHeader(b)
+	if err != nil {
+		return err
+	}
+	if !indefinite && cert.MaxSigners != 0 && n > uint64(cert.MaxSigners) {
+		return ErrMaxSignersReached
+	}
+	body := b[hdrLen:]
+	dec := cbor.NewDecoder(bytes.NewReader(body))
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite {
+			off := dec.NumBytesRead()
+			if off >= len(body) {
+				return ErrImpossibleDecode
+			}
+			if body[off] == cborBreak {
+				return nil
+			}
+		}
+		var sig Signature
+		if err := dec.Decode(&sig); err != nil {
+			return ErrImpossibleDecode
+		}
+		if err := fn(sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseArrayHeader parses the header of the CBOR array b, returning the
+// number of elements, the length of the header, and if the array is of
+// indefinite length.
+func parseArrayHeader(b []byte) (uint64, int, bool, error) {
+	if b[0] == cborIndefiniteArray {
+		return 0, 1, true, nil
+	}
+	if b[0]&0xe0 != cborMajorArray {
+		return 0, 0, false, ErrImpossibleDecode
+	}
+	info := b[0] & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), 1, false, nil
+	case info <= 27:
+		l := 1 << (info - 24)
+		if len(b) < 1+l {
+			return 0, 0, false, ErrImpossibleDecode
+		}
+		var tmp [8]byte
+		copy(tmp[8-l:], b[1:1+l])
+		return binary.BigEndian.Uint64(tmp[:]), 1 + l, false, nil
+	default:
+		return 0, 0, false, ErrImpossibleDecode
+	}
+}
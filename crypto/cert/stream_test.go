@@ -0,0 +1,90 @@
+// stream_test.go - Streaming certificate signature decoding tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSignaturesStream(t *testing.T) {
+	require := require.New(t)
+
+	const nrSignatures = 1000
+	cert := &certificate{
+		Version:    CertVersion,
+		Expiration: time.Now().AddDate(0, 1, 0).Unix(),
+		KeyType:    "ed25519",
+		Certified:  []byte("hello"),
+	}
+	mesg, err := cert.message()
+	require.NoError(err)
+	keys := make(map[string]*eddsa.PublicKey)
+	for i := 0; i < nrSignatures; i++ {
+		k, err := eddsa.NewKeypair(rand.Reader)
+		require.NoError(err)
+		keys[string(k.Identity())] = k.PublicKey()
+		cert.Signatures = append(cert.Signatures, Signature{
+			Identity: k.Identity(),
+			Payload:  k.Sign(mesg),
+		})
+	}
+	rawCert, err := cbor.Marshal(cert)
+	require.NoError(err)
+
+	calls := 0
+	err = DecodeSignaturesStream(rawCert, func(sig Signature) error {
+		calls++
+		k, ok := keys[string(sig.Identity)]
+		require.True(ok, "unknown signer")
+		require.True(k.Verify(sig.Payload, mesg), "bad signature")
+		return nil
+	})
+	require.NoError(err)
+	require.Equal(nrSignatures, calls)
+
+	// Returning an error stops the decoding.
+	errStop := errors.New("stop")
+	calls = 0
+	err = DecodeSignaturesStream(rawCert, func(sig Signature) error {
+		calls++
+		if calls == 10 {
+			return errStop
+		}
+		return nil
+	})
+	require.Equal(errStop, err)
+	require.Equal(10, calls)
+
+	// A certificate without signatures calls fn zero times.
+	signer, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	unsigned, err := Sign(signer, []byte("hello"), cert.Expiration)
+	require.NoError(err)
+	unsigned, err = StripSignatures(unsigned)
+	require.NoError(err)
+	err = DecodeSignaturesStream(unsigned, func(sig Signature) error {
+		return errors.New("unexpected signature")
+	})
+	require.NoError(err)
+}
@@ -0,0 +1,66 @@
+// types.go - Certificate key type registry.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownCertType indicates that strict type checking is enabled and the
+// certificate's key type has not been registered.
+var ErrUnknownCertType = errors.New("unknown certificate key type")
+
+var (
+	certTypes   sync.Map
+	strictTypes uint32
+)
+
+func init() {
+	RegisterCertType("ed25519")
+}
+
+// RegisterCertType registers name as a known certificate key type.
+func RegisterCertType(name string) {
+	certTypes.Store(name, struct{}{})
+}
+
+// IsRegisteredType returns true iff name is a registered certificate key
+// type.
+func IsRegisteredType(name string) bool {
+	_, ok := certTypes.Load(name)
+	return ok
+}
+
+// SetStrictCertTypes enables or disables strict key type checking.  When
+// enabled, verifying a certificate whose key type has not been registered
+// with RegisterCertType fails with ErrUnknownCertType.
+func SetStrictCertTypes(enabled bool) {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&strictTypes, v)
+}
+
+func checkCertType(name string) error {
+	if atomic.LoadUint32(&strictTypes) == 1 && !IsRegisteredType(name) {
+		return ErrUnknownCertType
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// types_test.go - Certificate key type registry tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+type typedSigner struct {
+	*eddsa.PrivateKey
+	keyType string
+}
+
+func (s *typedSigner) KeyType() string {
+	return s.keyType
+}
+
+func TestCertTypeRegistry(t *testing.T) {
+	require := require.New(t)
+
+	require.True(IsRegisteredType("ed25519"), "ed25519 is pre-registered")
+	require.False(IsRegisteredType("test-type"), "test-type is not registered")
+
+	key, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	signer := &typedSigner{PrivateKey: key, keyType: "test-type"}
+	expiration := time.Now().AddDate(0, 1, 0).Unix()
+	rawCert, err := Sign(signer, []byte("hello"), expiration)
+	require.NoError(err)
+
+	// Unknown types pass unless strict checking is enabled.
+	_, err = Verify(key.PublicKey(), rawCert)
+	require.NoError(err)
+
+	SetStrictCertTypes(true)
+	defer SetStrictCertTypes(false)
+	_, err = Verify(key.PublicKey(), rawCert)
+	require.Equal(ErrUnknownCertType, err)
+
+	RegisterCertType("test-type")
+	require.True(IsRegisteredType("test-type"), "test-type is registered")
+	certified, err := Verify(key.PublicKey(), rawCert)
+	require.NoError(err)
+	require.Equal([]byte("hello"), certified)
+}
@@ -18,6 +18,7 @@
 package eddsa
 
 import (
+	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
@@ -31,6 +32,8 @@ import (
 	"crypto/ed25519"
 	"github.com/katzenpost/core/crypto/ecdh"
 	"github.com/katzenpost/core/crypto/extra25519"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/crypto/secmem"
 	"github.com/katzenpost/core/utils"
 )
 
@@ -52,7 +55,7 @@ var errInvalidKey = errors.New("eddsa: invalid key")
 // PublicKey is a EdDSA public key.
 type PublicKey struct {
 	pubKey    ed25519.PublicKey
-	b64String string
+	hexString string
 }
 
 // InternalPtr returns a pointer to the internal (`golang.org/x/crypto/ed25519`)
@@ -88,7 +91,7 @@ func (k *PublicKey) FromBytes(b []byte) error {
 
 	k.pubKey = make([]byte, PublicKeySize)
 	copy(k.pubKey, b)
-	k.rebuildB64String()
+	k.rebuildString()
 	return nil
 }
 
@@ -142,6 +145,24 @@ func (k *PublicKey) ToPEMFile(f string) error {
 	return ioutil.WriteFile(f, pem.EncodeToMemory(blk), 0600)
 }
 
+// FromPEMFile reads the PublicKey from a PEM file at path f.
+func (k *PublicKey) FromPEMFile(f string) error {
+	const keyType = "ED25519 PUBLIC KEY"
+
+	buf, err := ioutil.ReadFile(f)
+	if err != nil {
+		return err
+	}
+	blk, _ := pem.Decode(buf)
+	if blk == nil {
+		return fmt.Errorf("eddsa: failed to decode PEM file %v", f)
+	}
+	if blk.Type != keyType {
+		return fmt.Errorf("eddsa: attempted to decode PEM file with wrong key type")
+	}
+	return k.FromBytes(blk.Bytes)
+}
+
 // ToECDH converts the PublicKey to the corresponding ecdh.PublicKey.
 func (k *PublicKey) ToECDH() *ecdh.PublicKey {
 	var dhBytes, dsaBytes [32]byte
@@ -159,7 +180,7 @@ func (k *PublicKey) ToECDH() *ecdh.PublicKey {
 // certain contexts (eg: if used once in path selection).
 func (k *PublicKey) Reset() {
 	utils.ExplicitBzero(k.pubKey)
-	k.b64String = "[scrubbed]"
+	k.hexString = "[scrubbed]"
 }
 
 // Verify returns true iff the signature sig is valid for the message msg.
@@ -167,13 +188,77 @@ func (k *PublicKey) Verify(sig, msg []byte) bool {
 	return ed25519.Verify(k.pubKey, msg, sig)
 }
 
-// String returns the public key as a base64 encoded string.
+// String returns the public key as a lowercase hex encoded string.
 func (k *PublicKey) String() string {
-	return k.b64String
-}
+	return k.hexString
+}
+
+func (k *PublicKey) rebuildString() {
+	// Avoid hex.EncodeToString's intermediate heap allocation, as this is
+	// called every time an ephemeral key is regenerated.
+	var buf [2 * PublicKeySize]byte
+	n := hex.Encode(buf[:], k.Bytes())
+	k.hexString = string(buf[:n])
+}
+
+// ParseError is the error returned when ParsePublicKey fails to parse a key.
+type ParseError struct {
+	// Format is the encoding the key was parsed as ("hex", "base64",
+	// "base64url"), or "unknown" if the encoding was not recognized.
+	Format string
+
+	// Detail describes why the key is invalid.
+	Detail string
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("eddsa: invalid %v public key: %v", e.Format, e.Detail)
+}
+
+// publicKeyDecoders are the encodings accepted by ParsePublicKey, in the
+// order they are tried.
+var publicKeyDecoders = []struct {
+	format string
+	decode func(string) ([]byte, error)
+}{
+	{"hex", hex.DecodeString},
+	{"base64", base64.StdEncoding.Strict().DecodeString},
+	{"base64", base64.RawStdEncoding.Strict().DecodeString},
+	{"base64url", base64.URLEncoding.Strict().DecodeString},
+	{"base64url", base64.RawURLEncoding.Strict().DecodeString},
+}
+
+// ParsePublicKey parses the string s, encoded as hex (64 characters), base64,
+// or base64url (with or without padding), into a PublicKey.  The encodings
+// are tried in that order, so a string that is valid in more than one of
+// them (eg: base64 without any of the characters specific to either
+// alphabet) is always parsed as the first.  All errors are of type
+// *ParseError.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	var raw []byte
+	format := "unknown"
+	for _, v := range publicKeyDecoders {
+		if v.format == "hex" && len(s) != 2*PublicKeySize {
+			continue
+		}
+		if b, err := v.decode(s); err == nil {
+			raw, format = b, v.format
+			break
+		}
+	}
+	if raw == nil {
+		return nil, &ParseError{Format: format, Detail: "key is not hex, base64, or base64url"}
+	}
 
-func (k *PublicKey) rebuildB64String() {
-	k.b64String = base64.StdEncoding.EncodeToString(k.Bytes())
+	if len(raw) != PublicKeySize {
+		return nil, &ParseError{Format: format, Detail: fmt.Sprintf("decoded length is %v bytes, expected %v", len(raw), PublicKeySize)}
+	}
+	k := new(PublicKey)
+	if err := k.FromBytes(raw); err != nil {
+		return nil, &ParseError{Format: format, Detail: err.Error()}
+	}
+	return k, nil
 }
 
 // Equal returns true iff the public key is byte for byte identical.
@@ -199,16 +284,20 @@ func (k *PrivateKey) FromBytes(b []byte) error {
 		return errInvalidKey
 	}
 
+	if k.privKey != nil {
+		secmem.Free(k.privKey)
+	}
 	k.privKey = make([]byte, PrivateKeySize)
 	copy(k.privKey, b)
 	k.pubKey.pubKey = k.privKey.Public().(ed25519.PublicKey)
-	k.pubKey.rebuildB64String()
+	k.pubKey.rebuildString()
 	return nil
 }
 
-// Bytes returns the raw private key.
+// Bytes returns a copy of the raw private key.  The copy is not in locked
+// memory, and should be cleared by the caller once it is no longer needed.
 func (k *PrivateKey) Bytes() []byte {
-	return k.privKey
+	return append([]byte{}, k.privKey...)
 }
 
 // MarshalBinary implements the BinaryMarshaler interface
@@ -240,7 +329,7 @@ func (k *PrivateKey) KeyType() string {
 func (k *PrivateKey) ToECDH() *ecdh.PrivateKey {
 	var dsaBytes [64]byte
 	defer utils.ExplicitBzero(dsaBytes[:])
-	copy(dsaBytes[:], k.Bytes())
+	copy(dsaBytes[:], k.privKey)
 
 	var dhBytes [32]byte
 	extra25519.PrivateKeyToCurve25519(&dhBytes, &dsaBytes)
@@ -252,10 +341,14 @@ func (k *PrivateKey) ToECDH() *ecdh.PrivateKey {
 }
 
 // Reset clears the PrivateKey structure such that no sensitive data is left
-// in memory.
+// in memory, and releases any locked memory backing the private key.  Slices
+// previously returned by Bytes() MUST NOT be used after this call.
 func (k *PrivateKey) Reset() {
 	k.pubKey.Reset()
-	utils.ExplicitBzero(k.privKey)
+	if k.privKey != nil {
+		secmem.Free(k.privKey)
+		k.privKey = make([]byte, PrivateKeySize)
+	}
 }
 
 // PublicKey returns the PublicKey corresponding to the PrivateKey.
@@ -265,21 +358,55 @@ func (k *PrivateKey) PublicKey() *PublicKey {
 
 // Sign signs the message msg with the PrivateKey and returns the signature.
 func (k *PrivateKey) Sign(msg []byte) []byte {
-	return ed25519.Sign(k.privKey, msg)
+	// crypto/ed25519 takes weak references to the keys it is passed, which
+	// is not possible for memory allocated by secmem, so sign with a
+	// temporary heap copy of the key.
+	privKey := k.Bytes()
+	defer utils.ExplicitBzero(privKey)
+	return ed25519.Sign(privKey, msg)
+}
+
+// secretScalar sets x to the clamped secret scalar of the key, as per
+// RFC 8032 section 5.1.5.
+func (k *PrivateKey) secretScalar(x *[32]byte) {
+	digest := sha512.Sum512(k.privKey[:32])
+	defer utils.ExplicitBzero(digest[:])
+	copy(x[:], digest[:32])
+	x[0] &= 248
+	x[31] &= 127
+	x[31] |= 64
 }
 
 // NewKeypair generates a new PrivateKey sampled from the provided entropy
-// source.
+// source.  Where supported, the private key is stored in memory that is
+// locked into RAM, which is only released by PrivateKey.Reset(), which
+// callers MUST call once the key is no longer needed.
 func NewKeypair(r io.Reader) (*PrivateKey, error) {
 	pubKey, privKey, err := ed25519.GenerateKey(r)
 	if err != nil {
 		return nil, err
 	}
+	defer utils.ExplicitBzero(privKey)
 
 	k := new(PrivateKey)
-	k.privKey = privKey
+	k.privKey = secmem.Alloc(PrivateKeySize)
+	copy(k.privKey, privKey)
 	k.pubKey.pubKey = pubKey
-	k.pubKey.rebuildB64String()
+	k.pubKey.rebuildString()
+	return k, nil
+}
+
+// RotateEphemeral generates a new ephemeral PrivateKey and clears the current
+// one, such that no sensitive data from the old key is left in memory.  The
+// current key may be nil, and must not be used after this call.
+func RotateEphemeral(current *PrivateKey) (*PrivateKey, error) {
+	k, err := NewKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		current.Reset()
+	}
 	return k, nil
 }
 
@@ -314,6 +441,7 @@ func Load(privFile, pubFile string, r io.Reader) (*PrivateKey, error) {
 		Type:  keyType,
 		Bytes: k.Bytes(),
 	}
+	defer utils.ExplicitBzero(blk.Bytes)
 	if err = ioutil.WriteFile(privFile, pem.EncodeToMemory(blk), 0600); err != nil {
 		return nil, err
 	}
@@ -17,9 +17,18 @@
 package eddsa
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	kRand "github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/epochtime"
 	"github.com/katzenpost/core/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,6 +49,11 @@ func TestKeypair(t *testing.T) {
 	assert.NoError(err, "PrivateKey.ToBytes()->FromBytes()")
 	assert.Equal(privKey, &privKey2, "PrivateKey.ToBytes()->FromBytes()")
 
+	// Bytes() returns a copy, that does not alias the key's storage.
+	b := privKey2.Bytes()
+	utils.ExplicitBzero(b)
+	assert.Equal(privKey, &privKey2, "PrivateKey.Bytes() aliased the key")
+
 	privKey2.Reset()
 	assert.True(utils.CtIsZero(privKey2.privKey), "PrivateKey.Reset()")
 
@@ -72,3 +86,183 @@ func TestEdDSAOps(t *testing.T) {
 	dhPubKey := privKey.PublicKey().ToECDH()
 	assert.True(dhPrivKey.PublicKey().Equal(dhPubKey), "ToECDH() basic sanity")
 }
+
+func TestPublicKeyToFromPEMFile(t *testing.T) {
+	require := require.New(t)
+
+	k, err := NewKeypair(rand.Reader)
+	require.NoError(err)
+	f, err := ioutil.TempFile("", "eddsa.pem")
+	require.NoError(err)
+	defer os.Remove(f.Name())
+	require.NoError(k.PublicKey().ToPEMFile(f.Name()))
+
+	pubKey := new(PublicKey)
+	require.NoError(pubKey.FromPEMFile(f.Name()))
+	require.Equal(k.PublicKey().Bytes(), pubKey.Bytes())
+
+	require.NoError(ioutil.WriteFile(f.Name(), []byte("not PEM"), 0600))
+	require.Error(pubKey.FromPEMFile(f.Name()))
+}
+
+func TestRotateEphemeral(t *testing.T) {
+	require := require.New(t)
+
+	oldKey, err := NewKeypair(rand.Reader)
+	require.NoError(err, "NewKeypair()")
+	oldBytes := append([]byte{}, oldKey.Bytes()...)
+
+	newKey, err := RotateEphemeral(oldKey)
+	require.NoError(err, "RotateEphemeral()")
+	require.True(utils.CtIsZero(oldKey.Bytes()), "RotateEphemeral() did not clear the old key")
+	require.NotEqual(oldBytes, newKey.Bytes(), "RotateEphemeral() returned the old key")
+
+	newKey2, err := RotateEphemeral(nil)
+	require.NoError(err, "RotateEphemeral(nil)")
+	require.NotEqual(newKey.Bytes(), newKey2.Bytes(), "RotateEphemeral(nil)")
+}
+
+func TestParsePublicKey(t *testing.T) {
+	require := require.New(t)
+
+	// This key's base64 and base64url encodings differ, which is not the
+	// case for every key.
+	raw, err := hex.DecodeString("fb731cf47b3732b24a5f9c00a0304b66d461b23e7292c5eb406ec09adc2d95e0")
+	require.NoError(err, "DecodeString()")
+	pubKey := new(PublicKey)
+	require.NoError(pubKey.FromBytes(raw), "FromBytes()")
+
+	require.Equal(hex.EncodeToString(raw), pubKey.String(), "String()")
+	for _, s := range []string{
+		pubKey.String(),
+		strings.ToUpper(pubKey.String()),
+		"+3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtleA=",
+		"+3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtleA",
+		"-3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtleA=",
+		"-3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtleA",
+	} {
+		k, err := ParsePublicKey(s)
+		require.NoError(err, "ParsePublicKey(%v)", s)
+		require.Equal(raw, k.Bytes(), "ParsePublicKey(%v)", s)
+	}
+
+	for _, v := range []struct {
+		s, format string
+	}{
+		{"+3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtlQ==", "base64"},
+		{"-3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtleAA", "base64url"},
+		// Valid in either alphabet, so parsed as base64.
+		{base64.RawURLEncoding.EncodeToString(make([]byte, PublicKeySize+1)), "base64"},
+		// Non-zero padding bits are rejected.
+		{"+3Mc9Hs3MrJKX5wAoDBLZtRhsj5yksXrQG7AmtwtleB=", "unknown"},
+		{"not a key!", "unknown"},
+	} {
+		_, err := ParsePublicKey(v.s)
+		parseErr, ok := err.(*ParseError)
+		require.True(ok, "ParsePublicKey(%v): %v", v.s, err)
+		require.Equal(v.format, parseErr.Format, "ParsePublicKey(%v)", v.s)
+	}
+}
+
+func TestEphemeralPool(t *testing.T) {
+	require := require.New(t)
+
+	k := GetEphemeral()
+	msg := []byte("ephemeral")
+	require.True(k.PublicKey().Verify(k.Sign(msg), msg), "Verify(GetEphemeral().Sign())")
+	oldPub := append([]byte{}, k.PublicKey().Bytes()...)
+
+	// Returning a key to the pool zeroes it.
+	privKey, pubKey := k.privKey, k.PublicKey().Bytes()
+	PutEphemeral(k)
+	require.True(utils.CtIsZero(privKey), "PutEphemeral() private key")
+	require.True(utils.CtIsZero(pubKey), "PutEphemeral() public key")
+
+	// Keys retrieved from the pool are fresh, whether or not they were
+	// recycled.
+	for i := 0; i < 4; i++ {
+		k = GetEphemeral()
+		require.False(utils.CtIsZero(k.privKey), "GetEphemeral() zero key")
+		require.NotEqual(oldPub, k.PublicKey().Bytes(), "GetEphemeral() reused key")
+		expected := ed25519.NewKeyFromSeed(k.privKey[:32])
+		require.Equal([]byte(expected), []byte(k.privKey), "GetEphemeral() inconsistent key")
+		require.Equal(hex.EncodeToString(k.PublicKey().Bytes()), k.PublicKey().String())
+		require.True(k.PublicKey().Verify(k.Sign(msg), msg), "Verify(GetEphemeral().Sign())")
+		PutEphemeral(k)
+	}
+
+	// Recycling keys via the pool allocates at least 50% less than
+	// generating new ones with the same entropy source.
+	poolAllocs := testing.AllocsPerRun(100, func() {
+		PutEphemeral(GetEphemeral())
+	})
+	newAllocs := testing.AllocsPerRun(100, func() {
+		k, _ := NewKeypair(kRand.Reader)
+		k.Reset()
+	})
+	require.True(poolAllocs <= newAllocs/2, "pool allocs/op %v, NewKeypair allocs/op %v", poolAllocs, newAllocs)
+}
+
+func BenchmarkEphemeralPool(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		PutEphemeral(GetEphemeral())
+	}
+}
+
+func BenchmarkNewKeypair(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		k, err := NewKeypair(kRand.Reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+		k.Reset()
+	}
+}
+
+func TestKeyScheduler(t *testing.T) {
+	require := require.New(t)
+
+	clock := epochtime.NewFakeEpochClock(100)
+	initialKey, err := NewKeypair(rand.Reader)
+	require.NoError(err, "NewKeypair()")
+	s := NewKeyScheduler(initialKey, epochtime.Period, 103, clock)
+
+	type rotation struct {
+		old, new *PrivateKey
+	}
+	var rotations []rotation
+	s.OnRotation(func(old, new *PrivateKey) {
+		rotations = append(rotations, rotation{old, new})
+	})
+
+	// Outside of the renewal window there is no next key.
+	require.Equal(initialKey, s.CurrentKey())
+	require.Nil(s.NextKey())
+	clock.Advance(epochtime.Period + time.Minute)
+	require.Nil(s.NextKey())
+
+	expected := []rotation{}
+	current := initialKey
+	for expiry := uint64(103); expiry <= 106; expiry += 3 {
+		// The next key is generated once in the renewal window.
+		clock.SetEpoch(expiry - 1)
+		next := s.NextKey()
+		require.NotNil(next, "NextKey() in renewal window")
+		require.False(next.PublicKey().Equal(current.PublicKey()))
+		require.Equal(current, s.CurrentKey())
+		require.Equal(next, s.NextKey(), "NextKey() is stable")
+		require.Equal(expiry, s.Expiry())
+
+		// And promoted at expiry.
+		clock.SetEpoch(expiry)
+		require.Equal(next, s.CurrentKey(), "CurrentKey() after expiry")
+		require.Nil(s.NextKey())
+		require.Equal(expiry+3, s.Expiry())
+
+		expected = append(expected, rotation{current, next})
+		current = next
+	}
+	require.Equal(expected, rotations)
+}
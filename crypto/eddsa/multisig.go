@@ -0,0 +1,269 @@
+// multisig.go - Ed25519 multi-signatures.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eddsa
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/katzenpost/core/crypto/edwards25519"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/utils"
+)
+
+const multiSigKeyAggContext = "katzenpost-eddsa-multisig-keyagg-v0"
+
+var (
+	// ErrInvalidPartialSignature is the error returned when a participant's
+	// partial signature does not verify.
+	ErrInvalidPartialSignature = errors.New("eddsa: invalid partial signature")
+
+	// ErrInvalidAggregateSignature is the error returned when an aggregate
+	// signature does not verify.
+	ErrInvalidAggregateSignature = errors.New("eddsa: invalid aggregate signature")
+
+	errNoKeys        = errors.New("eddsa: no multi-signature keys")
+	errDuplicateKeys = errors.New("eddsa: duplicate multi-signature keys")
+)
+
+// MultiSigner produces a single 64 byte signature from multiple keys, per
+// the MuSig scheme.  Each key contributes a nonce commitment and a partial
+// signature, which are combined into a standard Ed25519 signature by the
+// aggregate public key of all of the keys.
+//
+// As the keys are all held locally, each signing round is executed in
+// full by AggregateSign.
+type MultiSigner struct {
+	keys       []*PrivateKey
+	randReader io.Reader
+}
+
+// NewMultiSigner creates a MultiSigner for the keys.
+func NewMultiSigner(keys []*PrivateKey) *MultiSigner {
+	return &MultiSigner{
+		keys:       append([]*PrivateKey{}, keys...),
+		randReader: rand.Reader,
+	}
+}
+
+// AggregatePublicKey returns the aggregate public key, that verifies
+// signatures made by the MultiSigner.
+func (m *MultiSigner) AggregatePublicKey() (*PublicKey, error) {
+	pubKeys := make([]*PublicKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		pubKeys = append(pubKeys, k.PublicKey())
+	}
+	agg, err := aggregateKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	pk := new(PublicKey)
+	return pk, pk.FromBytes(agg.key[:])
+}
+
+// AggregateSign signs message with every key, and returns the aggregate
+// signature.
+func (m *MultiSigner) AggregateSign(message []byte) ([]byte, error) {
+	s, err := m.newSigningSession(message)
+	if err != nil {
+		return nil, err
+	}
+	defer s.reset()
+	return s.combine(s.partialSigns())
+}
+
+// AggregateVerify returns nil iff aggSig is a valid signature of message by
+// the aggregate of pubkeys, in any order.
+func AggregateVerify(message, aggSig []byte, pubkeys []*PublicKey) error {
+	agg, err := aggregateKeys(pubkeys)
+	if err != nil {
+		return err
+	}
+	if len(aggSig) != SignatureSize || !ed25519.Verify(agg.key[:], message, aggSig) {
+		return ErrInvalidAggregateSignature
+	}
+	return nil
+}
+
+// keyAggregate is the result of aggregating a set of public keys.
+type keyAggregate struct {
+	// key is the aggregate public key, X = sum([a_i]A_i).
+	key [32]byte
+
+	// coefficients are the per-key coefficients a_i, indexed by public
+	// key, that prevent rogue key attacks.
+	coefficients map[[32]byte]*[32]byte
+}
+
+func aggregateKeys(pubkeys []*PublicKey) (*keyAggregate, error) {
+	if len(pubkeys) == 0 {
+		return nil, errNoKeys
+	}
+
+	// The aggregate is independent of the order of the keys.
+	keys := make([][32]byte, 0, len(pubkeys))
+	for _, pk := range pubkeys {
+		keys = append(keys, pk.ByteArray())
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	l := sha512.New()
+	l.Write([]byte(multiSigKeyAggContext))
+	for i := range keys {
+		if i > 0 && keys[i] == keys[i-1] {
+			return nil, errDuplicateKeys
+		}
+		l.Write(keys[i][:])
+	}
+	lDigest := l.Sum(nil)
+
+	agg := &keyAggregate{coefficients: make(map[[32]byte]*[32]byte)}
+	var acc, p edwards25519.ExtendedGroupElement
+	acc.Zero()
+	for i := range keys {
+		if !p.FromBytes(&keys[i]) {
+			return nil, errInvalidKey
+		}
+		a := edwards25519.ScFromHash(lDigest, keys[i][:])
+		agg.coefficients[keys[i]] = a
+		geScalarMult(&p, a, &p)
+		edwards25519.GeAddExtended(&acc, &acc, &p)
+	}
+	acc.ToBytes(&agg.key)
+	return agg, nil
+}
+
+// signingSession is the state of a single signing round.
+type signingSession struct {
+	m       *MultiSigner
+	message []byte
+	agg     *keyAggregate
+
+	// nonces are the secret nonces r_i, and commitments the public
+	// commitments R_i = [r_i]B.
+	nonces      []*[32]byte
+	commitments []*[32]byte
+
+	// r is the aggregate commitment R = sum(R_i), and c the challenge
+	// H(R || X || M).
+	r [32]byte
+	c *[32]byte
+}
+
+func (m *MultiSigner) newSigningSession(message []byte) (*signingSession, error) {
+	pubKeys := make([]*PublicKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		pubKeys = append(pubKeys, k.PublicKey())
+	}
+	agg, err := aggregateKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	s := &signingSession{
+		m:       m,
+		message: message,
+		agg:     agg,
+	}
+
+	// Round 1: Each participant commits to a nonce.
+	var acc, p edwards25519.ExtendedGroupElement
+	acc.Zero()
+	for range m.keys {
+		var b [64]byte
+		if _, err := io.ReadFull(m.randReader, b[:]); err != nil {
+			s.reset()
+			return nil, err
+		}
+		r := new([32]byte)
+		edwards25519.ScReduce(r, &b)
+		utils.ExplicitBzero(b[:])
+		edwards25519.GeScalarMultBase(&p, r)
+		commitment := new([32]byte)
+		p.ToBytes(commitment)
+		edwards25519.GeAddExtended(&acc, &acc, &p)
+		s.nonces = append(s.nonces, r)
+		s.commitments = append(s.commitments, commitment)
+	}
+	acc.ToBytes(&s.r)
+	s.c = edwards25519.ScFromHash(s.r[:], agg.key[:], message)
+	return s, nil
+}
+
+// partialSigns returns each participant's partial signature,
+// s_i = r_i + c * a_i * x_i.
+func (s *signingSession) partialSigns() []*[32]byte {
+	var zero [32]byte
+	partials := make([]*[32]byte, 0, len(s.m.keys))
+	for i, k := range s.m.keys {
+		var x, ca [32]byte
+		k.secretScalar(&x)
+		edwards25519.ScMulAdd(&ca, s.c, s.agg.coefficients[k.PublicKey().ByteArray()], &zero)
+		si := new([32]byte)
+		edwards25519.ScMulAdd(si, &ca, &x, s.nonces[i])
+		utils.ExplicitBzero(x[:])
+		partials = append(partials, si)
+	}
+	return partials
+}
+
+// combine verifies each partial signature, and returns the aggregate
+// signature R || sum(s_i).
+func (s *signingSession) combine(partials []*[32]byte) ([]byte, error) {
+	var one, sum [32]byte
+	one[0] = 1
+	for i, k := range s.m.keys {
+		// Check [s_i]B == R_i + [c * a_i]A_i.
+		var zero, ca, check [32]byte
+		var negA edwards25519.ExtendedGroupElement
+		var p edwards25519.ProjectiveGroupElement
+		edwards25519.ScMulAdd(&ca, s.c, s.agg.coefficients[k.PublicKey().ByteArray()], &zero)
+		pub := k.PublicKey().ByteArray()
+		negA.FromBytes(&pub)
+		edwards25519.GeNeg(&negA, &negA)
+		edwards25519.GeDoubleScalarMultVartime(&p, &ca, &negA, partials[i])
+		p.ToBytes(&check)
+		if check != *s.commitments[i] {
+			return nil, ErrInvalidPartialSignature
+		}
+		edwards25519.ScMulAdd(&sum, &one, partials[i], &sum)
+	}
+
+	sig := make([]byte, 0, SignatureSize)
+	sig = append(sig, s.r[:]...)
+	return append(sig, sum[:]...), nil
+}
+
+func (s *signingSession) reset() {
+	for _, r := range s.nonces {
+		utils.ExplicitBzero(r[:])
+	}
+}
+
+// The group arithmetic below is only ever applied to public values, and is
+// therefore NOT constant time.
+
+// geScalarMult sets r = [a]p.
+func geScalarMult(r *edwards25519.ExtendedGroupElement, a *[32]byte, p *edwards25519.ExtendedGroupElement) {
+	var zero, b [32]byte
+	var proj edwards25519.ProjectiveGroupElement
+	edwards25519.GeDoubleScalarMultVartime(&proj, a, p, &zero)
+	proj.ToBytes(&b)
+	r.FromBytes(&b)
+}
@@ -0,0 +1,108 @@
+// multisig_test.go - Ed25519 multi-signature tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eddsa
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/edwards25519"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiSigner(t *testing.T) {
+	require := require.New(t)
+
+	const n = 5
+	keys := make([]*PrivateKey, 0, n)
+	pubKeys := make([]*PublicKey, 0, n)
+	for i := 0; i < n; i++ {
+		k, err := NewKeypair(rand.Reader)
+		require.NoError(err, "NewKeypair()")
+		keys = append(keys, k)
+		pubKeys = append(pubKeys, k.PublicKey())
+	}
+	msg := []byte("PKI document")
+
+	m := NewMultiSigner(keys)
+	sig, err := m.AggregateSign(msg)
+	require.NoError(err, "AggregateSign()")
+	require.Len(sig, SignatureSize)
+	require.NoError(AggregateVerify(msg, sig, pubKeys), "AggregateVerify()")
+
+	// The signature is a standard Ed25519 signature by the aggregate key,
+	// which does not depend on the order of the keys.
+	aggKey, err := m.AggregatePublicKey()
+	require.NoError(err, "AggregatePublicKey()")
+	require.True(aggKey.Verify(sig, msg), "Verify(aggregate key)")
+	reversed := make([]*PublicKey, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		reversed = append(reversed, pubKeys[i])
+	}
+	require.NoError(AggregateVerify(msg, sig, reversed), "AggregateVerify(reversed)")
+
+	// The signature is bound to the message and the full set of keys.
+	require.Equal(ErrInvalidAggregateSignature, AggregateVerify([]byte("other document"), sig, pubKeys))
+	require.Equal(ErrInvalidAggregateSignature, AggregateVerify(msg, sig, pubKeys[1:]))
+	require.Equal(ErrInvalidAggregateSignature, AggregateVerify(msg, sig[:SignatureSize-1], pubKeys))
+	require.Error(AggregateVerify(msg, sig, append(pubKeys, pubKeys[0])), "duplicate keys")
+	require.Error(AggregateVerify(msg, sig, nil), "no keys")
+	_, err = NewMultiSigner(nil).AggregateSign(msg)
+	require.Error(err, "AggregateSign(no keys)")
+}
+
+func TestMultiSignerTamperedPartial(t *testing.T) {
+	require := require.New(t)
+
+	const n = 3
+	keys := make([]*PrivateKey, 0, n)
+	pubKeys := make([]*PublicKey, 0, n)
+	for i := 0; i < n; i++ {
+		k, err := NewKeypair(rand.Reader)
+		require.NoError(err, "NewKeypair()")
+		keys = append(keys, k)
+		pubKeys = append(pubKeys, k.PublicKey())
+	}
+	msg := []byte("PKI document")
+
+	s, err := NewMultiSigner(keys).newSigningSession(msg)
+	require.NoError(err, "newSigningSession()")
+	partials := s.partialSigns()
+	partials[1][0] ^= 0x01
+
+	// The tampered partial signature is detected when combining.
+	_, err = s.combine(partials)
+	require.Equal(ErrInvalidPartialSignature, err)
+
+	// And the aggregate including it is rejected.
+	var one, sum [32]byte
+	one[0] = 1
+	for _, si := range partials {
+		edwards25519.ScMulAdd(&sum, &one, si, &sum)
+	}
+	sig := append(append([]byte{}, s.r[:]...), sum[:]...)
+	require.Equal(ErrInvalidAggregateSignature, AggregateVerify(msg, sig, pubKeys))
+
+	// Untampered, the same round produces a valid signature.
+	partials[1][0] ^= 0x01
+	sig, err = s.combine(partials)
+	require.NoError(err, "combine()")
+	agg, err := aggregateKeys(pubKeys)
+	require.NoError(err, "aggregateKeys()")
+	require.True(ed25519.Verify(agg.key[:], msg, sig), "ed25519.Verify()")
+}
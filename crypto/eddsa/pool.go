@@ -0,0 +1,83 @@
+// pool.go - Ephemeral EdDSA key pool.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eddsa
+
+import (
+	"io"
+	"sync"
+
+	"github.com/katzenpost/core/crypto/edwards25519"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/utils"
+)
+
+// EphemeralPool is a pool of ephemeral PrivateKeys, that amortizes the cost
+// of allocating keys in high throughput paths.  Use GetEphemeral and
+// PutEphemeral rather than accessing the pool directly.
+var EphemeralPool = sync.Pool{
+	New: func() interface{} {
+		k, err := NewKeypair(rand.Reader)
+		if err != nil {
+			panic("eddsa: failed to generate ephemeral key: " + err.Error())
+		}
+		return k
+	},
+}
+
+// GetEphemeral returns a freshly generated ephemeral PrivateKey from the
+// EphemeralPool.  Keys that were previously returned to the pool are
+// regenerated in place, without allocating new key storage.
+func GetEphemeral() *PrivateKey {
+	k := EphemeralPool.Get().(*PrivateKey)
+	if utils.CtIsZero(k.privKey) {
+		k.regenerate(rand.Reader)
+	}
+	return k
+}
+
+// PutEphemeral clears the PrivateKey and its PublicKey such that no
+// sensitive data is left in memory, and returns it to the EphemeralPool.
+// Neither the key nor its PublicKey may be used after this call.
+func PutEphemeral(k *PrivateKey) {
+	// Unlike Reset, the key storage is retained for reuse.
+	utils.ExplicitBzero(k.privKey)
+	k.pubKey.Reset()
+	EphemeralPool.Put(k)
+}
+
+// regenerate generates a new key sampled from r into the PrivateKey's
+// existing storage.
+func (k *PrivateKey) regenerate(r io.Reader) {
+	// Read the seed directly into the key, as passing a stack buffer to
+	// r would force it onto the heap.
+	seed := k.privKey[:32]
+	if _, err := io.ReadFull(r, seed); err != nil {
+		panic("eddsa: failed to generate ephemeral key: " + err.Error())
+	}
+
+	// This is what crypto/ed25519.NewKeyFromSeed does, sans the allocation.
+	var scalar, pub [32]byte
+	k.secretScalar(&scalar)
+	var a edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&a, &scalar)
+	a.ToBytes(&pub)
+	utils.ExplicitBzero(scalar[:])
+
+	copy(k.privKey[32:], pub[:])
+	copy(k.pubKey.pubKey, pub[:])
+	k.pubKey.rebuildString()
+}
@@ -0,0 +1,131 @@
+// scheduler.go - EdDSA key rotation scheduler.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eddsa
+
+import (
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/epochtime"
+)
+
+// KeyScheduler rotates a long lived signing key before its certificate
+// expires.  The next key is generated once the current key's expiry epoch
+// is within the renewal window, and is promoted to the current key when the
+// expiry epoch starts, with every key being valid for the same number of
+// epochs as the initial key.  The schedule is advanced on every call to
+// CurrentKey or NextKey.  It is safe for concurrent use.
+type KeyScheduler struct {
+	sync.Mutex
+
+	clock       epochtime.EpochClock
+	renewBefore time.Duration
+	lifetime    uint64
+
+	current *PrivateKey
+	next    *PrivateKey
+	expiry  uint64
+
+	onRotation []func(old, new *PrivateKey)
+}
+
+// CurrentKey returns the key that should currently be used for signing.
+func (s *KeyScheduler) CurrentKey() *PrivateKey {
+	s.update()
+
+	s.Lock()
+	defer s.Unlock()
+	return s.current
+}
+
+// NextKey returns the key that will replace the current key once it expires,
+// or nil if the renewal window has not yet been reached.
+func (s *KeyScheduler) NextKey() *PrivateKey {
+	s.update()
+
+	s.Lock()
+	defer s.Unlock()
+	return s.next
+}
+
+// Expiry returns the epoch at which the current key expires.
+func (s *KeyScheduler) Expiry() uint64 {
+	s.update()
+
+	s.Lock()
+	defer s.Unlock()
+	return s.expiry
+}
+
+// OnRotation registers fn to be called every time the next key is promoted
+// to the current key.  The old key is not cleared by the scheduler.
+func (s *KeyScheduler) OnRotation(fn func(old, new *PrivateKey)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onRotation = append(s.onRotation, fn)
+}
+
+func (s *KeyScheduler) update() {
+	type rotation struct {
+		old, new *PrivateKey
+	}
+	var rotations []rotation
+	s.Lock()
+	for {
+		until := epochtime.DurationUntilEpoch(s.clock, s.expiry)
+		if s.next == nil && until <= s.renewBefore {
+			k, err := NewKeypair(rand.Reader)
+			if err != nil {
+				panic("eddsa: failed to generate key: " + err.Error())
+			}
+			s.next = k
+		}
+		if until > 0 {
+			break
+		}
+		rotations = append(rotations, rotation{s.current, s.next})
+		s.current, s.next = s.next, nil
+		s.expiry += s.lifetime
+	}
+	callbacks := s.onRotation
+	s.Unlock()
+
+	for _, r := range rotations {
+		for _, fn := range callbacks {
+			fn(r.old, r.new)
+		}
+	}
+}
+
+// NewKeyScheduler returns a new KeyScheduler, starting with initialKey,
+// which expires at the start of the epoch certExpiry.  The next key is
+// generated renewBefore the current key expires.
+func NewKeyScheduler(initialKey *PrivateKey, renewBefore time.Duration, certExpiry uint64, clock epochtime.EpochClock) *KeyScheduler {
+	now, _, _ := clock.Now()
+	if certExpiry <= now {
+		panic("eddsa: key scheduler initial key has already expired")
+	}
+	return &KeyScheduler{
+		clock:       clock,
+		renewBefore: renewBefore,
+		lifetime:    certExpiry - now,
+		current:     initialKey,
+		expiry:      certExpiry,
+	}
+}
@@ -0,0 +1,61 @@
+// stream.go - EdDSA signatures over streamed messages.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eddsa
+
+import (
+	"crypto/sha512"
+	"io"
+)
+
+// streamDomain prefixes the digests signed by SignStream, separating them
+// from any message signed directly with Sign.
+var streamDomain = []byte("katzenpost-eddsa-stream-v0")
+
+// streamMessage returns the message signed for the contents of r, the
+// domain separator followed by the SHA-512 digest of the contents.
+func streamMessage(r io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(streamDomain)+sha512.Size)
+	msg = append(msg, streamDomain...)
+	return h.Sum(msg), nil
+}
+
+// SignStream signs the contents of r with the PrivateKey and returns the
+// signature, without holding the contents in memory.  The message is
+// pre-hashed with SHA-512, and the digest signed with Ed25519 prefixed by a
+// domain separator, so the signature is only valid for VerifyStream, not
+// Verify.  This is not the RFC 8032 Ed25519ph variant.
+func (k *PrivateKey) SignStream(r io.Reader) ([]byte, error) {
+	msg, err := streamMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	return k.Sign(msg), nil
+}
+
+// VerifyStream returns true iff the signature sig, as produced by
+// SignStream, is valid for the contents of r.
+func (k *PublicKey) VerifyStream(r io.Reader, sig []byte) bool {
+	msg, err := streamMessage(r)
+	if err != nil {
+		return false
+	}
+	return k.Verify(sig, msg)
+}
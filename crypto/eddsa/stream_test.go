@@ -0,0 +1,84 @@
+// stream_test.go - EdDSA streamed message signature tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eddsa
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignStream(t *testing.T) {
+	require := require.New(t)
+
+	privKey, err := NewKeypair(rand.Reader)
+	require.NoError(err, "NewKeypair()")
+	defer privKey.Reset()
+	pubKey := privKey.PublicKey()
+
+	msg := make([]byte, 1<<20)
+	_, err = io.ReadFull(rand.Reader, msg)
+	require.NoError(err, "failed to generate message")
+
+	sig, err := privKey.SignStream(bytes.NewReader(msg))
+	require.NoError(err, "SignStream()")
+	require.Len(sig, SignatureSize)
+
+	// The stream signature is a Sign of the domain separator followed by
+	// the digest, and a Sign of the digest alone is not accepted.
+	digest := sha512.Sum512(msg)
+	require.Equal(privKey.Sign(append([]byte("katzenpost-eddsa-stream-v0"), digest[:]...)), sig, "SignStream() != Sign(domain || digest)")
+	require.False(pubKey.VerifyStream(bytes.NewReader(msg), privKey.Sign(digest[:])), "VerifyStream(Sign(digest))")
+
+	// It is independent of how the stream is read.
+	chunked, err := privKey.SignStream(iotest.HalfReader(bytes.NewReader(msg)))
+	require.NoError(err, "SignStream(chunked)")
+	require.Equal(sig, chunked)
+
+	require.True(pubKey.VerifyStream(bytes.NewReader(msg), sig), "VerifyStream()")
+	require.True(pubKey.VerifyStream(iotest.OneByteReader(bytes.NewReader(msg)), sig), "VerifyStream(one byte)")
+
+	// Stream and direct signatures are not interchangeable.
+	require.False(pubKey.Verify(sig, msg), "Verify(stream signature)")
+	require.False(pubKey.VerifyStream(bytes.NewReader(msg), privKey.Sign(msg)), "VerifyStream(direct signature)")
+
+	// Modified messages and truncated streams are rejected.
+	msg[len(msg)/2] ^= 0xa5
+	require.False(pubKey.VerifyStream(bytes.NewReader(msg), sig), "VerifyStream(modified)")
+	msg[len(msg)/2] ^= 0xa5
+	require.False(pubKey.VerifyStream(bytes.NewReader(msg[:len(msg)-1]), sig), "VerifyStream(truncated)")
+
+	// Read errors are propagated, and never verify.
+	errRead := errors.New("read failed")
+	_, err = privKey.SignStream(&errReader{errRead})
+	require.Equal(errRead, err, "SignStream(failing reader)")
+	require.False(pubKey.VerifyStream(&errReader{errRead}, sig), "VerifyStream(failing reader)")
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,51 @@
+// extended.go - Extended group element helpers.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package edwards25519
+
+import "crypto/sha512"
+
+// The helpers here are only intended for public values (signatures and
+// public keys), and are NOT constant time.
+
+// ScFromHash returns SHA-512(b...) reduced modulo the group order.
+func ScFromHash(b ...[]byte) *[32]byte {
+	h := sha512.New()
+	for _, v := range b {
+		h.Write(v)
+	}
+	var digest [64]byte
+	h.Sum(digest[:0])
+	out := new([32]byte)
+	ScReduce(out, &digest)
+	return out
+}
+
+// GeAddExtended sets r = p + q.
+func GeAddExtended(r, p, q *ExtendedGroupElement) {
+	var qCached CachedGroupElement
+	var sum CompletedGroupElement
+	q.ToCached(&qCached)
+	geAdd(&sum, p, &qCached)
+	sum.ToExtended(r)
+}
+
+// GeNeg sets r = -p.
+func GeNeg(r, p *ExtendedGroupElement) {
+	*r = *p
+	FeNeg(&r.X, &p.X)
+	FeNeg(&r.T, &p.T)
+}
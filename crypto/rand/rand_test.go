@@ -19,6 +19,7 @@ package rand
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
 	"testing"
@@ -87,3 +88,40 @@ func TestMath(t *testing.T) {
 	}
 	assert.NoError(ensureHighEntropy(b[:]), "math/rand: Statistical test")
 }
+
+type countingReader struct {
+	reads int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	r.reads++
+	return len(b), nil
+}
+
+func TestSafeReader(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf [32]byte
+	ctx, cancel := context.WithCancel(context.Background())
+	cr := new(countingReader)
+	r := NewSafeReader(ctx, cr)
+
+	n, err := r.Read(buf[:])
+	assert.NoError(err, "Read()")
+	assert.Equal(len(buf), n)
+	assert.Equal(1, cr.reads)
+
+	cancel()
+	n, err = r.Read(buf[:])
+	assert.Equal(context.Canceled, err, "Read(), cancelled")
+	assert.Equal(0, n)
+	assert.Equal(1, cr.reads, "underlying reader called after cancel")
+
+	// A context that is already done fails the first Read.
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	cr = new(countingReader)
+	_, err = NewSafeReader(ctx, cr).Read(buf[:])
+	assert.Equal(context.DeadlineExceeded, err, "Read(), expired")
+	assert.Equal(0, cr.reads, "underlying reader called after expiry")
+}
@@ -0,0 +1,41 @@
+// safe_reader.go - Context bound entropy source.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rand
+
+import (
+	"context"
+	"io"
+)
+
+type safeReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *safeReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// NewSafeReader returns an io.Reader that proxies reads to r, unless ctx is
+// done, in which case every Read fails with ctx.Err() without reading from
+// r.
+func NewSafeReader(ctx context.Context, r io.Reader) io.Reader {
+	return &safeReader{ctx: ctx, r: r}
+}
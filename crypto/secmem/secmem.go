@@ -0,0 +1,23 @@
+// secmem.go - Secure memory allocation.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package secmem provides memory allocation for sensitive data such as
+// private keys, that where supported is locked into RAM so that it is never
+// swapped to disk.
+//
+// Memory returned by Alloc MUST be released with Free, after which it MUST
+// NOT be accessed.
+package secmem
@@ -0,0 +1,35 @@
+// secmem_fallback.go - Fallback secure memory allocation.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !linux
+
+package secmem
+
+import "github.com/katzenpost/core/utils"
+
+// Alloc returns a zeroed n byte slice.  Memory locking is not supported on
+// this platform, so regular allocation is used.
+func Alloc(n int) []byte {
+	if n <= 0 {
+		return []byte{}
+	}
+	return make([]byte, n)
+}
+
+// Free clears the slice b.
+func Free(b []byte) {
+	utils.ExplicitBzero(b)
+}
@@ -0,0 +1,170 @@
+// secmem_linux.go - Linux mlock() based secure memory allocation.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secmem
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/katzenpost/core/utils"
+)
+
+// chunkSize is the granularity of allocations packed into slabs.
+const chunkSize = 64
+
+var (
+	pageSize = syscall.Getpagesize()
+
+	mappingsLock sync.Mutex
+	mappings     = make(map[*byte][]byte)
+	slabs        = make(map[uintptr]*slab)
+)
+
+// slab is a single locked page, that small allocations are packed into so
+// that each does not consume a whole page of RLIMIT_MEMLOCK.
+type slab struct {
+	mem    []byte
+	used   []bool
+	allocs map[int]int
+}
+
+func newSlab() *slab {
+	b := mapLocked(pageSize)
+	if b == nil {
+		return nil
+	}
+	s := &slab{
+		mem:    b,
+		used:   make([]bool, pageSize/chunkSize),
+		allocs: make(map[int]int),
+	}
+	slabs[uintptr(unsafe.Pointer(&b[0]))] = s
+	return s
+}
+
+// alloc returns the index of the first run of nChunks free chunks in the
+// slab, and marks it as used, or returns -1 if there is no such run.
+func (s *slab) alloc(nChunks int) int {
+	run := 0
+	for i, used := range s.used {
+		if used {
+			run = 0
+			continue
+		}
+		if run++; run == nChunks {
+			off := i - nChunks + 1
+			for j := off; j <= i; j++ {
+				s.used[j] = true
+			}
+			s.allocs[off] = nChunks
+			return off
+		}
+	}
+	return -1
+}
+
+func mapLocked(n int) []byte {
+	b, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil
+	}
+	if err = syscall.Mlock(b); err != nil {
+		syscall.Munmap(b)
+		return nil
+	}
+	return b
+}
+
+func unmapLocked(b []byte) {
+	utils.ExplicitBzero(b)
+	syscall.Munlock(b)
+	syscall.Munmap(b)
+}
+
+// Alloc returns a zeroed n byte slice backed by memory locked into RAM,
+// falling back to regular allocation if the memory can not be mapped or
+// locked (eg: due to RLIMIT_MEMLOCK).  Allocations of up to a page are
+// packed together into shared locked pages.
+func Alloc(n int) []byte {
+	if n <= 0 {
+		return []byte{}
+	}
+
+	mappingsLock.Lock()
+	defer mappingsLock.Unlock()
+
+	if n > pageSize {
+		b := mapLocked(n)
+		if b == nil {
+			return make([]byte, n)
+		}
+		mappings[&b[0]] = b
+		return b
+	}
+
+	nChunks := (n + chunkSize - 1) / chunkSize
+	for _, s := range slabs {
+		if off := s.alloc(nChunks); off >= 0 {
+			return s.mem[off*chunkSize : off*chunkSize+n : off*chunkSize+n]
+		}
+	}
+	s := newSlab()
+	if s == nil {
+		return make([]byte, n)
+	}
+	off := s.alloc(nChunks)
+	return s.mem[off*chunkSize : off*chunkSize+n : off*chunkSize+n]
+}
+
+// Free clears the slice b, and releases it if it was returned by Alloc.
+func Free(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	utils.ExplicitBzero(b)
+
+	mappingsLock.Lock()
+	defer mappingsLock.Unlock()
+
+	addr := uintptr(unsafe.Pointer(&b[0]))
+	base := addr &^ uintptr(pageSize-1)
+	if s, ok := slabs[base]; ok {
+		off := int(addr-base) / chunkSize
+		nChunks, ok := s.allocs[off]
+		if !ok {
+			return
+		}
+		delete(s.allocs, off)
+		utils.ExplicitBzero(s.mem[off*chunkSize : (off+nChunks)*chunkSize])
+		for i := off; i < off+nChunks; i++ {
+			s.used[i] = false
+		}
+		if len(s.allocs) == 0 {
+			delete(slabs, base)
+			unmapLocked(s.mem)
+		}
+		return
+	}
+
+	m, ok := mappings[&b[0]]
+	if !ok {
+		return
+	}
+	delete(mappings, &b[0])
+	unmapLocked(m)
+}
@@ -0,0 +1,149 @@
+// secmem_linux_test.go - Linux secure memory allocation tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secmem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/katzenpost/core/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedKB returns the value of the Locked field of the /proc/self/smaps
+// entry for the mapping containing addr.
+func lockedKB(t *testing.T, addr uintptr) int {
+	f, err := os.Open("/proc/self/smaps")
+	require.NoError(t, err, "Open(/proc/self/smaps)")
+	defer f.Close()
+
+	inMapping, found := false, false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.Contains(fields[0], "-") {
+			// Mapping header (eg: "7f0000000000-7f0000001000 rw-p ...").
+			var start, end uintptr
+			_, err = fmt.Sscanf(fields[0], "%x-%x", &start, &end)
+			require.NoError(t, err, "Sscanf(mapping)")
+			inMapping = addr >= start && addr < end
+			found = found || inMapping
+			continue
+		}
+		if inMapping && strings.HasPrefix(line, "Locked:") {
+			var kb int
+			_, err = fmt.Sscanf(strings.TrimPrefix(line, "Locked:"), "%d", &kb)
+			require.NoError(t, err, "Sscanf(Locked)")
+			return kb
+		}
+	}
+	require.NoError(t, scanner.Err(), "Scan()")
+	require.True(t, found, "mapping not found in /proc/self/smaps")
+	return 0
+}
+
+func TestAllocLocked(t *testing.T) {
+	require := require.New(t)
+
+	const n = 64
+	b := Alloc(n)
+	require.Len(b, n, "Alloc()")
+	require.True(utils.CtIsZero(b), "Alloc() returned non-zero memory")
+	addr := uintptr(unsafe.Pointer(&b[0]))
+
+	mappingsLock.Lock()
+	nSlabs := len(slabs)
+	mappingsLock.Unlock()
+	if nSlabs == 0 {
+		t.Skip("secmem: unable to lock memory, RLIMIT_MEMLOCK is likely too low")
+	}
+
+	for i := range b {
+		b[i] = byte(i)
+	}
+	require.True(lockedKB(t, addr) > 0, "allocation is not locked")
+
+	// Small allocations share a page, and do not overlap.
+	b2 := Alloc(n / 2)
+	require.Len(b2, n/2, "Alloc()")
+	require.Equal(n/2, cap(b2), "Alloc() capacity extends past the allocation")
+	require.True(utils.CtIsZero(b2), "Alloc() returned non-zero memory")
+	addr2 := uintptr(unsafe.Pointer(&b2[0]))
+	require.Equal(addr&^uintptr(pageSize-1), addr2&^uintptr(pageSize-1), "allocations are not packed")
+	require.True(addr2 >= addr+n || addr2+n/2 <= addr, "allocations overlap")
+	mappingsLock.Lock()
+	require.Len(slabs, 1, "Alloc() did not reuse the slab")
+	mappingsLock.Unlock()
+
+	// The slab is released once all of its allocations are freed.
+	Free(b)
+	mappingsLock.Lock()
+	require.Len(slabs, 1, "Free() released a slab in use")
+	mappingsLock.Unlock()
+	Free(b2)
+	mappingsLock.Lock()
+	require.Len(slabs, 0, "Free() did not release the slab")
+	mappingsLock.Unlock()
+
+	// Allocations larger than a page get a dedicated mapping.
+	b = Alloc(pageSize + 1)
+	require.Len(b, pageSize+1, "Alloc()")
+	require.True(lockedKB(t, uintptr(unsafe.Pointer(&b[0]))) > 0, "large allocation is not locked")
+	mappingsLock.Lock()
+	require.Len(mappings, 1, "Alloc() did not map a large allocation")
+	mappingsLock.Unlock()
+	Free(b)
+	mappingsLock.Lock()
+	require.Len(mappings, 0, "Free() did not release the mapping")
+	mappingsLock.Unlock()
+
+	// Free() of regular memory just clears it.
+	b = []byte{1, 2, 3}
+	Free(b)
+	require.True(utils.CtIsZero(b), "Free() did not clear regular memory")
+	require.Len(Alloc(0), 0, "Alloc(0)")
+}
+
+func TestAllocManyKeys(t *testing.T) {
+	require := require.New(t)
+
+	// Many key sized allocations fit in a handful of pages.
+	const nKeys = 1024
+	keys := make([][]byte, 0, nKeys)
+	for i := 0; i < nKeys; i++ {
+		keys = append(keys, Alloc(64))
+	}
+	mappingsLock.Lock()
+	nSlabs := len(slabs)
+	mappingsLock.Unlock()
+	if nSlabs == 0 {
+		t.Skip("secmem: unable to lock memory, RLIMIT_MEMLOCK is likely too low")
+	}
+	require.True(nSlabs <= nKeys*64/pageSize+1, "allocations are not packed: %d slabs", nSlabs)
+
+	for _, k := range keys {
+		Free(k)
+	}
+	mappingsLock.Lock()
+	require.Len(slabs, 0, "Free() did not release the slabs")
+	mappingsLock.Unlock()
+}
@@ -0,0 +1,76 @@
+// sessionkeys.go - Session key derivation.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package sessionkeys provides HKDF based derivation of multiple keys from a
+// single session secret.
+package sessionkeys
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// MinKeyLength is the minimum length of a derived key in bytes.
+const MinKeyLength = 16
+
+var (
+	// ErrKeyTooShort is the error returned when the requested key length
+	// is less than MinKeyLength.
+	ErrKeyTooShort = errors.New("sessionkeys: key length too short")
+
+	// ErrInvalidKeyCount is the error returned when the requested number
+	// of keys is not positive.
+	ErrInvalidKeyCount = errors.New("sessionkeys: invalid number of keys")
+)
+
+// DeriveSessionKeys derives n independent keyLen byte keys from the
+// masterSecret using HKDF-SHA256, with no salt.  The i-th key is expanded
+// with the info string prefixed by the big endian 32 bit counter i, so
+// the keys for different purposes must use different info strings.
+func DeriveSessionKeys(masterSecret []byte, info string, n int, keyLen int) ([][]byte, error) {
+	if keyLen < MinKeyLength {
+		return nil, ErrKeyTooShort
+	}
+	if n <= 0 {
+		return nil, ErrInvalidKeyCount
+	}
+
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		keyInfo := make([]byte, 4, 4+len(info))
+		binary.BigEndian.PutUint32(keyInfo, uint32(i))
+		keyInfo = append(keyInfo, info...)
+
+		k, err := deriveKey(masterSecret, nil, keyInfo, keyLen)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func deriveKey(secret, salt, info []byte, keyLen int) ([]byte, error) {
+	k := make([]byte, keyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
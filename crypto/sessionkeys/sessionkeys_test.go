@@ -0,0 +1,88 @@
+// sessionkeys_test.go - Session key derivation tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sessionkeys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestDeriveKeyVectors(t *testing.T) {
+	require := require.New(t)
+
+	// RFC 5869 Appendix A, the HKDF-SHA256 test cases.
+	for i, v := range []struct {
+		ikm, salt, info, okm []byte
+	}{
+		{
+			ikm:  bytes.Repeat([]byte{0x0b}, 22),
+			salt: mustDecodeHex("000102030405060708090a0b0c"),
+			info: mustDecodeHex("f0f1f2f3f4f5f6f7f8f9"),
+			okm:  mustDecodeHex("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"),
+		},
+		{
+			ikm: bytes.Repeat([]byte{0x0b}, 22),
+			okm: mustDecodeHex("8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8"),
+		},
+	} {
+		okm, err := deriveKey(v.ikm, v.salt, v.info, len(v.okm))
+		require.NoError(err, "deriveKey(): %d", i)
+		require.Equal(v.okm, okm, "deriveKey(): %d", i)
+	}
+}
+
+func TestDeriveSessionKeys(t *testing.T) {
+	require := require.New(t)
+
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	keys, err := DeriveSessionKeys(secret, "wire", 2, 32)
+	require.NoError(err)
+	require.Len(keys, 2)
+	for i, k := range keys {
+		require.Len(k, 32)
+		expected, err := deriveKey(secret, nil, append([]byte{0, 0, 0, byte(i)}, "wire"...), 32)
+		require.NoError(err)
+		require.Equal(expected, k, "key %d", i)
+	}
+	require.NotEqual(keys[0], keys[1])
+
+	// Derivation is deterministic, and separated by the info string.
+	again, err := DeriveSessionKeys(secret, "wire", 2, 32)
+	require.NoError(err)
+	require.Equal(keys, again)
+	other, err := DeriveSessionKeys(secret, "other", 2, 32)
+	require.NoError(err)
+	require.NotEqual(keys[0], other[0])
+
+	_, err = DeriveSessionKeys(secret, "wire", 2, MinKeyLength-1)
+	require.Equal(ErrKeyTooShort, err)
+	_, err = DeriveSessionKeys(secret, "wire", 0, 32)
+	require.Equal(ErrInvalidKeyCount, err)
+	_, err = DeriveSessionKeys(secret, "wire", 1, 255*32+1)
+	require.Error(err, "DeriveSessionKeys(too long)")
+}
@@ -0,0 +1,127 @@
+// docstore.go - Epoch keyed PKI document store.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package docstore provides on-disk storage of PKI documents by epoch.
+package docstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+const fileExt = ".cbor"
+
+// DocumentStore stores serialized PKI documents in a directory, one file per
+// epoch, and garbage collects the documents of old epochs.  It is safe for
+// concurrent use.
+type DocumentStore struct {
+	sync.Mutex
+
+	dir        string
+	keepEpochs uint64
+	clock      epochtime.EpochClock
+}
+
+// NewDocumentStore creates a DocumentStore backed by the directory dir,
+// that retains the documents of the keepEpochs most recent epochs up to and
+// including the current one.
+func NewDocumentStore(dir string, keepEpochs uint64) *DocumentStore {
+	return &DocumentStore{
+		dir:        dir,
+		keepEpochs: keepEpochs,
+		clock:      new(epochtime.Clock),
+	}
+}
+
+func (s *DocumentStore) path(epoch uint64) string {
+	return filepath.Join(s.dir, strconv.FormatUint(epoch, 10)+fileExt)
+}
+
+// Put stores rawDoc as the document for epoch, replacing any existing
+// document.
+func (s *DocumentStore) Put(epoch uint64, rawDoc []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// Write to a temporary file first, so that a partially written
+	// document is never visible.
+	f, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	_, err = f.Write(rawDoc)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, s.path(epoch))
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// Get returns the document for epoch.  If there is none, the error returned
+// satisfies os.IsNotExist.
+func (s *DocumentStore) Get(epoch uint64) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return ioutil.ReadFile(s.path(epoch))
+}
+
+// GC deletes the documents of every epoch older than the keepEpochs most
+// recent epochs, and returns the number of documents deleted.
+func (s *DocumentStore) GC() int {
+	s.Lock()
+	defer s.Unlock()
+
+	now, _, _ := s.clock.Now()
+	if now < s.keepEpochs {
+		return 0
+	}
+	oldest := now - s.keepEpochs + 1
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return 0
+	}
+	deleted := 0
+	for _, ent := range entries {
+		name := ent.Name()
+		if ent.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		epoch, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 10, 64)
+		if err != nil || epoch >= oldest {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err == nil {
+			deleted++
+		}
+	}
+	return deleted
+}
+
@@ -0,0 +1,70 @@
+// docstore_test.go - Epoch keyed PKI document store tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package docstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentStore(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "docstore")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	const (
+		current    = 1000
+		nrDocs     = 20
+		keepEpochs = 5
+	)
+	s := NewDocumentStore(dir, keepEpochs)
+	s.clock = epochtime.NewFakeEpochClock(current)
+
+	_, err = s.Get(current)
+	require.True(os.IsNotExist(err), "Get() with no document: %v", err)
+
+	for epoch := uint64(current - nrDocs + 1); epoch <= current; epoch++ {
+		require.NoError(s.Put(epoch, []byte(fmt.Sprintf("document %d", epoch))), "Put(%d)", epoch)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.cbor"))
+	require.NoError(err)
+	require.Len(files, nrDocs)
+
+	// Only the documents of the 5 most recent epochs are retained.
+	require.Equal(nrDocs-keepEpochs, s.GC(), "GC()")
+	require.Zero(s.GC(), "GC() with no old documents")
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(err)
+	require.Len(files, keepEpochs)
+	for epoch := uint64(current - nrDocs + 1); epoch <= current; epoch++ {
+		b, err := s.Get(epoch)
+		if epoch <= current-keepEpochs {
+			require.True(os.IsNotExist(err), "Get(%d) after GC(): %v", epoch, err)
+			continue
+		}
+		require.NoError(err, "Get(%d)", epoch)
+		require.Equal(fmt.Sprintf("document %d", epoch), string(b))
+	}
+}
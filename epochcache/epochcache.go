@@ -0,0 +1,83 @@
+// epochcache.go - Epoch scoped cache.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package epochcache implements a cache whose entries are only valid for the
+// Katzenpost epoch they were stored in.
+package epochcache
+
+import (
+	"sync"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+// Cache is a key value cache that is purged whenever the epoch changes.  It
+// is safe for concurrent use.
+type Cache struct {
+	sync.Mutex
+
+	clock   epochtime.EpochClock
+	epoch   uint64
+	entries map[string]interface{}
+}
+
+// purgeIfStaleLocked drops all entries if the epoch has changed since they
+// were stored.
+func (c *Cache) purgeIfStaleLocked() {
+	now, _, _ := c.clock.Now()
+	if now != c.epoch {
+		c.entries = make(map[string]interface{})
+		c.epoch = now
+	}
+}
+
+// Set stores the value under key for the current epoch.
+func (c *Cache) Set(key string, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.purgeIfStaleLocked()
+	c.entries[key] = value
+}
+
+// Get returns the value stored under key, and true iff the value was stored
+// in the current epoch.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.purgeIfStaleLocked()
+	v, ok := c.entries[key]
+	return v, ok
+}
+
+// Invalidate drops all entries.
+func (c *Cache) Invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[string]interface{})
+}
+
+// New returns a new Cache, using clock as the source of the current epoch.
+func New(clock epochtime.EpochClock) *Cache {
+	c := &Cache{
+		clock:   clock,
+		entries: make(map[string]interface{}),
+	}
+	c.epoch, _, _ = clock.Now()
+	return c
+}
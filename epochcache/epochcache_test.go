@@ -0,0 +1,58 @@
+// epochcache_test.go - Epoch scoped cache tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package epochcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	require := require.New(t)
+
+	clock := epochtime.NewFakeEpochClock(10)
+	c := New(clock)
+
+	_, ok := c.Get("doc")
+	require.False(ok, "Get(empty)")
+	c.Set("doc", 1234)
+	v, ok := c.Get("doc")
+	require.True(ok, "Get(epoch 10)")
+	require.Equal(1234, v)
+
+	// Entries survive within the epoch.
+	clock.Advance(epochtime.Period - time.Second)
+	_, ok = c.Get("doc")
+	require.True(ok, "Get(end of epoch 10)")
+
+	// Entries stored in epoch 10 are invisible in epoch 11.
+	clock.Advance(time.Second)
+	_, ok = c.Get("doc")
+	require.False(ok, "Get(epoch 11)")
+
+	// Explicit invalidation.
+	c.Set("doc", 4321)
+	v, ok = c.Get("doc")
+	require.True(ok, "Get(epoch 11)")
+	require.Equal(4321, v)
+	c.Invalidate()
+	_, ok = c.Get("doc")
+	require.False(ok, "Get(after Invalidate)")
+}
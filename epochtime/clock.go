@@ -0,0 +1,86 @@
+// clock.go - Katzenpost epoch clock interface.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package epochtime
+
+import (
+	"sync"
+	"time"
+)
+
+// EpochClock is the interface to a source of the current Katzenpost epoch,
+// satisfied by Clock and FakeEpochClock.
+type EpochClock interface {
+	// Now returns the current Katzenpost epoch, time since the start of
+	// the current epoch, and time till the next epoch.
+	Now() (current uint64, elapsed, till time.Duration)
+}
+
+// DurationUntilEpoch returns the time remaining until the start of the epoch
+// according to the clock c, or zero if the epoch has already started.
+func DurationUntilEpoch(c EpochClock, epoch uint64) time.Duration {
+	current, _, till := c.Now()
+	if epoch <= current {
+		return 0
+	}
+	return till + time.Duration(epoch-current-1)*Period
+}
+
+// WallTime returns the current wall clock time according to the clock c.
+func WallTime(c EpochClock) time.Time {
+	current, elapsed, _ := c.Now()
+	return Epoch.Add(time.Duration(current)*Period + elapsed)
+}
+
+// FakeEpochClock is an EpochClock that only advances when told to, for use
+// in tests.  It is safe for concurrent use.
+type FakeEpochClock struct {
+	sync.Mutex
+
+	now time.Time
+}
+
+// Now returns the fake clock's Katzenpost epoch, time since the start of the
+// epoch, and time till the next epoch.
+func (c *FakeEpochClock) Now() (current uint64, elapsed, till time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	return getEpoch(c.now)
+}
+
+// Advance advances the fake clock by d.
+func (c *FakeEpochClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// SetEpoch sets the fake clock to the start of the epoch.
+func (c *FakeEpochClock) SetEpoch(epoch uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = Epoch.Add(time.Duration(epoch) * Period)
+}
+
+// NewFakeEpochClock returns a FakeEpochClock set to the start of the epoch.
+func NewFakeEpochClock(epoch uint64) *FakeEpochClock {
+	c := new(FakeEpochClock)
+	c.SetEpoch(epoch)
+	return c
+}
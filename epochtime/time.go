@@ -17,7 +17,16 @@
 // Package epochtime implements Katzenpost epoch related timekeeping functions.
 package epochtime
 
-import "time"
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/core/crypto/rand"
+)
 
 // Period is the duration of a Katzenpost epoch.
 var Period = 20 * time.Minute
@@ -28,12 +37,102 @@ var WarpedEpoch string
 // Epoch is the Katzenpost epoch expressed in UTC.
 var Epoch = time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
 
+// ErrInvalidEpochText is the error returned when the text representation of
+// an EpochNumber is malformed.
+var ErrInvalidEpochText = errors.New("epochtime: invalid epoch text")
+
+// EpochNumber is a Katzenpost epoch number.
+type EpochNumber uint64
+
+// MarshalText implements the encoding.TextMarshaler interface, and returns
+// the decimal representation of the epoch.
+func (e EpochNumber) MarshalText() ([]byte, error) {
+	return strconv.AppendUint(nil, uint64(e), 10), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (e *EpochNumber) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 10, 64)
+	if err != nil {
+		return ErrInvalidEpochText
+	}
+	*e = EpochNumber(v)
+	return nil
+}
+
+// MarshalCBOR implements the cbor.Marshaler interface, and encodes the epoch
+// as a CBOR text string.
+func (e EpochNumber) MarshalCBOR() ([]byte, error) {
+	text, err := e.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(string(text))
+}
+
+// UnmarshalCBOR implements the cbor.Unmarshaler interface.
+func (e *EpochNumber) UnmarshalCBOR(data []byte) error {
+	var text string
+	if err := cbor.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return e.UnmarshalText([]byte(text))
+}
+
 // Now returns the current Katzenpost epoch, time since the start of the
 // current epoch, and time till the next epoch.
 func Now() (current uint64, elapsed, till time.Duration) {
 	return getEpoch(time.Now())
 }
 
+// Clock is a Katzenpost epoch clock.  The zero value is a Clock backed by the
+// system wall clock.
+type Clock struct {
+	nowFn func() time.Time
+}
+
+func (c *Clock) wallNow() time.Time {
+	if c.nowFn == nil {
+		return time.Now()
+	}
+	return c.nowFn()
+}
+
+// Now returns the current Katzenpost epoch, time since the start of the
+// current epoch, and time till the next epoch.
+func (c *Clock) Now() (current uint64, elapsed, till time.Duration) {
+	return getEpoch(c.wallNow())
+}
+
+// NowWithJitter returns the current Katzenpost epoch, time since the start of
+// the current epoch, and time till the next epoch, with the current time
+// offset by a uniformly random jitter in [-maxJitter, +maxJitter] sampled from
+// a cryptographic entropy source.  The returned values are all relative to
+// the jittered time, so the epoch may differ from that returned by Now() if
+// the jitter crosses an epoch boundary.
+func (c *Clock) NowWithJitter(maxJitter time.Duration) (current uint64, elapsed, till time.Duration) {
+	t := c.wallNow()
+	if maxJitter > 0 {
+		t = t.Add(uniformJitter(rand.Reader, maxJitter))
+	}
+	return getEpoch(t)
+}
+
+func uniformJitter(r io.Reader, maxJitter time.Duration) time.Duration {
+	// Rejection sample [0, 2*maxJitter] to avoid modulo bias.
+	span := 2*uint64(maxJitter) + 1
+	limit := ^uint64(0) - (^uint64(0) % span)
+	var tmp [8]byte
+	for {
+		if _, err := io.ReadFull(r, tmp[:]); err != nil {
+			panic("epochtime: failed to read entropy: " + err.Error())
+		}
+		if v := binary.LittleEndian.Uint64(tmp[:]); v < limit {
+			return time.Duration(v%span) - maxJitter
+		}
+	}
+}
+
 // IsInEpoch returns true iff the epoch e contains the time t, measured in the
 // number of seconds since the UNIX epoch.
 func IsInEpoch(e uint64, t uint64) bool {
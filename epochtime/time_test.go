@@ -17,9 +17,11 @@
 package epochtime
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -46,3 +48,103 @@ func TestIsInEpoch(t *testing.T) {
 	prevNow := now - 3*60*60
 	assert.False(IsInEpoch(e, prevNow), "IsInEpoch(e, now-3h)")
 }
+
+func TestNowWithJitter(t *testing.T) {
+	require := require.New(t)
+
+	// Pin the clock to the middle of an epoch.
+	now := Epoch.Add(1000*Period + Period/2)
+	c := &Clock{nowFn: func() time.Time { return now }}
+	e, elapsed, till := c.Now()
+	require.Equal(uint64(1000), e, "Now() epoch")
+
+	// No jitter is identical to Now().
+	je, jElapsed, jTill := c.NowWithJitter(0)
+	require.Equal(e, je, "NowWithJitter(0) epoch")
+	require.Equal(elapsed, jElapsed, "NowWithJitter(0) elapsed")
+	require.Equal(till, jTill, "NowWithJitter(0) till")
+
+	// A jitter of a full Period can land in the adjacent epochs.
+	seen := make(map[uint64]bool)
+	for i := 0; i < 1000; i++ {
+		je, jElapsed, jTill = c.NowWithJitter(Period)
+		require.True(je >= e-1 && je <= e+1, "NowWithJitter(Period) epoch out of range")
+		require.Equal(Period, jElapsed+jTill, "NowWithJitter(Period) elapsed + till")
+		seen[je] = true
+	}
+	require.True(seen[e-1], "NowWithJitter(Period) never moved to the previous epoch")
+	require.True(seen[e+1], "NowWithJitter(Period) never moved to the next epoch")
+
+	// The zero value Clock uses the wall clock.
+	var wall Clock
+	require.NotPanics(func() { wall.NowWithJitter(time.Second) }, "Basic NowWithJitter() sanity check")
+}
+
+func TestEpochText(t *testing.T) {
+	require := require.New(t)
+
+	b, err := json.Marshal(map[EpochNumber]string{14387: "doc"})
+	require.NoError(err, "json.Marshal()")
+	require.Equal(`{"14387":"doc"}`, string(b))
+
+	var m map[EpochNumber]string
+	err = json.Unmarshal(b, &m)
+	require.NoError(err, "json.Unmarshal()")
+	require.Equal(map[EpochNumber]string{14387: "doc"}, m)
+
+	var e EpochNumber
+	for _, v := range []string{"", "abc", "-1", "+1", "1.5", "18446744073709551616"} {
+		require.Equal(ErrInvalidEpochText, e.UnmarshalText([]byte(v)), "UnmarshalText(%q)", v)
+	}
+
+	type doc struct {
+		Epoch EpochNumber
+	}
+	b, err = cbor.Marshal(&doc{Epoch: 14387})
+	require.NoError(err, "cbor.Marshal()")
+	b2, err := cbor.Marshal(map[string]string{"Epoch": "14387"})
+	require.NoError(err, "cbor.Marshal()")
+	require.Equal(b2, b, "CBOR encoding is not the text form")
+
+	var d doc
+	err = cbor.Unmarshal(b, &d)
+	require.NoError(err, "cbor.Unmarshal()")
+	require.Equal(EpochNumber(14387), d.Epoch)
+}
+
+func TestFakeEpochClock(t *testing.T) {
+	require := require.New(t)
+
+	var _ EpochClock = new(Clock)
+	c := NewFakeEpochClock(10)
+	current, elapsed, till := c.Now()
+	require.Equal(uint64(10), current)
+	require.Zero(elapsed)
+	require.Equal(Period, till)
+
+	c.Advance(Period - time.Second)
+	current, elapsed, till = c.Now()
+	require.Equal(uint64(10), current)
+	require.Equal(Period-time.Second, elapsed)
+	require.Equal(time.Second, till)
+
+	c.Advance(time.Second)
+	current, _, _ = c.Now()
+	require.Equal(uint64(11), current)
+
+	c.Advance(time.Nanosecond)
+	require.Equal(Epoch.Add(11*Period+time.Nanosecond), WallTime(c))
+	now := time.Now()
+	require.WithinDuration(now, WallTime(&Clock{nowFn: func() time.Time { return now }}), 0)
+}
+
+func TestDurationUntilEpoch(t *testing.T) {
+	require := require.New(t)
+
+	c := NewFakeEpochClock(10)
+	c.Advance(time.Minute)
+	require.Equal(Period-time.Minute, DurationUntilEpoch(c, 11))
+	require.Equal(2*Period-time.Minute, DurationUntilEpoch(c, 12))
+	require.Zero(DurationUntilEpoch(c, 10))
+	require.Zero(DurationUntilEpoch(c, 9))
+}
@@ -1,6 +1,6 @@
 module github.com/katzenpost/core
 
-go 1.12
+go 1.13
 
 require (
 	git.schwanenlied.me/yawning/aez.git v0.0.0-20180408160647-ec7426b44926
@@ -0,0 +1,27 @@
+// anonymize.go - PKI document anonymization.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki
+
+import "github.com/katzenpost/core/crypto/cert"
+
+// AnonymizeDocument returns the signed document rawDoc with every authority
+// signature, and thus every authority identity, removed, for sharing when
+// debugging.  The routing information is preserved, but the result will no
+// longer verify.
+func AnonymizeDocument(rawDoc []byte) ([]byte, error) {
+	return cert.StripSignatures(rawDoc)
+}
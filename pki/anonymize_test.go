@@ -0,0 +1,76 @@
+// anonymize_test.go - PKI document anonymization tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeDocument(t *testing.T) {
+	require := require.New(t)
+
+	const epoch = 12345
+	keys := []*eddsa.PrivateKey{
+		testpki.DeriveKeypair("authority0"),
+		testpki.DeriveKeypair("authority1"),
+		testpki.DeriveKeypair("authority2"),
+	}
+	verifiers := []cert.Verifier{}
+	for _, k := range keys {
+		verifiers = append(verifiers, k.PublicKey())
+	}
+	rawDoc, err := testpki.SignTestDocument(testpki.NewTestDocument(epoch, 6, 2), keys...)
+	require.NoError(err, "SignTestDocument()")
+	_, _, _, err = cert.VerifyThreshold(verifiers, 2, rawDoc)
+	require.NoError(err, "VerifyThreshold()")
+
+	anonDoc, err := pki.AnonymizeDocument(rawDoc)
+	require.NoError(err, "AnonymizeDocument()")
+	sigs, err := cert.GetSignatures(anonDoc)
+	require.NoError(err, "GetSignatures()")
+	require.Empty(sigs)
+	_, _, _, err = cert.VerifyThreshold(verifiers, 2, anonDoc)
+	require.Error(err, "VerifyThreshold(anonymized)")
+
+	// The routing information is intact.
+	payload, err := cert.GetCertified(anonDoc)
+	require.NoError(err, "GetCertified()")
+	doc := new(pki.Document)
+	require.NoError(json.Unmarshal(payload, doc), "json.Unmarshal()")
+	require.EqualValues(epoch, doc.Epoch)
+
+	mix, err := doc.GetMix("mix4")
+	require.NoError(err, "GetMix()")
+	require.EqualValues(1, mix.Layer)
+	byKey, err := doc.GetMixByKey(mix.IdentityKey.Bytes())
+	require.NoError(err, "GetMixByKey()")
+	require.Equal(mix.Name, byKey.Name)
+	layer, err := doc.GetMixesInLayer(2)
+	require.NoError(err, "GetMixesInLayer()")
+	require.Len(layer, 2)
+	provider, err := doc.GetProviderByKey(testpki.DeriveKeypair("provider1").PublicKey().Bytes())
+	require.NoError(err, "GetProviderByKey()")
+	require.Equal("provider1", provider.Name)
+	require.Contains(provider.MixKeys, uint64(epoch))
+}
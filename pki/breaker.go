@@ -0,0 +1,111 @@
+// breaker.go - Epoch aware PKI circuit breaker.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+// ErrNoCachedDocument is the error returned when the circuit breaker is
+// tripped, and no document has ever been successfully fetched.
+var ErrNoCachedDocument = errors.New("pki: circuit breaker tripped with no cached document")
+
+// EpochCircuitBreaker wraps a Client, and stops fetching documents after a
+// number of consecutive failures within an epoch, serving the last good
+// document instead until the next epoch.  It is safe for concurrent use.
+type EpochCircuitBreaker struct {
+	sync.Mutex
+
+	client      Client
+	clock       epochtime.EpochClock
+	maxFailures int
+
+	epoch    uint64
+	failures int
+	lastGood *Document
+}
+
+// NewEpochCircuitBreaker returns a new EpochCircuitBreaker around client,
+// that trips after maxFailures consecutive failures within an epoch, as
+// determined by clock.
+func NewEpochCircuitBreaker(client Client, maxFailures int, clock epochtime.EpochClock) *EpochCircuitBreaker {
+	if maxFailures <= 0 {
+		panic("pki: invalid circuit breaker maxFailures")
+	}
+	now, _, _ := clock.Now()
+	return &EpochCircuitBreaker{
+		client:      client,
+		clock:       clock,
+		maxFailures: maxFailures,
+		epoch:       now,
+	}
+}
+
+// GetDocument returns the PKI document for the provided epoch.  If the
+// breaker is tripped, or trips with this call, the last successfully fetched
+// document (which may be for a different epoch) is returned instead, with
+// degraded set.
+func (b *EpochCircuitBreaker) GetDocument(ctx context.Context, epoch uint64) (doc *Document, degraded bool, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if now, _, _ := b.clock.Now(); now != b.epoch {
+		b.epoch = now
+		b.failures = 0
+	}
+	if b.isTripped() {
+		return b.degraded()
+	}
+
+	doc, _, err = b.client.Get(ctx, epoch)
+	if err == nil {
+		b.failures = 0
+		b.lastGood = doc
+		return doc, false, nil
+	}
+	b.failures++
+	if b.isTripped() {
+		return b.degraded()
+	}
+	return nil, false, err
+}
+
+// IsTripped returns true iff the breaker is tripped for the current epoch.
+func (b *EpochCircuitBreaker) IsTripped() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if now, _, _ := b.clock.Now(); now != b.epoch {
+		return false
+	}
+	return b.isTripped()
+}
+
+func (b *EpochCircuitBreaker) isTripped() bool {
+	return b.failures >= b.maxFailures
+}
+
+func (b *EpochCircuitBreaker) degraded() (*Document, bool, error) {
+	if b.lastGood == nil {
+		return nil, true, ErrNoCachedDocument
+	}
+	return b.lastGood, true, nil
+}
@@ -0,0 +1,118 @@
+// breaker_test.go - Epoch aware PKI circuit breaker tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+var errMockFetch = errors.New("mock fetch failure")
+
+type mockClient struct {
+	fail  bool
+	calls int
+}
+
+func (c *mockClient) Get(ctx context.Context, epoch uint64) (*pki.Document, []byte, error) {
+	c.calls++
+	if c.fail {
+		return nil, nil, errMockFetch
+	}
+	return testpki.NewTestDocument(epoch, 3, 1), nil, nil
+}
+
+func (c *mockClient) Post(ctx context.Context, epoch uint64, signingKey *eddsa.PrivateKey, d *pki.MixDescriptor) error {
+	return errors.New("not implemented")
+}
+
+func (c *mockClient) Deserialize(raw []byte) (*pki.Document, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestEpochCircuitBreaker(t *testing.T) {
+	require := require.New(t)
+
+	const maxFailures = 3
+	ctx := context.Background()
+	clock := epochtime.NewFakeEpochClock(100)
+	client := new(mockClient)
+	b := pki.NewEpochCircuitBreaker(client, maxFailures, clock)
+
+	doc, degraded, err := b.GetDocument(ctx, 101)
+	require.NoError(err)
+	require.False(degraded)
+	require.EqualValues(101, doc.Epoch)
+
+	// The breaker trips after maxFailures consecutive failures.
+	client.fail = true
+	for i := 0; i < maxFailures-1; i++ {
+		_, degraded, err = b.GetDocument(ctx, 101)
+		require.Equal(errMockFetch, err)
+		require.False(degraded)
+		require.False(b.IsTripped())
+	}
+	doc, degraded, err = b.GetDocument(ctx, 101)
+	require.NoError(err)
+	require.True(degraded)
+	require.EqualValues(101, doc.Epoch)
+	require.True(b.IsTripped())
+	require.Equal(1+maxFailures, client.calls)
+
+	// Once tripped, the client is not queried for the rest of the epoch,
+	// even if it has recovered.
+	client.fail = false
+	doc, degraded, err = b.GetDocument(ctx, 102)
+	require.NoError(err)
+	require.True(degraded)
+	require.EqualValues(101, doc.Epoch)
+	require.Equal(1+maxFailures, client.calls)
+
+	// The breaker resets in the next epoch.
+	clock.SetEpoch(101)
+	require.False(b.IsTripped())
+	doc, degraded, err = b.GetDocument(ctx, 102)
+	require.NoError(err)
+	require.False(degraded)
+	require.EqualValues(102, doc.Epoch)
+	require.Equal(2+maxFailures, client.calls)
+
+	// Failures in the previous epoch do not count towards tripping.
+	client.fail = true
+	for i := 0; i < maxFailures-1; i++ {
+		_, _, err = b.GetDocument(ctx, 102)
+		require.Equal(errMockFetch, err)
+	}
+	clock.SetEpoch(102)
+	_, degraded, err = b.GetDocument(ctx, 103)
+	require.Equal(errMockFetch, err)
+	require.False(degraded)
+
+	// Without a good document to fall back on, tripping is an error.
+	b = pki.NewEpochCircuitBreaker(client, 1, clock)
+	doc, degraded, err = b.GetDocument(ctx, 103)
+	require.Equal(pki.ErrNoCachedDocument, err)
+	require.True(degraded)
+	require.Nil(doc)
+}
@@ -0,0 +1,96 @@
+// cache.go - PKI document cache.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+// ErrNoCachedEpoch is the error returned when the DocumentCache has no
+// document for the requested epoch.
+var ErrNoCachedEpoch = errors.New("pki: no cached document for epoch")
+
+// DocumentCache holds the PKI documents for the current and the next epoch,
+// so that both are available during epoch transitions.  Documents for
+// previous epochs are evicted as the epoch advances.  It is safe for
+// concurrent use.
+type DocumentCache struct {
+	sync.Mutex
+
+	clock epochtime.EpochClock
+	docs  map[uint64]*Document
+}
+
+// Put stores doc, which must be for either the current or the next epoch.
+func (c *DocumentCache) Put(doc *Document) error {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.evictLocked()
+	if doc.Epoch != now && doc.Epoch != now+1 {
+		return fmt.Errorf("pki: document for epoch %v is neither current nor next (%v)", doc.Epoch, now)
+	}
+	c.docs[doc.Epoch] = doc
+	return nil
+}
+
+// GetCurrentDocument returns the document for the current epoch.
+func (c *DocumentCache) GetCurrentDocument() (*Document, error) {
+	return c.get(0)
+}
+
+// GetNextDocument returns the document for the next epoch.
+func (c *DocumentCache) GetNextDocument() (*Document, error) {
+	return c.get(1)
+}
+
+func (c *DocumentCache) get(offset uint64) (*Document, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.evictLocked()
+	doc, ok := c.docs[now+offset]
+	if !ok {
+		return nil, ErrNoCachedEpoch
+	}
+	return doc, nil
+}
+
+// evictLocked drops the documents for past epochs, and returns the current
+// epoch.
+func (c *DocumentCache) evictLocked() uint64 {
+	now, _, _ := c.clock.Now()
+	for epoch := range c.docs {
+		if epoch < now {
+			delete(c.docs, epoch)
+		}
+	}
+	return now
+}
+
+// NewDocumentCache returns a new DocumentCache, using clock as the source of
+// the current epoch.
+func NewDocumentCache(clock epochtime.EpochClock) *DocumentCache {
+	return &DocumentCache{
+		clock: clock,
+		docs:  make(map[uint64]*Document),
+	}
+}
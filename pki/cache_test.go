@@ -0,0 +1,73 @@
+// cache_test.go - PKI document cache tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentCache(t *testing.T) {
+	require := require.New(t)
+
+	clock := epochtime.NewFakeEpochClock(10)
+	c := pki.NewDocumentCache(clock)
+
+	_, err := c.GetCurrentDocument()
+	require.Equal(pki.ErrNoCachedEpoch, err)
+
+	current := testpki.NewTestDocument(10, 3, 1)
+	next := testpki.NewTestDocument(11, 3, 1)
+	require.NoError(c.Put(current))
+	require.Error(c.Put(testpki.NewTestDocument(9, 3, 1)), "Put(previous)")
+	require.Error(c.Put(testpki.NewTestDocument(12, 3, 1)), "Put(next + 1)")
+
+	doc, err := c.GetCurrentDocument()
+	require.NoError(err)
+	require.True(doc == current)
+	_, err = c.GetNextDocument()
+	require.Equal(pki.ErrNoCachedEpoch, err)
+
+	// Near the end of the epoch, the next document is fetched early, and
+	// both are available.
+	clock.Advance(epochtime.Period - time.Minute)
+	require.NoError(c.Put(next))
+	doc, err = c.GetCurrentDocument()
+	require.NoError(err)
+	require.True(doc == current)
+	doc, err = c.GetNextDocument()
+	require.NoError(err)
+	require.True(doc == next)
+
+	// Once the epoch advances, the next document becomes current.
+	clock.Advance(2 * time.Minute)
+	doc, err = c.GetCurrentDocument()
+	require.NoError(err)
+	require.True(doc == next)
+	_, err = c.GetNextDocument()
+	require.Equal(pki.ErrNoCachedEpoch, err)
+
+	// And the previous document is evicted.
+	clock.SetEpoch(10)
+	_, err = c.GetCurrentDocument()
+	require.Equal(pki.ErrNoCachedEpoch, err)
+}
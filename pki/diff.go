@@ -0,0 +1,134 @@
+// diff.go - PKI document diffs.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DocumentDiff is the difference between the nodes listed in two PKI
+// documents.  Nodes are matched across documents by IdentityKey.
+type DocumentDiff struct {
+	// AddedNodes are the nodes only present in the next document.
+	AddedNodes []*MixDescriptor
+
+	// RemovedNodes are the nodes only present in the previous document.
+	RemovedNodes []*MixDescriptor
+
+	// ModifiedNodes are the nodes present in both documents, with
+	// differing descriptors.
+	ModifiedNodes []DescriptorDiff
+}
+
+// DescriptorDiff is the difference between two descriptors of a node.
+type DescriptorDiff struct {
+	// Prev is the node's descriptor in the previous document.
+	Prev *MixDescriptor
+
+	// Next is the node's descriptor in the next document.
+	Next *MixDescriptor
+
+	// Fields are the names of the MixDescriptor fields that differ.
+	Fields []string
+}
+
+func (d *Document) nodesByIdentity() (map[[32]byte]*MixDescriptor, error) {
+	m := make(map[[32]byte]*MixDescriptor)
+	add := func(desc *MixDescriptor) error {
+		if desc.IdentityKey == nil {
+			return fmt.Errorf("pki: document contains invalid descriptors")
+		}
+		id := desc.IdentityKey.ByteArray()
+		if _, ok := m[id]; ok {
+			return fmt.Errorf("pki: document contains duplicate node '%v'", desc.Name)
+		}
+		m[id] = desc
+		return nil
+	}
+	for _, l := range d.Topology {
+		for _, v := range l {
+			if err := add(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, v := range d.Providers {
+		if err := add(v); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func diffDescriptors(prev, next *MixDescriptor) []string {
+	var fields []string
+	p, n := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < p.NumField(); i++ {
+		if !reflect.DeepEqual(p.Field(i).Interface(), n.Field(i).Interface()) {
+			fields = append(fields, p.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+// DiffDocuments returns the changes to the nodes listed in the PKI document
+// prev, that were made in the PKI document next.  Each list in the returned
+// DocumentDiff is sorted by node Name.
+func DiffDocuments(prev, next *Document) (*DocumentDiff, error) {
+	prevNodes, err := prev.nodesByIdentity()
+	if err != nil {
+		return nil, err
+	}
+	nextNodes, err := next.nodesByIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	diff := new(DocumentDiff)
+	for id, p := range prevNodes {
+		n, ok := nextNodes[id]
+		if !ok {
+			diff.RemovedNodes = append(diff.RemovedNodes, p)
+			continue
+		}
+		if fields := diffDescriptors(p, n); len(fields) > 0 {
+			diff.ModifiedNodes = append(diff.ModifiedNodes, DescriptorDiff{
+				Prev:   p,
+				Next:   n,
+				Fields: fields,
+			})
+		}
+	}
+	for id, n := range nextNodes {
+		if _, ok := prevNodes[id]; !ok {
+			diff.AddedNodes = append(diff.AddedNodes, n)
+		}
+	}
+
+	sort.Slice(diff.AddedNodes, func(i, j int) bool {
+		return diff.AddedNodes[i].Name < diff.AddedNodes[j].Name
+	})
+	sort.Slice(diff.RemovedNodes, func(i, j int) bool {
+		return diff.RemovedNodes[i].Name < diff.RemovedNodes[j].Name
+	})
+	sort.Slice(diff.ModifiedNodes, func(i, j int) bool {
+		return diff.ModifiedNodes[i].Next.Name < diff.ModifiedNodes[j].Next.Name
+	})
+	return diff, nil
+}
@@ -0,0 +1,85 @@
+// diff_test.go - PKI document diff tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki_test
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDescriptor(name string, layer uint8) *pki.MixDescriptor {
+	desc := testpki.NewTestDescriptor(name)
+	desc.Layer = layer
+	return desc
+}
+
+func TestDiffDocuments(t *testing.T) {
+	require := require.New(t)
+
+	mix1 := newTestDescriptor("mix1", 0)
+	mix2 := newTestDescriptor("mix2", 1)
+	mix3 := newTestDescriptor("mix3", 2)
+	provider := newTestDescriptor("provider", pki.LayerProvider)
+	prev := &pki.Document{
+		Epoch:     1,
+		Topology:  [][]*pki.MixDescriptor{{mix1}, {mix2}, {mix3}},
+		Providers: []*pki.MixDescriptor{provider},
+	}
+
+	// Identical documents have no differences.
+	diff, err := pki.DiffDocuments(prev, prev)
+	require.NoError(err, "DiffDocuments(prev, prev)")
+	require.Empty(diff.AddedNodes)
+	require.Empty(diff.RemovedNodes)
+	require.Empty(diff.ModifiedNodes)
+
+	// Change one node's address.
+	mix2Next := *mix2
+	mix2Next.Addresses = map[pki.Transport][]string{
+		pki.TransportTCPv4: {"198.51.100.1:29483"},
+	}
+	next := &pki.Document{
+		Epoch:     2,
+		Topology:  [][]*pki.MixDescriptor{{mix1}, {&mix2Next}, {mix3}},
+		Providers: []*pki.MixDescriptor{provider},
+	}
+	diff, err = pki.DiffDocuments(prev, next)
+	require.NoError(err, "DiffDocuments(prev, next)")
+	require.Empty(diff.AddedNodes)
+	require.Empty(diff.RemovedNodes)
+	require.Len(diff.ModifiedNodes, 1)
+	require.Equal(mix2, diff.ModifiedNodes[0].Prev)
+	require.Equal(&mix2Next, diff.ModifiedNodes[0].Next)
+	require.Equal([]string{"Addresses"}, diff.ModifiedNodes[0].Fields)
+
+	// Replace a node.
+	mix4 := newTestDescriptor("mix4", 2)
+	next.Topology[2] = []*pki.MixDescriptor{mix4}
+	diff, err = pki.DiffDocuments(prev, next)
+	require.NoError(err, "DiffDocuments(prev, next)")
+	require.Equal([]*pki.MixDescriptor{mix4}, diff.AddedNodes)
+	require.Equal([]*pki.MixDescriptor{mix3}, diff.RemovedNodes)
+	require.Len(diff.ModifiedNodes, 1)
+
+	// Invalid descriptors are rejected.
+	next.Providers = []*pki.MixDescriptor{{Name: "invalid"}}
+	_, err = pki.DiffDocuments(prev, next)
+	require.Error(err, "DiffDocuments(invalid)")
+}
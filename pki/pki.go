@@ -23,13 +23,21 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/katzenpost/core/crypto/ecdh"
 	"github.com/katzenpost/core/crypto/eddsa"
 )
 
-// LayerProvider is the Layer that providers list in their MixDescriptors.
-const LayerProvider = 255
+const (
+	// LayerProvider is the Layer that providers list in their MixDescriptors.
+	LayerProvider = 255
+
+	// MaxIssuedAtSkew is the maximum difference between a Document's
+	// IssuedAtNanos and the local clock for it to be considered fresh.
+	MaxIssuedAtSkew = 30 * time.Second
+)
 
 var (
 	// ErrNoDocument is the error returned when there never will be a document
@@ -39,6 +47,10 @@ var (
 	// ErrInvalidPostEpoch is the error returned when the server rejects a
 	// descriptor upload for a given epoch due to time reasons.
 	ErrInvalidPostEpoch = errors.New("pki: post for epoch will never succeeed")
+
+	// ErrDocumentNotFresh is the error returned when a document's
+	// IssuedAtNanos is not within MaxIssuedAtSkew of the local clock.
+	ErrDocumentNotFresh = errors.New("pki: document is not fresh")
 )
 
 // Document is a PKI document.
@@ -49,6 +61,10 @@ type Document struct {
 	// GenesisEpoch is the epoch on which authorities started consensus
 	GenesisEpoch uint64
 
+	// IssuedAtNanos is the time at which the document was signed, in
+	// nanoseconds since the Unix epoch.
+	IssuedAtNanos int64
+
 	// SendRatePerMinute is the number of packets per minute a client can send.
 	SendRatePerMinute uint64
 
@@ -138,6 +154,17 @@ func (d *Document) String() string {
 	return s
 }
 
+// VerifyFreshness returns ErrDocumentNotFresh iff the document was not
+// issued within MaxIssuedAtSkew of now, so that a document replayed from
+// earlier in the same epoch is rejected.
+func (d *Document) VerifyFreshness(now time.Time) error {
+	skew := now.Sub(time.Unix(0, d.IssuedAtNanos))
+	if skew > MaxIssuedAtSkew || skew < -MaxIssuedAtSkew {
+		return fmt.Errorf("%w: issued %v from local time", ErrDocumentNotFresh, -skew)
+	}
+	return nil
+}
+
 // GetProvider returns the MixDescriptor for the given provider Name.
 func (d *Document) GetProvider(name string) (*MixDescriptor, error) {
 	for _, v := range d.Providers {
@@ -248,6 +275,32 @@ var (
 	ClientTransports = []Transport{TransportTCP, TransportTCPv4, TransportTCPv6}
 )
 
+// ValidateAddresses returns nil iff every address listed under the
+// TransportTCPv4 and TransportTCPv6 transports is a raw IP + Port
+// combination of the matching IP version.  Addresses for other transports
+// are not checked.
+func ValidateAddresses(addrs map[Transport][]string) error {
+	for transport, v := range addrs {
+		if transport != TransportTCPv4 && transport != TransportTCPv6 {
+			continue
+		}
+		for _, a := range v {
+			host, _, err := net.SplitHostPort(a)
+			if err != nil {
+				return fmt.Errorf("pki: invalid %v address '%v': %v", transport, a, err)
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("pki: invalid %v address '%v': not an IP", transport, a)
+			}
+			if isV4 := ip.To4() != nil; isV4 != (transport == TransportTCPv4) {
+				return fmt.Errorf("pki: invalid %v address '%v': wrong IP version", transport, a)
+			}
+		}
+	}
+	return nil
+}
+
 // MixDescriptor is a description of a given Mix or Provider (node).
 type MixDescriptor struct {
 	// Name is the human readable (descriptive) node identifier.
@@ -279,6 +332,10 @@ type MixDescriptor struct {
 
 	// LoadWeight is the node's load balancing weight (unused).
 	LoadWeight uint8
+
+	// BandwidthBytesPerSec is the node's advertised bandwidth, used to
+	// weight path selection.
+	BandwidthBytesPerSec uint64 `json:",omitempty"`
 }
 
 // Client is the abstract interface used for PKI interaction.
@@ -0,0 +1,81 @@
+// pki_test.go - Mixnet PKI tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescriptorAddresses(t *testing.T) {
+	require := require.New(t)
+
+	desc := newTestDescriptor("mix1", 0)
+	desc.Addresses = map[pki.Transport][]string{
+		pki.TransportTCPv4: {"192.0.2.1:29483", "192.0.2.2:29483"},
+		pki.TransportTCPv6: {"[2001:db8::1]:29483"},
+	}
+	require.NoError(pki.ValidateAddresses(desc.Addresses), "ValidateAddresses()")
+
+	b, err := json.Marshal(desc)
+	require.NoError(err, "json.Marshal()")
+	var desc2 pki.MixDescriptor
+	err = json.Unmarshal(b, &desc2)
+	require.NoError(err, "json.Unmarshal()")
+	require.Equal(desc.Addresses, desc2.Addresses)
+	require.NoError(pki.ValidateAddresses(desc2.Addresses), "ValidateAddresses(round trip)")
+
+	for _, addrs := range []map[pki.Transport][]string{
+		{pki.TransportTCPv6: {"192.0.2.1:29483"}},
+		{pki.TransportTCPv6: {"[::ffff:192.0.2.1]:29483"}},
+		{pki.TransportTCPv4: {"[2001:db8::1]:29483"}},
+		{pki.TransportTCPv6: {"2001:db8::1"}},
+		{pki.TransportTCPv4: {"example.com:29483"}},
+	} {
+		require.Error(pki.ValidateAddresses(addrs), "ValidateAddresses(%v)", addrs)
+	}
+	require.NoError(pki.ValidateAddresses(map[pki.Transport][]string{pki.TransportTCP: {"example.com:29483"}}))
+}
+
+func TestDocumentFreshness(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	doc := &pki.Document{IssuedAtNanos: now.UnixNano()}
+	require.NoError(doc.VerifyFreshness(now), "VerifyFreshness(now)")
+	doc.IssuedAtNanos = now.Add(-pki.MaxIssuedAtSkew).UnixNano()
+	require.NoError(doc.VerifyFreshness(now), "VerifyFreshness(-MaxIssuedAtSkew)")
+
+	// Documents issued too far in the future or the past are rejected.
+	for _, skew := range []time.Duration{60 * time.Second, -60 * time.Second} {
+		doc.IssuedAtNanos = now.Add(skew).UnixNano()
+		b, err := json.Marshal(doc)
+		require.NoError(err, "json.Marshal()")
+		var doc2 pki.Document
+		require.NoError(json.Unmarshal(b, &doc2), "json.Unmarshal()")
+		err = doc2.VerifyFreshness(now)
+		require.True(errors.Is(err, pki.ErrDocumentNotFresh), "VerifyFreshness(%v): %v", skew, err)
+	}
+
+	// As are documents without a timestamp.
+	require.Error((&pki.Document{}).VerifyFreshness(now))
+}
@@ -0,0 +1,72 @@
+// pkiutil.go - PKI document utilities.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package pkiutil provides utilities for working with PKI documents.
+package pkiutil
+
+import (
+	"encoding/hex"
+
+	"github.com/katzenpost/core/pki"
+)
+
+// IdentityIndex is an index of the descriptors in a PKI document by
+// identity key.  It is immutable, and thus safe for concurrent use.
+type IdentityIndex struct {
+	byIdentity  map[string]*pki.MixDescriptor
+	descriptors []*pki.MixDescriptor
+}
+
+// BuildIdentityIndex indexes the mixes and providers of doc by identity key.
+// Descriptors without an identity key are omitted, and if several
+// descriptors share an identity key, only the first is indexed.
+func BuildIdentityIndex(doc *pki.Document) *IdentityIndex {
+	idx := &IdentityIndex{
+		byIdentity: make(map[string]*pki.MixDescriptor),
+	}
+	add := func(desc *pki.MixDescriptor) {
+		if desc == nil || desc.IdentityKey == nil {
+			return
+		}
+		k := hex.EncodeToString(desc.IdentityKey.Bytes())
+		if _, ok := idx.byIdentity[k]; ok {
+			return
+		}
+		idx.byIdentity[k] = desc
+		idx.descriptors = append(idx.descriptors, desc)
+	}
+	for _, l := range doc.Topology {
+		for _, desc := range l {
+			add(desc)
+		}
+	}
+	for _, desc := range doc.Providers {
+		add(desc)
+	}
+	return idx
+}
+
+// Lookup returns the descriptor with the identity key identity, if any.
+func (idx *IdentityIndex) Lookup(identity []byte) (*pki.MixDescriptor, bool) {
+	desc, ok := idx.byIdentity[hex.EncodeToString(identity)]
+	return desc, ok
+}
+
+// LookupAll returns every indexed descriptor, mixes in layer order followed
+// by providers.
+func (idx *IdentityIndex) LookupAll() []*pki.MixDescriptor {
+	return append([]*pki.MixDescriptor{}, idx.descriptors...)
+}
@@ -0,0 +1,59 @@
+// pkiutil_test.go - PKI document utility tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pkiutil
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityIndex(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		numMixes     = 90
+		numProviders = 10
+	)
+	doc := testpki.NewTestDocument(1, numMixes, numProviders)
+	idx := BuildIdentityIndex(doc)
+
+	var nodes []*pki.MixDescriptor
+	for _, l := range doc.Topology {
+		nodes = append(nodes, l...)
+	}
+	nodes = append(nodes, doc.Providers...)
+	require.Len(nodes, numMixes+numProviders)
+	for _, desc := range nodes {
+		found, ok := idx.Lookup(desc.IdentityKey.Bytes())
+		require.True(ok, "Lookup(%v)", desc.Name)
+		require.Same(desc, found, "Lookup(%v)", desc.Name)
+	}
+	require.Equal(nodes, idx.LookupAll())
+
+	_, ok := idx.Lookup(testpki.DeriveKeypair("absent").PublicKey().Bytes())
+	require.False(ok, "Lookup(absent)")
+	_, ok = idx.Lookup(nil)
+	require.False(ok, "Lookup(nil)")
+
+	// The index is not affected by modifying the returned slice.
+	all := idx.LookupAll()
+	all[0] = nil
+	require.Equal(nodes, idx.LookupAll())
+}
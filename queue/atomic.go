@@ -0,0 +1,77 @@
+// atomic.go - Concurrency safe swappable priority queue.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// AtomicPriorityQueue is a PriorityQueue that is safe for concurrent use,
+// and whose underlying PriorityQueue may be atomically replaced.
+type AtomicPriorityQueue struct {
+	sync.Mutex
+
+	q *PriorityQueue
+}
+
+// Enqueue inserts the provided value, into the queue with the specified
+// priority.
+func (a *AtomicPriorityQueue) Enqueue(priority uint64, value interface{}) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.q.Enqueue(priority, value)
+}
+
+// Pop removes and returns the entry with the lowest priority if any, or nil.
+func (a *AtomicPriorityQueue) Pop() *Entry {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.q.Len() <= 0 {
+		return nil
+	}
+	return heap.Pop(a.q).(*Entry)
+}
+
+// Len returns the current length of the priority queue.
+func (a *AtomicPriorityQueue) Len() int {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.q.Len()
+}
+
+// Swap replaces the underlying queue with newQueue, and returns the old
+// queue.  Every Enqueue that returned before Swap was called is in the old
+// queue, and every Enqueue that is called after Swap returns is in the new
+// queue, so no entries are lost if the caller drains the old queue.  The
+// caller MUST NOT use newQueue directly after the call.
+func (a *AtomicPriorityQueue) Swap(newQueue *PriorityQueue) *PriorityQueue {
+	a.Lock()
+	defer a.Unlock()
+
+	old := a.q
+	a.q = newQueue
+	return old
+}
+
+// NewAtomic creates a new, empty AtomicPriorityQueue.
+func NewAtomic() *AtomicPriorityQueue {
+	return &AtomicPriorityQueue{q: New()}
+}
@@ -0,0 +1,77 @@
+// json.go - PriorityQueue JSON serialization.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+type jsonEntry struct {
+	Priority uint64          `json:"priority"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// MarshalJSON serializes the entries of the PriorityQueue q to a JSON array
+// of `{"priority": N, "value": ...}` objects, sorted by ascending priority.
+// The PriorityQueue is left unaltered.
+func MarshalJSON(q *PriorityQueue) ([]byte, error) {
+	entries := make([]*Entry, len(q.heap))
+	copy(entries, q.heap)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Priority < entries[j].Priority
+	})
+
+	out := make([]jsonEntry, 0, len(entries))
+	for _, e := range entries {
+		v, err := json.Marshal(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, jsonEntry{
+			Priority: e.Priority,
+			Value:    v,
+		})
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON de-serializes a JSON array produced by MarshalJSON into a new
+// PriorityQueue.  As Entry values are untyped, valueFactory is called once per
+// entry and MUST return a pointer to a new value of the concrete type to
+// decode into, which is then enqueued as is.
+func UnmarshalJSON(data []byte, valueFactory func() interface{}) (*PriorityQueue, error) {
+	if valueFactory == nil {
+		return nil, errors.New("queue: missing valueFactory")
+	}
+
+	var entries []jsonEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	q := New()
+	for _, e := range entries {
+		v := valueFactory()
+		if err := json.Unmarshal(e.Value, v); err != nil {
+			return nil, err
+		}
+		q.Enqueue(e.Priority, v)
+	}
+	return q, nil
+}
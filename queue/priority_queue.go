@@ -23,6 +23,9 @@ package queue
 import (
 	"container/heap"
 	"math/rand"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
 )
 
 // Entry is a PriorityQueue entry.
@@ -117,6 +120,18 @@ func (q *PriorityQueue) Enqueue(priority uint64, value interface{}) {
 	heap.Push(q, ent)
 }
 
+// EnqueueOrDrop inserts the provided value into the queue with the specified
+// priority iff the current time according to clock is before deadline, and
+// returns true iff the value was inserted.  A deadline of exactly the current
+// time has passed, and the value is dropped.
+func (q *PriorityQueue) EnqueueOrDrop(clock epochtime.EpochClock, priority uint64, value interface{}, deadline time.Time) bool {
+	if !epochtime.WallTime(clock).Before(deadline) {
+		return false
+	}
+	q.Enqueue(priority, value)
+	return true
+}
+
 // DequeueRandom removes a random entry from the queue.
 func (q *PriorityQueue) DequeueRandom(r *rand.Rand) *Entry {
 	if q.Len() <= 0 {
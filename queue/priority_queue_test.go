@@ -18,10 +18,16 @@ package queue
 
 import (
 	"container/heap"
+	"encoding/json"
+	"fmt"
 	"math/rand"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/katzenpost/core/epochtime"
 	"github.com/stretchr/testify/require"
 )
 
@@ -174,3 +180,103 @@ func TestPriorityQueueRemove(t *testing.T) {
 	require.Nil(e)
 
 }
+
+func TestPriorityQueueJSON(t *testing.T) {
+	require := require.New(t)
+
+	const nrEntries = 100
+
+	q := New()
+	r := rand.New(rand.NewSource(23)) // Don't do this in production.
+	for _, i := range r.Perm(nrEntries) {
+		q.Enqueue(uint64(i)*10, fmt.Sprintf("entry %d", i))
+	}
+
+	b, err := MarshalJSON(q)
+	require.NoError(err, "MarshalJSON()")
+	require.Equal(nrEntries, q.Len(), "MarshalJSON() altered the queue")
+
+	var raw []map[string]interface{}
+	require.NoError(json.Unmarshal(b, &raw), "MarshalJSON() produced invalid JSON")
+	require.Len(raw, nrEntries)
+	require.Equal(map[string]interface{}{"priority": float64(0), "value": "entry 0"}, raw[0])
+
+	q2, err := UnmarshalJSON(b, func() interface{} { return new(string) })
+	require.NoError(err, "UnmarshalJSON()")
+	require.Equal(nrEntries, q2.Len(), "UnmarshalJSON() length")
+
+	for i := 0; i < nrEntries; i++ {
+		ent := heap.Pop(q2).(*Entry)
+		require.Equal(uint64(i)*10, ent.Priority, "UnmarshalJSON(): Priority")
+		require.Equal(fmt.Sprintf("entry %d", i), *ent.Value.(*string), "UnmarshalJSON(): Value")
+	}
+
+	_, err = UnmarshalJSON([]byte(`[{"priority": 1, "value": 2}]`), func() interface{} { return new(string) })
+	require.Error(err, "UnmarshalJSON(): mismatched value type")
+}
+
+func TestAtomicPriorityQueue(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		nrWriters   = 8
+		nrPerWriter = 1000
+	)
+
+	q := NewAtomic()
+	var wg sync.WaitGroup
+	for w := 0; w < nrWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < nrPerWriter; i++ {
+				q.Enqueue(uint64(w*nrPerWriter+i), w)
+			}
+		}(w)
+	}
+
+	// Swap the queue while the writers are running.
+	oldCh := make(chan *PriorityQueue, 1)
+	go func() {
+		for q.Len() < nrWriters*nrPerWriter/2 {
+			runtime.Gosched()
+		}
+		oldCh <- q.Swap(New())
+	}()
+	wg.Wait()
+	old := <-oldCh
+
+	// Every entry is in exactly one of the queues, and the new queue pops
+	// in priority order.
+	seen := make(map[uint64]bool)
+	for old.Len() > 0 {
+		e := heap.Pop(old).(*Entry)
+		require.False(seen[e.Priority], "duplicate entry")
+		seen[e.Priority] = true
+	}
+	require.True(len(seen) >= nrWriters*nrPerWriter/2)
+	prev := -1
+	for q.Len() > 0 {
+		e := q.Pop()
+		require.True(int(e.Priority) > prev, "out of order")
+		prev = int(e.Priority)
+		require.False(seen[e.Priority], "duplicate entry")
+		seen[e.Priority] = true
+	}
+	require.Len(seen, nrWriters*nrPerWriter)
+	require.Nil(q.Pop())
+}
+
+func TestEnqueueOrDrop(t *testing.T) {
+	require := require.New(t)
+
+	clock := epochtime.NewFakeEpochClock(1)
+	now := epochtime.WallTime(clock)
+
+	q := New()
+	require.True(q.EnqueueOrDrop(clock, 1, "future", now.Add(time.Second)), "future deadline")
+	require.False(q.EnqueueOrDrop(clock, 2, "past", now.Add(-time.Second)), "past deadline")
+	require.False(q.EnqueueOrDrop(clock, 3, "now", now), "deadline of now")
+	require.Equal(1, q.Len())
+	require.Equal("future", q.Peek().Value)
+}
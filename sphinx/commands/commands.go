@@ -40,6 +40,18 @@ const (
 	// NodeDelayLength is the length of a NodeDelay command in bytes.
 	NodeDelayLength = 1 + 4
 
+	// SURBEpochLength is the length of a SURBEpoch command in bytes.
+	SURBEpochLength = 1 + 8
+
+	// PerHopPayloadOverhead is the length of a PerHopPayload command in
+	// bytes, excluding the payload itself.
+	PerHopPayloadOverhead = 1 + 1
+
+	// MaxPerHopPayloadLength is the maximum length of the payload that can
+	// be carried by a PerHopPayload command, ignoring the space available
+	// in the routing information.
+	MaxPerHopPayloadLength = 255
+
 	// Generic commands.
 	null        commandID = 0x00
 	nextNodeHop commandID = 0x01
@@ -47,7 +59,9 @@ const (
 	surbReply   commandID = 0x03
 
 	// Implementation defined commands.
-	nodeDelay commandID = 0x80
+	nodeDelay     commandID = 0x80
+	perHopPayload commandID = 0x81
+	surbEpoch     commandID = 0x82
 )
 
 var errInvalidCommand = errors.New("sphinx: invalid per-hop command")
@@ -97,6 +111,10 @@ func FromBytes(b []byte) (cmd RoutingCommand, rest []byte, err error) {
 		cmd, rest, err = surbReplyFromBytes(b)
 	case nodeDelay:
 		cmd, rest, err = nodeDelayFromBytes(b)
+	case perHopPayload:
+		cmd, rest, err = perHopPayloadFromBytes(b)
+	case surbEpoch:
+		cmd, rest, err = surbEpochFromBytes(b)
 	default:
 		err = errInvalidCommand
 	}
@@ -211,3 +229,71 @@ func nodeDelayFromBytes(b []byte) (cmd RoutingCommand, rest []byte, err error) {
 	cmd = r
 	return
 }
+
+// PerHopPayload is a de-serialized Sphinx per_hop_payload command, carrying
+// opaque data destined for the application layer of a single hop.
+type PerHopPayload struct {
+	Payload []byte
+}
+
+// ToBytes appends the serialized PerHopPayload to slice b, and returns the
+// resulting slice.
+func (cmd *PerHopPayload) ToBytes(b []byte) []byte {
+	if len(cmd.Payload) > MaxPerHopPayloadLength {
+		panic("sphinx: invalid PerHopPayload payload when serializing")
+	}
+
+	b = append(b, byte(perHopPayload))
+	b = append(b, uint8(len(cmd.Payload)))
+	b = append(b, cmd.Payload...)
+	return b
+}
+
+func perHopPayloadFromBytes(b []byte) (cmd RoutingCommand, rest []byte, err error) {
+	if len(b) < PerHopPayloadOverhead-1 {
+		err = errInvalidCommand
+		return
+	}
+	payloadLen := int(b[0])
+	b = b[PerHopPayloadOverhead-1:]
+	if len(b) < payloadLen {
+		err = errInvalidCommand
+		return
+	}
+	rest = b[payloadLen:]
+
+	r := new(PerHopPayload)
+	r.Payload = make([]byte, 0, payloadLen)
+	r.Payload = append(r.Payload, b[:payloadLen]...)
+	cmd = r
+	return
+}
+
+// SURBEpoch is a de-serialized Sphinx surb_epoch command, carrying the epoch
+// a SURB was created in to the first hop of the SURB.
+type SURBEpoch struct {
+	Epoch uint64
+}
+
+// ToBytes appends the serialized SURBEpoch to slice b, and returns the
+// resulting slice.
+func (cmd *SURBEpoch) ToBytes(b []byte) []byte {
+	var tmp [8]byte
+	b = append(b, byte(surbEpoch))
+	binary.BigEndian.PutUint64(tmp[:], cmd.Epoch)
+	b = append(b, tmp[:]...)
+	return b
+}
+
+func surbEpochFromBytes(b []byte) (cmd RoutingCommand, rest []byte, err error) {
+	if len(b) < SURBEpochLength-1 {
+		err = errInvalidCommand
+		return
+	}
+	rest = b[SURBEpochLength-1:]
+
+	r := new(SURBEpoch)
+	r.Epoch = binary.BigEndian.Uint64(b[:8])
+	cmd = r
+	return
+}
@@ -107,8 +107,28 @@ func TestCommands(t *testing.T) {
 	nodeDelayValues := [][]byte{tmp[:]}
 	b = nodeDelayCmd.ToBytes(b)
 	ser = b[off:]
+	off = len(b)
 	toBytesTest(assert, ser, NodeDelayLength, nodeDelay, nodeDelayValues)
 
+	// SURBEpoch
+	const testEpoch = 0x0123456789abcdef
+	surbEpochCmd := &SURBEpoch{Epoch: testEpoch}
+	var tmp8 [8]byte
+	binary.BigEndian.PutUint64(tmp8[:], surbEpochCmd.Epoch)
+	surbEpochValues := [][]byte{tmp8[:]}
+	b = surbEpochCmd.ToBytes(b)
+	ser = b[off:]
+	off = len(b)
+	toBytesTest(assert, ser, SURBEpochLength, surbEpoch, surbEpochValues)
+
+	// PerHopPayload
+	perHopPayloadCmd := &PerHopPayload{Payload: []byte("per-hop payload")}
+	perHopPayloadValues := [][]byte{[]byte{byte(len(perHopPayloadCmd.Payload))}, perHopPayloadCmd.Payload}
+	b = perHopPayloadCmd.ToBytes(b)
+	ser = b[off:]
+	perHopPayloadLength := PerHopPayloadOverhead + len(perHopPayloadCmd.Payload)
+	toBytesTest(assert, ser, perHopPayloadLength, perHopPayload, perHopPayloadValues)
+
 	// Null (No command or serialization because it is just 0x00s).
 	b = append(b, []byte{0x00, 0x00, 0x00}...) // Append a null command.
 
@@ -117,6 +137,8 @@ func TestCommands(t *testing.T) {
 	b = fromBytesTest(assert, b, RecipientLength, recipientCmd)
 	b = fromBytesTest(assert, b, SURBReplyLength, surbReplyCmd)
 	b = fromBytesTest(assert, b, NodeDelayLength, nodeDelayCmd)
+	b = fromBytesTest(assert, b, SURBEpochLength, surbEpochCmd)
+	b = fromBytesTest(assert, b, perHopPayloadLength, perHopPayloadCmd)
 
 	// Ensure that Null commands as a terminal works as intended.
 	iCmd, rest, err = FromBytes(b)
@@ -128,6 +150,10 @@ func TestCommands(t *testing.T) {
 	b = []byte{0x00, 0x00, 0x01}
 	fromBytesErrorTest(assert, b, "a invalid null command")
 
+	// Ensure that truncated per-hop payloads are rejected.
+	b = []byte{byte(perHopPayload), 0x04, 0x00, 0x00}
+	fromBytesErrorTest(assert, b, "a truncated per_hop_payload command")
+
 	// Ensure that unknown commands are rejected.
 	b = []byte{0xff, 0x00, 0x00}
 	fromBytesErrorTest(assert, b, "a unknown command")
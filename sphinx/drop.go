@@ -0,0 +1,199 @@
+// drop.go - Sphinx packet drop accounting.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sphinx
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/sphinx/commands"
+)
+
+// ErrStaleEpoch is the error returned by Processor.UnwrapEpoch when a reply
+// packet's SURB was created more than one epoch before the epoch of the key
+// the packet is for.
+var ErrStaleEpoch = errors.New("sphinx: SURB epoch is stale")
+
+// ErrPacketExpired is the error returned by Processor.UnwrapEpoch when the
+// epoch of the key a packet is for ended longer ago than the Processor's
+// packet expiry.
+var ErrPacketExpired = errors.New("sphinx: packet expired")
+
+// DropReason is the reason a Sphinx packet was dropped.
+type DropReason int
+
+const (
+	// BadMAC is a packet with an invalid header MAC or payload tag.
+	BadMAC DropReason = iota
+
+	// Replay is a packet with a previously seen replay tag.
+	Replay
+
+	// Expired is a packet that arrived outside of its validity period.
+	Expired
+
+	// Truncated is a packet with a truncated header or payload.
+	Truncated
+
+	// BadRoutingCommand is a packet with malformed routing commands.
+	BadRoutingCommand
+
+	numDropReasons
+)
+
+var dropReasonStrings = [numDropReasons]string{
+	BadMAC:            "bad MAC",
+	Replay:            "replay",
+	Expired:           "expired",
+	Truncated:         "truncated",
+	BadRoutingCommand: "bad routing command",
+}
+
+// String returns the string representation of a DropReason.
+func (r DropReason) String() string {
+	if r < 0 || r >= numDropReasons {
+		return "unknown"
+	}
+	return dropReasonStrings[r]
+}
+
+// DropCounter counts dropped packets by reason.  It is safe for concurrent
+// use.
+type DropCounter struct {
+	counts [numDropReasons]uint64
+}
+
+// Increment increments the counter for reason.
+func (dc *DropCounter) Increment(reason DropReason) {
+	if reason < 0 || reason >= numDropReasons {
+		panic("sphinx: BUG: invalid drop reason")
+	}
+	atomic.AddUint64(&dc.counts[reason], 1)
+}
+
+// Snapshot returns the current value of every counter.
+func (dc *DropCounter) Snapshot() map[DropReason]uint64 {
+	m := make(map[DropReason]uint64, numDropReasons)
+	for r := range dc.counts {
+		m[DropReason(r)] = atomic.LoadUint64(&dc.counts[r])
+	}
+	return m
+}
+
+// Processor unwraps Sphinx packets, optionally accounting for the packets
+// that are dropped.
+//
+// Replay checks are done by the caller after unwrapping, and should be
+// accounted for by incrementing the Replay counter directly.
+type Processor struct {
+	dropCounter  *DropCounter
+	clock        epochtime.EpochClock
+	packetExpiry time.Duration
+	trace        processorTrace
+}
+
+// NewProcessor creates a new Processor.
+func NewProcessor() *Processor {
+	return new(Processor)
+}
+
+// WithDropCounter attaches dc to the Processor, and returns the Processor.
+func (p *Processor) WithDropCounter(dc *DropCounter) *Processor {
+	p.dropCounter = dc
+	return p
+}
+
+// WithPacketExpiry sets how long after the end of an epoch UnwrapEpoch
+// accepts packets for that epoch's mix key, according to clock, and returns
+// the Processor.  An expiry of 0 (the default) disables the check.
+//
+// Replay caches may evict old replay tags, so expiring packets bounds how
+// long a captured packet may be replayed for.  The expiry is enforced by
+// every mix based on its own clock and key schedule, so it neither depends
+// on, nor requires, anything from the sender.
+func (p *Processor) WithPacketExpiry(clock epochtime.EpochClock, d time.Duration) *Processor {
+	p.clock = clock
+	p.packetExpiry = d
+	return p
+}
+
+// Unwrap is Unwrap, incrementing the drop counter (if any) on failure.
+func (p *Processor) Unwrap(privKey *ecdh.PrivateKey, pkt []byte) ([]byte, []byte, []commands.RoutingCommand, error) {
+	payload, replayTag, cmds, err := Unwrap(privKey, pkt)
+	if err != nil {
+		if p.dropCounter != nil {
+			p.dropCounter.Increment(dropReasonFor(err))
+		}
+		return payload, replayTag, cmds, err
+	}
+	p.traceUnwrap(cmds)
+	return payload, replayTag, cmds, nil
+}
+
+// UnwrapEpoch is Unwrap for a packet destined to privKey, the mix key for
+// epoch, additionally rejecting the packet with ErrPacketExpired if epoch
+// ended longer ago than the Processor's packet expiry, and with
+// ErrStaleEpoch if the packet was made from a SURB created more than one
+// epoch before epoch.
+func (p *Processor) UnwrapEpoch(privKey *ecdh.PrivateKey, epoch uint64, pkt []byte) ([]byte, []byte, []commands.RoutingCommand, error) {
+	if p.isExpired(epoch) {
+		if p.dropCounter != nil {
+			p.dropCounter.Increment(Expired)
+		}
+		return nil, nil, nil, ErrPacketExpired
+	}
+	payload, replayTag, cmds, err := p.Unwrap(privKey, pkt)
+	if err != nil {
+		return payload, replayTag, cmds, err
+	}
+	for _, v := range cmds {
+		if cmd, ok := v.(*commands.SURBEpoch); ok && epoch > cmd.Epoch+1 {
+			if p.dropCounter != nil {
+				p.dropCounter.Increment(Expired)
+			}
+			return nil, nil, nil, ErrStaleEpoch
+		}
+	}
+	return payload, replayTag, cmds, nil
+}
+
+func (p *Processor) isExpired(epoch uint64) bool {
+	if p.packetExpiry <= 0 {
+		return false
+	}
+	current, elapsed, _ := p.clock.Now()
+	if epoch >= current {
+		return false
+	}
+	sinceEnd := time.Duration(current-epoch-1)*epochtime.Period + elapsed
+	return sinceEnd > p.packetExpiry
+}
+
+func dropReasonFor(err error) DropReason {
+	switch err {
+	case errTruncatedPacket, errTruncatedPayload:
+		return Truncated
+	case errUnknownVersion, errMACMismatch, errInvalidTag:
+		// The version is part of the AD covered by the header MAC.
+		return BadMAC
+	default:
+		return BadRoutingCommand
+	}
+}
@@ -0,0 +1,131 @@
+// drop_test.go - Sphinx packet drop accounting tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sphinx
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/sphinx/commands"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropCounter(t *testing.T) {
+	require := require.New(t)
+
+	dc := new(DropCounter)
+	p := NewProcessor().WithDropCounter(dc)
+	payload := []byte("The pipe cleaner is bent at an odd angle.")
+
+	newPacket := func() ([]*nodeParams, []byte) {
+		nodes, path := newPathVector(require, 1, false)
+		pkt, err := NewPacket(rand.Reader, path, payload)
+		require.NoError(err, "NewPacket()")
+		return nodes, pkt
+	}
+
+	// Truncated header.
+	nodes, pkt := newPacket()
+	_, _, _, err := p.Unwrap(nodes[0].privateKey, pkt[:HeaderLength-1])
+	require.Error(err, "Unwrap(truncated)")
+
+	// Corrupted header MAC.
+	nodes, pkt = newPacket()
+	pkt[HeaderLength-1] ^= 0xa5
+	_, _, _, err = p.Unwrap(nodes[0].privateKey, pkt)
+	require.Error(err, "Unwrap(bad MAC)")
+
+	// Corrupted payload, failing the tag check at the terminal hop.
+	nodes, pkt = newPacket()
+	pkt[HeaderLength] ^= 0xa5
+	_, _, _, err = p.Unwrap(nodes[0].privateKey, pkt)
+	require.Error(err, "Unwrap(bad payload tag)")
+
+	// Duplicate SURB reply commands.
+	nodes, path := newPathVector(require, 1, false)
+	path[0].Commands = []commands.RoutingCommand{new(commands.SURBReply), new(commands.SURBReply)}
+	pkt, err = NewPacket(rand.Reader, path, payload)
+	require.NoError(err, "NewPacket(duplicate SURBReply)")
+	_, _, _, err = p.Unwrap(nodes[0].privateKey, pkt)
+	require.Error(err, "Unwrap(duplicate SURBReply)")
+
+	// A valid packet is not counted.
+	nodes, pkt = newPacket()
+	_, _, _, err = p.Unwrap(nodes[0].privateKey, pkt)
+	require.NoError(err, "Unwrap(valid)")
+
+	dc.Increment(Replay)
+	require.Equal(map[DropReason]uint64{
+		BadMAC:            2,
+		Replay:            1,
+		Expired:           0,
+		Truncated:         1,
+		BadRoutingCommand: 1,
+	}, dc.Snapshot())
+}
+
+func TestProcessorPacketExpiry(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		epoch  = 10
+		expiry = 10 * time.Minute
+	)
+	clock := epochtime.NewFakeEpochClock(epoch)
+	dc := new(DropCounter)
+	p := NewProcessor().WithDropCounter(dc).WithPacketExpiry(clock, expiry)
+	payload := []byte("The pipe cleaner is bent at an odd angle.")
+
+	newPacket := func() ([]*nodeParams, []byte) {
+		nodes, path := newPathVector(require, 2, false)
+		pkt, err := NewPacket(rand.Reader, path, payload)
+		require.NoError(err, "NewPacket()")
+		return nodes, pkt
+	}
+
+	// A packet for the current epoch's key is accepted.
+	nodes, pkt := newPacket()
+	_, _, _, err := p.UnwrapEpoch(nodes[0].privateKey, epoch, pkt)
+	require.NoError(err, "UnwrapEpoch(current epoch)")
+
+	// As is one for the previous epoch's key, up to the expiry after the
+	// end of the epoch.  Unwrap decrypts in place, so keep a copy to
+	// replay.
+	nodes, pkt = newPacket()
+	replay := append([]byte{}, pkt...)
+	clock.Advance(epochtime.Period + expiry)
+	_, _, _, err = p.UnwrapEpoch(nodes[0].privateKey, epoch, pkt)
+	require.NoError(err, "UnwrapEpoch(at expiry)")
+
+	// Once the clock advances beyond the expiry, it is rejected.
+	clock.Advance(time.Second)
+	_, _, _, err = p.UnwrapEpoch(nodes[0].privateKey, epoch, replay)
+	require.Equal(ErrPacketExpired, err, "UnwrapEpoch(expired)")
+	require.EqualValues(1, dc.Snapshot()[Expired])
+
+	// Packets unwrapped without an epoch, and processors without an
+	// expiry, are not checked.
+	nodes, pkt = newPacket()
+	replay = append([]byte{}, pkt...)
+	clock.Advance(24 * time.Hour)
+	_, _, _, err = p.Unwrap(nodes[0].privateKey, pkt)
+	require.NoError(err, "Unwrap(no epoch)")
+	_, _, _, err = NewProcessor().UnwrapEpoch(nodes[0].privateKey, epoch, replay)
+	require.NoError(err, "UnwrapEpoch(no expiry)")
+}
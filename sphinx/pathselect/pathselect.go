@@ -0,0 +1,175 @@
+// pathselect.go - Bandwidth weighted path selection.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package pathselect provides Sphinx path selection weighted by the
+// advertised bandwidth of each mix.
+package pathselect
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx"
+	"github.com/katzenpost/core/sphinx/constants"
+)
+
+// NodeID is a node identifier, the node's IdentityKey.
+type NodeID [constants.NodeIDLength]byte
+
+var errNoTopology = errors.New("pathselect: document has no topology")
+
+// SelectPath selects a path from the provider src to the provider dst,
+// through one mix in each layer of the topology in doc, sampled from rng
+// proportionally to the mixes' BandwidthBytesPerSec.  Mixes with no
+// advertised bandwidth are never selected.
+//
+// The returned hops have their ID and the PublicKey for the document's
+// epoch set, it is up to the caller to add the routing commands.
+func SelectPath(doc *pki.Document, src, dst NodeID, rng io.Reader) ([]*sphinx.PathHop, error) {
+	if len(doc.Topology) == 0 {
+		return nil, errNoTopology
+	}
+	srcDesc, err := doc.GetProviderByKey(src[:])
+	if err != nil {
+		return nil, fmt.Errorf("pathselect: invalid source: %v", err)
+	}
+	dstDesc, err := doc.GetProviderByKey(dst[:])
+	if err != nil {
+		return nil, fmt.Errorf("pathselect: invalid destination: %v", err)
+	}
+
+	descs := make([]*pki.MixDescriptor, 0, len(doc.Topology)+2)
+	descs = append(descs, srcDesc)
+	for layer, nodes := range doc.Topology {
+		candidates := make([]*pki.MixDescriptor, 0, len(nodes))
+		weights := make([]float64, 0, len(nodes))
+		for _, v := range nodes {
+			if v.BandwidthBytesPerSec == 0 {
+				continue
+			}
+			candidates = append(candidates, v)
+			weights = append(weights, float64(v.BandwidthBytesPerSec))
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("pathselect: layer %v has no nodes with bandwidth", layer)
+		}
+		idx, err := newAliasTable(weights).sample(rng)
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, candidates[idx])
+	}
+	descs = append(descs, dstDesc)
+
+	path := make([]*sphinx.PathHop, 0, len(descs))
+	for _, desc := range descs {
+		k, ok := desc.MixKeys[doc.Epoch]
+		if !ok {
+			return nil, fmt.Errorf("pathselect: node '%v' has no key for epoch %v", desc.Name, doc.Epoch)
+		}
+		h := &sphinx.PathHop{PublicKey: k}
+		copy(h.ID[:], desc.IdentityKey.Bytes())
+		path = append(path, h)
+	}
+	return path, nil
+}
+
+// aliasTable is a Walker/Vose alias table, allowing O(1) sampling from a
+// discrete distribution.
+type aliasTable struct {
+	prob  []float64
+	alias []int
+}
+
+func newAliasTable(weights []float64) *aliasTable {
+	n := len(weights)
+	t := &aliasTable{
+		prob:  make([]float64, n),
+		alias: make([]int, n),
+	}
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	scaled := make([]float64, n)
+	var small, large []int
+	for i, w := range weights {
+		scaled[i] = w * float64(n) / sum
+		if scaled[i] < 1.0 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s, l := small[len(small)-1], large[len(large)-1]
+		small, large = small[:len(small)-1], large[:len(large)-1]
+
+		t.prob[s] = scaled[s]
+		t.alias[s] = l
+		scaled[l] = (scaled[l] + scaled[s]) - 1.0
+		if scaled[l] < 1.0 {
+			small = append(small, l)
+		} else {
+			large = append(large, l)
+		}
+	}
+	// Anything left over is due to floating point imprecision.
+	for _, i := range append(small, large...) {
+		t.prob[i] = 1.0
+	}
+	return t
+}
+
+func (t *aliasTable) sample(rng io.Reader) (int, error) {
+	n := uint64(len(t.prob))
+
+	// Rejection sample the column to avoid modulo bias.
+	limit := ^uint64(0) - (^uint64(0) % n)
+	var col uint64
+	for {
+		v, err := readUint64(rng)
+		if err != nil {
+			return 0, err
+		}
+		if v < limit {
+			col = v % n
+			break
+		}
+	}
+
+	v, err := readUint64(rng)
+	if err != nil {
+		return 0, err
+	}
+	u := float64(v>>11) / (1 << 53)
+	if u < t.prob[col] {
+		return int(col), nil
+	}
+	return t.alias[col], nil
+}
+
+func readUint64(rng io.Reader) (uint64, error) {
+	var tmp [8]byte
+	if _, err := io.ReadFull(rng, tmp[:]); err != nil {
+		return 0, fmt.Errorf("pathselect: failed to read entropy: %v", err)
+	}
+	return binary.LittleEndian.Uint64(tmp[:]), nil
+}
@@ -0,0 +1,81 @@
+// pathselect_test.go - Bandwidth weighted path selection tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pathselect
+
+import (
+	"math/rand"
+	"testing"
+
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+const testEpoch = 1234
+
+func TestSelectPath(t *testing.T) {
+	require := require.New(t)
+
+	// The first layer has nodes with known bandwidths, including one with
+	// none at all.
+	bandwidths := []uint64{0, 100, 200, 300, 400}
+	doc := testpki.NewTestDocument(testEpoch, len(bandwidths)*testpki.NumLayers, 2)
+	layer0 := doc.Topology[0]
+	for i, bw := range bandwidths {
+		layer0[i].BandwidthBytesPerSec = bw
+	}
+	src, dst := doc.Providers[0], doc.Providers[1]
+	srcID, dstID := NodeID(src.IdentityKey.ByteArray()), NodeID(dst.IdentityKey.ByteArray())
+
+	const nrSamples = 20000
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[NodeID]int)
+	for i := 0; i < nrSamples; i++ {
+		path, err := SelectPath(doc, srcID, dstID, rng)
+		require.NoError(err, "SelectPath()")
+		require.Len(path, len(doc.Topology)+2)
+		require.Equal([32]byte(srcID), path[0].ID)
+		require.Equal([32]byte(dstID), path[len(path)-1].ID)
+		require.Equal(src.MixKeys[testEpoch], path[0].PublicKey)
+		counts[NodeID(path[1].ID)]++
+	}
+
+	// Zero bandwidth nodes are never selected.
+	require.Zero(counts[NodeID(layer0[0].IdentityKey.ByteArray())])
+
+	// Pearson's chi-squared test against the expected distribution, with 3
+	// degrees of freedom the critical value for p = 0.01 is 11.345.
+	var totalBandwidth uint64
+	for _, bw := range bandwidths {
+		totalBandwidth += bw
+	}
+	var chiSquared float64
+	for _, desc := range layer0[1:] {
+		expected := float64(nrSamples) * float64(desc.BandwidthBytesPerSec) / float64(totalBandwidth)
+		delta := float64(counts[NodeID(desc.IdentityKey.ByteArray())]) - expected
+		chiSquared += delta * delta / expected
+	}
+	require.True(chiSquared < 11.345, "chi-squared statistic %v exceeds the critical value", chiSquared)
+
+	// Layers must have at least one node with bandwidth.
+	doc.Topology[0] = layer0[:1]
+	_, err := SelectPath(doc, srcID, dstID, rng)
+	require.Error(err, "SelectPath(no bandwidth)")
+
+	// The source and destination must be providers.
+	_, err = SelectPath(doc, NodeID(layer0[1].IdentityKey.ByteArray()), dstID, rng)
+	require.Error(err, "SelectPath(invalid source)")
+}
@@ -44,22 +44,36 @@ const (
 
 	// PayloadTagLength is the length of the Sphinx packet payload SPRP tag.
 	PayloadTagLength = 16
+
+	// MaxPerHopPayloadSize is the maximum length of a PathHop's
+	// PerHopPayload in bytes, for a non-terminal hop that also carries a
+	// NodeDelay.  Terminal hops have less space available, depending on the
+	// commands present.
+	MaxPerHopPayloadSize = perHopRoutingInfoLength - (commands.NodeDelayLength + commands.NextNodeHopLength + commands.PerHopPayloadOverhead) // 26 bytes.
 )
 
 var (
 	v0AD      = [2]byte{0x00, 0x00}
 	zeroBytes = [perHopRoutingInfoLength]byte{}
 
+	errTruncatedPacket  = errors.New("sphinx: invalid packet, truncated")
+	errUnknownVersion   = errors.New("sphinx: invalid packet, unknown version")
+	errMACMismatch      = errors.New("sphinx: invalid packet, MAC mismatch")
 	errTruncatedPayload = errors.New("sphinx: truncated payload")
 	errInvalidTag       = errors.New("sphinx: payload auth failed")
 )
 
 // PathHop describes a hop that a Sphinx Packet will traverse, along with
-// all of the per-hop Commands (excluding NextNodeHop).
+// all of the per-hop Commands (excluding NextNodeHop and PerHopPayload).
+//
+// The optional PerHopPayload is delivered to the hop as a
+// commands.PerHopPayload routing command, and must be at most
+// MaxPerHopPayloadSize bytes.
 type PathHop struct {
-	ID        [constants.NodeIDLength]byte
-	PublicKey *ecdh.PublicKey
-	Commands  []commands.RoutingCommand
+	ID            [constants.NodeIDLength]byte
+	PublicKey     *ecdh.PublicKey
+	Commands      []commands.RoutingCommand
+	PerHopPayload []byte
 }
 
 type sprpKey struct {
@@ -72,15 +86,26 @@ func (k *sprpKey) Reset() {
 	utils.ExplicitBzero(k.iv[:])
 }
 
-func commandsToBytes(cmds []commands.RoutingCommand, isTerminal bool) ([]byte, error) {
+func commandsToBytes(cmds []commands.RoutingCommand, perHopPayload []byte, isTerminal bool) ([]byte, error) {
 	b := make([]byte, 0, perHopRoutingInfoLength)
 	for _, v := range cmds {
-		// NextNodeHop is generated by the header creation process.
-		if _, isNextNodeHop := v.(*commands.NextNodeHop); isNextNodeHop {
+		// NextNodeHop and PerHopPayload are generated by the header
+		// creation process.
+		switch v.(type) {
+		case *commands.NextNodeHop:
 			return nil, errors.New("sphinx: invalid commands, NextNodeHop")
+		case *commands.PerHopPayload:
+			return nil, errors.New("sphinx: invalid commands, PerHopPayload")
 		}
 		b = v.ToBytes(b)
 	}
+	if len(perHopPayload) > 0 {
+		if len(perHopPayload) > MaxPerHopPayloadSize {
+			return nil, errors.New("sphinx: invalid per-hop payload, oversized")
+		}
+		cmd := &commands.PerHopPayload{Payload: perHopPayload}
+		b = cmd.ToBytes(b)
+	}
 	if len(b) > perHopRoutingInfoLength {
 		return nil, errors.New("sphinx: invalid commands, oversized serialized block")
 	}
@@ -158,7 +183,7 @@ func createHeader(r io.Reader, path []*PathHop) ([]byte, []*sprpKey, error) {
 	}
 	for i := nrHops - 1; i >= 0; i-- {
 		isTerminal := i == nrHops-1
-		riFragment, err := commandsToBytes(path[i].Commands, isTerminal)
+		riFragment, err := commandsToBytes(path[i].Commands, path[i].PerHopPayload, isTerminal)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -238,7 +263,8 @@ func NewPacket(r io.Reader, path []*PathHop, payload []byte) ([]byte, error) {
 
 // Unwrap unwraps the provided Sphinx packet pkt in-place, using the provided
 // ECDH private key, and returns the payload (if applicable), replay tag, and
-// routing info command vector.
+// routing info command vector.  The hop's per-hop payload, if any, is
+// returned as a commands.PerHopPayload in the command vector.
 func Unwrap(privKey *ecdh.PrivateKey, pkt []byte) ([]byte, []byte, []commands.RoutingCommand, error) {
 	const (
 		geOff      = 2
@@ -249,10 +275,10 @@ func Unwrap(privKey *ecdh.PrivateKey, pkt []byte) ([]byte, []byte, []commands.Ro
 
 	// Do some basic sanity checking, and validate the AD.
 	if len(pkt) < HeaderLength {
-		return nil, nil, nil, errors.New("sphinx: invalid packet, truncated")
+		return nil, nil, nil, errTruncatedPacket
 	}
 	if subtle.ConstantTimeCompare(v0AD[:], pkt[:2]) != 1 {
-		return nil, nil, nil, errors.New("sphinx: invalid packet, unknown version")
+		return nil, nil, nil, errUnknownVersion
 	}
 
 	// Calculate the hop's shared secret, and replay_tag.
@@ -274,7 +300,7 @@ func Unwrap(privKey *ecdh.PrivateKey, pkt []byte) ([]byte, []byte, []commands.Ro
 	mac := m.Sum(nil)
 
 	if subtle.ConstantTimeCompare(pkt[macOff:macOff+crypto.MACLength], mac) != 1 {
-		return nil, replayTag[:], nil, errors.New("sphinx: invalid packet, MAC mismatch")
+		return nil, replayTag[:], nil, errMACMismatch
 	}
 
 	// Append padding to preserve length invariance, decrypt the (padded)
@@ -291,6 +317,7 @@ func Unwrap(privKey *ecdh.PrivateKey, pkt []byte) ([]byte, []byte, []commands.Ro
 	// Parse the per-hop routing commands.
 	var nextNode *commands.NextNodeHop
 	var surbReply *commands.SURBReply
+	var perHopPayload *commands.PerHopPayload
 	cmds := make([]commands.RoutingCommand, 0, 2) // Usually 2, excluding null.
 	for {
 		cmd, rest, err := commands.FromBytes(cmdBuf)
@@ -315,6 +342,11 @@ func Unwrap(privKey *ecdh.PrivateKey, pkt []byte) ([]byte, []byte, []commands.Ro
 				return nil, replayTag[:], nil, errors.New("sphinx: invalid packet, > 1 surb_reply")
 			}
 			surbReply = c
+		case *commands.PerHopPayload:
+			if perHopPayload != nil {
+				return nil, replayTag[:], nil, errors.New("sphinx: invalid packet, > 1 per_hop_payload")
+			}
+			perHopPayload = c
 		default:
 		}
 
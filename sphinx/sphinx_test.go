@@ -170,11 +170,18 @@ func TestSURB(t *testing.T) {
 			} else {
 				t.Logf("Hop %d: Unwrapped pkt: %s", i, hex.Dump(pkt))
 
-				require.Equalf(2, len(cmds), "SURB Hop %d: Unexpected number of commands", i)
+				nrCmds := 2
+				if i == 0 {
+					// The first hop also gets the SURB's epoch.
+					nrCmds++
+					_, ok := cmds[1].(*commands.SURBEpoch)
+					require.Truef(ok, "SURB Hop %d: cmds[1] is not a SURBEpoch", i)
+				}
+				require.Equalf(nrCmds, len(cmds), "SURB Hop %d: Unexpected number of commands", i)
 				require.EqualValuesf(path[i].Commands[0], cmds[0], "SURB Hop %d: delay mismatch", i)
 
-				nextNode, ok := cmds[1].(*commands.NextNodeHop)
-				require.Truef(ok, "SURB Hop %d: cmds[1] is not a NextNodeHop", i)
+				nextNode, ok := cmds[nrCmds-1].(*commands.NextNodeHop)
+				require.Truef(ok, "SURB Hop %d: cmds[%d] is not a NextNodeHop", i, nrCmds-1)
 				require.Equalf(path[i+1].ID, nextNode.ID, "SURB Hop %d: NextNodeHop.ID mismatch", i)
 
 				require.Nil(b, "SURB Hop %d: returned payload", i)
@@ -182,3 +189,88 @@ func TestSURB(t *testing.T) {
 		}
 	}
 }
+
+func TestSURBStaleEpoch(t *testing.T) {
+	require := require.New(t)
+
+	const surbEpoch = 10
+
+	nodes, path := newPathVector(require, constants.NrHops, true)
+	surb, _, err := newSURB(rand.Reader, surbEpoch, path)
+	require.NoError(err, "newSURB failed")
+	require.Equal(SURBLength, len(surb), "SURB length")
+
+	payload := []byte("The past is never dead. It's not even past.")
+	pkt, _, err := NewPacketFromSURB(surb, payload)
+	require.NoError(err, "NewPacketFromSURB failed")
+
+	// The epoch is only visible to, and checked by, the first hop.
+	_, _, cmds, err := Unwrap(nodes[0].privateKey, append([]byte{}, pkt...))
+	require.NoError(err, "Unwrap failed")
+	require.Len(cmds, 3, "Unexpected number of commands")
+	require.Equal(&commands.SURBEpoch{Epoch: surbEpoch}, cmds[1], "SURBEpoch mismatch")
+
+	// SURBs may be used in the epoch they were created in, and the next.
+	dc := new(DropCounter)
+	p := NewProcessor().WithDropCounter(dc)
+	for _, epoch := range []uint64{surbEpoch, surbEpoch + 1} {
+		_, _, _, err = p.UnwrapEpoch(nodes[0].privateKey, epoch, append([]byte{}, pkt...))
+		require.NoErrorf(err, "UnwrapEpoch(epoch %d)", epoch)
+	}
+	_, _, _, err = p.UnwrapEpoch(nodes[0].privateKey, surbEpoch+2, pkt)
+	require.Equal(ErrStaleEpoch, err, "UnwrapEpoch(epoch 12)")
+	require.Equal(uint64(1), dc.Snapshot()[Expired], "Expired drops")
+}
+
+func TestForwardSphinxPerHopPayload(t *testing.T) {
+	const (
+		testPayload = "Every gun that is made, every warship launched, every rocket fired signifies, in the final sense, a theft from those who hunger and are not fed."
+		nrHops      = 3
+	)
+
+	require := require.New(t)
+
+	nodes, path := newPathVector(require, nrHops, false)
+	perHopPayloads := [][]byte{
+		[]byte("hop zero"),
+		[]byte("the first hop's payload..."),
+		[]byte("terminal hop"),
+	}
+	require.Len(perHopPayloads[1], MaxPerHopPayloadSize, "Non-terminal payload length")
+	for i := range path {
+		path[i].PerHopPayload = perHopPayloads[i]
+	}
+
+	payload := []byte(testPayload)
+	pkt, err := NewPacket(rand.Reader, path, payload)
+	require.NoError(err, "NewPacket failed")
+	require.Len(pkt, HeaderLength+PayloadTagLength+len(payload), "Packet Length")
+
+	for i := range nodes {
+		b, _, cmds, err := Unwrap(nodes[i].privateKey, pkt)
+		require.NoErrorf(err, "Hop %d: Unwrap failed", i)
+
+		var hopPayload *commands.PerHopPayload
+		for _, cmd := range cmds {
+			if c, ok := cmd.(*commands.PerHopPayload); ok {
+				hopPayload = c
+			}
+		}
+		require.NotNilf(hopPayload, "Hop %d: missing per-hop payload", i)
+		require.Equalf(perHopPayloads[i], hopPayload.Payload, "Hop %d: per-hop payload mismatch", i)
+
+		if i == len(path)-1 {
+			require.Equalf(2, len(cmds), "Hop %d: Unexpected number of commands", i)
+			require.Equalf(payload, b, "Hop %d: payload mismatch", i)
+		} else {
+			require.Equalf(3, len(cmds), "Hop %d: Unexpected number of commands", i)
+			require.Nil(b, "Hop %d: returned payload", i)
+		}
+	}
+
+	// Oversized per-hop payloads must be rejected.
+	_, path = newPathVector(require, nrHops, false)
+	path[0].PerHopPayload = make([]byte, MaxPerHopPayloadSize+1)
+	_, err = NewPacket(rand.Reader, path, payload)
+	require.Error(err, "NewPacket with oversized per-hop payload")
+}
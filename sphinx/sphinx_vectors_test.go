@@ -129,13 +129,18 @@ func TestVectorSphinx(t *testing.T) {
 				require.NoError(err)
 				require.Equal(packet, rawPacket)
 
-				require.Equalf(2, len(cmds), "Hop %d: Unexpected number of commands", i)
+				nrCmds := 2
+				if i == 0 && len(test.Surb) > 0 {
+					// The first hop of a SURB also gets the SURB's epoch.
+					nrCmds++
+				}
+				require.Equalf(nrCmds, len(cmds), "Hop %d: Unexpected number of commands", i)
 				cmd, err := hex.DecodeString(test.Path[i].Commands[0])
 				require.NoError(err)
 				require.EqualValuesf(cmd, cmds[0].ToBytes([]byte{}), "Hop %d: delay mismatch", i)
 
-				nextNode, ok := cmds[1].(*commands.NextNodeHop)
-				require.Truef(ok, "Hop %d: cmds[1] is not a NextNodeHop", i)
+				nextNode, ok := cmds[nrCmds-1].(*commands.NextNodeHop)
+				require.Truef(ok, "Hop %d: cmds[%d] is not a NextNodeHop", i, nrCmds-1)
 				require.NotNil(nextNode)
 				id, err := hex.DecodeString(test.Path[i+1].ID)
 				require.NoError(err)
@@ -232,11 +237,16 @@ func buildVectorSphinx(t *testing.T, withSURB bool) []hexSphinxTest {
 				tests[nrHops].Payload = hex.EncodeToString(b)
 			} else {
 				tests[nrHops].Packets[i+1] = hex.EncodeToString(pkt)
-				require.Equalf(2, len(cmds), "Hop %d: Unexpected number of commands", i)
+				nrCmds := 2
+				if i == 0 && withSURB {
+					// The first hop of a SURB also gets the SURB's epoch.
+					nrCmds++
+				}
+				require.Equalf(nrCmds, len(cmds), "Hop %d: Unexpected number of commands", i)
 				require.EqualValuesf(path[i].Commands[0], cmds[0], "Hop %d: delay mismatch", i)
 
-				nextNode, ok := cmds[1].(*commands.NextNodeHop)
-				require.Truef(ok, "Hop %d: cmds[1] is not a NextNodeHop", i)
+				nextNode, ok := cmds[nrCmds-1].(*commands.NextNodeHop)
+				require.Truef(ok, "Hop %d: cmds[%d] is not a NextNodeHop", i, nrCmds-1)
 				require.Equalf(path[i+1].ID, nextNode.ID, "Hop %d: NextNodeHop.ID mismatch", i)
 
 				require.Nil(b, "Hop %d: returned payload", i)
@@ -20,6 +20,8 @@ import (
 	"errors"
 	"io"
 
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/sphinx/commands"
 	"github.com/katzenpost/core/sphinx/constants"
 	"github.com/katzenpost/core/sphinx/internal/crypto"
 	"github.com/katzenpost/core/utils"
@@ -34,7 +36,17 @@ const (
 
 // NewSURB creates a new SURB with the provided path using the provided entropy
 // source, and returns the SURB and decrypion keys.
+//
+// The current epoch is delivered to the first hop as a commands.SURBEpoch
+// routing command, leaving MaxPerHopPayloadSize - commands.SURBEpochLength
+// bytes for the first hop's PerHopPayload.  A single hop SURB has no room
+// for the command, and is not bound to an epoch.
 func NewSURB(r io.Reader, path []*PathHop) ([]byte, []byte, error) {
+	epoch, _, _ := epochtime.Now()
+	return newSURB(r, epoch, path)
+}
+
+func newSURB(r io.Reader, epoch uint64, path []*PathHop) ([]byte, []byte, error) {
 	// Create a random SPRP key + iv for the recipient to use to encrypt
 	// the payload when using the SURB.
 	var keyPayload [sprpKeyMaterialLength]byte
@@ -43,7 +55,19 @@ func NewSURB(r io.Reader, path []*PathHop) ([]byte, []byte, error) {
 	}
 	defer utils.ExplicitBzero(keyPayload[:])
 
-	hdr, sprpKeys, err := createHeader(r, path)
+	// Bind the SURB to the epoch, without altering the caller's path.
+	surbPath := path
+	if len(path) > 1 {
+		firstHop := *path[0]
+		firstHop.Commands = make([]commands.RoutingCommand, 0, len(path[0].Commands)+1)
+		firstHop.Commands = append(firstHop.Commands, path[0].Commands...)
+		firstHop.Commands = append(firstHop.Commands, &commands.SURBEpoch{Epoch: epoch})
+		surbPath = make([]*PathHop, 0, len(path))
+		surbPath = append(surbPath, &firstHop)
+		surbPath = append(surbPath, path[1:]...)
+	}
+
+	hdr, sprpKeys, err := createHeader(r, surbPath)
 	if err != nil {
 		return nil, nil, err
 	}
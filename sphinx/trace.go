@@ -0,0 +1,91 @@
+// trace.go - Sphinx packet tracing for test networks.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build sphinx_trace
+
+package sphinx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/katzenpost/core/sphinx/commands"
+)
+
+// TraceIDLength is the length of a TraceID in bytes.
+const TraceIDLength = 8
+
+var traceMagic = []byte("trace")
+
+// TraceID identifies a packet at every hop of its path.  It is only
+// available in builds with the sphinx_trace tag, and MUST NOT be used in
+// production, as it trivially links a packet across hops.
+type TraceID [TraceIDLength]byte
+
+// TraceLogger is the logger that a Processor logs traced packets to, such
+// as a *logging.Logger.
+type TraceLogger interface {
+	Noticef(format string, args ...interface{})
+}
+
+type processorTrace struct {
+	log TraceLogger
+}
+
+// NewTracedPacket is NewPacket, additionally delivering id and the hop's
+// position in the path to each hop as its per-hop payload.  The hops of the
+// path must not have per-hop payloads of their own.
+func NewTracedPacket(r io.Reader, path []*PathHop, payload []byte, id TraceID) ([]byte, error) {
+	traced := make([]*PathHop, 0, len(path))
+	for i, v := range path {
+		if len(v.PerHopPayload) > 0 {
+			return nil, errors.New("sphinx: traced hop has a per-hop payload")
+		}
+		hop := *v
+		hop.PerHopPayload = make([]byte, 0, len(traceMagic)+TraceIDLength+1)
+		hop.PerHopPayload = append(hop.PerHopPayload, traceMagic...)
+		hop.PerHopPayload = append(hop.PerHopPayload, id[:]...)
+		hop.PerHopPayload = append(hop.PerHopPayload, byte(i))
+		traced = append(traced, &hop)
+	}
+	return NewPacket(r, traced, payload)
+}
+
+// WithTraceLogger attaches l to the Processor, which will log the TraceID,
+// hop number and timestamp of every traced packet it unwraps, and returns
+// the Processor.
+func (p *Processor) WithTraceLogger(l TraceLogger) *Processor {
+	p.trace.log = l
+	return p
+}
+
+func (p *Processor) traceUnwrap(cmds []commands.RoutingCommand) {
+	if p.trace.log == nil {
+		return
+	}
+	for _, v := range cmds {
+		cmd, ok := v.(*commands.PerHopPayload)
+		if !ok || len(cmd.Payload) != len(traceMagic)+TraceIDLength+1 || !bytes.HasPrefix(cmd.Payload, traceMagic) {
+			continue
+		}
+		var id TraceID
+		b := cmd.Payload[len(traceMagic):]
+		copy(id[:], b)
+		p.trace.log.Noticef("sphinx trace: TraceID: %x hopNumber: %d timestamp: %d", id[:], b[TraceIDLength], time.Now().UnixNano())
+	}
+}
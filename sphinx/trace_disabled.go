@@ -0,0 +1,25 @@
+// trace_disabled.go - Sphinx packet tracing stubs.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !sphinx_trace
+
+package sphinx
+
+import "github.com/katzenpost/core/sphinx/commands"
+
+type processorTrace struct{}
+
+func (p *Processor) traceUnwrap(cmds []commands.RoutingCommand) {}
@@ -0,0 +1,79 @@
+// trace_test.go - Sphinx packet tracing tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build sphinx_trace
+
+package sphinx
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+type testTraceLogger struct {
+	lines []string
+}
+
+func (l *testTraceLogger) Noticef(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestTracedPacket(t *testing.T) {
+	require := require.New(t)
+
+	const nrHops = 3
+	nodes, path := newPathVector(require, nrHops, false)
+	var id TraceID
+	_, err := rand.Reader.Read(id[:])
+	require.NoError(err)
+	payload := []byte("traced payload")
+	pkt, err := NewTracedPacket(rand.Reader, path, payload, id)
+	require.NoError(err, "NewTracedPacket()")
+
+	// Each simulated mix unwraps the packet, logging the trace.
+	logs := make([]*testTraceLogger, nrHops)
+	for i := range nodes {
+		logs[i] = new(testTraceLogger)
+		p := NewProcessor().WithTraceLogger(logs[i])
+		b, _, _, err := p.Unwrap(nodes[i].privateKey, pkt)
+		require.NoError(err, "Hop %d: Unwrap()", i)
+		if i == nrHops-1 {
+			require.Equal(payload, b)
+		}
+	}
+
+	re := regexp.MustCompile(`^sphinx trace: TraceID: ([0-9a-f]{16}) hopNumber: (\d+) timestamp: \d+$`)
+	for i, l := range logs {
+		require.Len(l.lines, 1, "Hop %d: log", i)
+		m := re.FindStringSubmatch(l.lines[0])
+		require.NotNil(m, "Hop %d: log line: %v", i, l.lines[0])
+		require.Equal(fmt.Sprintf("%x", id[:]), m[1], "Hop %d: TraceID", i)
+		require.Equal(fmt.Sprintf("%d", i), m[2], "Hop %d: hopNumber", i)
+	}
+
+	// Untraced packets are not logged.
+	nodes, path = newPathVector(require, nrHops, false)
+	pkt, err = NewPacket(rand.Reader, path, payload)
+	require.NoError(err, "NewPacket()")
+	l := new(testTraceLogger)
+	_, _, _, err = NewProcessor().WithTraceLogger(l).Unwrap(nodes[0].privateKey, pkt)
+	require.NoError(err, "Unwrap()")
+	require.Empty(l.lines)
+}
@@ -0,0 +1,161 @@
+// pki.go - PKI test helpers.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package pki provides helpers for generating PKI documents and descriptors
+// for use in tests.  All keys are derived deterministically from the node
+// identifiers, and MUST NOT be used outside of tests.
+package pki
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/pki"
+)
+
+const (
+	// NumLayers is the number of mix layers in the topology of documents
+	// returned by NewTestDocument.
+	NumLayers = 3
+
+	// DefaultBandwidth is the BandwidthBytesPerSec of test descriptors.
+	DefaultBandwidth = 1 << 20
+)
+
+// deriveSeed returns the deterministic entropy source used to generate the
+// key of type label for the node id.
+func deriveSeed(label, id string) *bytes.Reader {
+	h := sha256.New()
+	h.Write([]byte("katzenpost-testutil-pki/" + label + "/"))
+	h.Write([]byte(id))
+	return bytes.NewReader(h.Sum(nil))
+}
+
+// DeriveKeypair returns the identity key of the node id.
+func DeriveKeypair(id string) *eddsa.PrivateKey {
+	k, err := eddsa.NewKeypair(deriveSeed("identity", id))
+	if err != nil {
+		panic("testutil/pki: failed to derive identity key: " + err.Error())
+	}
+	return k
+}
+
+// DeriveLinkKeypair returns the wire protocol link key of the node id.
+func DeriveLinkKeypair(id string) *ecdh.PrivateKey {
+	return deriveECDHKeypair("link", id)
+}
+
+// DeriveMixKeypair returns the Sphinx key of the node id for the epoch.
+func DeriveMixKeypair(id string, epoch uint64) *ecdh.PrivateKey {
+	return deriveECDHKeypair(fmt.Sprintf("mix/%d", epoch), id)
+}
+
+func deriveECDHKeypair(label, id string) *ecdh.PrivateKey {
+	k, err := ecdh.NewKeypair(deriveSeed(label, id))
+	if err != nil {
+		panic("testutil/pki: failed to derive " + label + " key: " + err.Error())
+	}
+	return k
+}
+
+// NewTestDescriptor returns a descriptor for the node id, in layer 0, with
+// no Sphinx keys.
+func NewTestDescriptor(id string) *pki.MixDescriptor {
+	identityKey := DeriveKeypair(id)
+	port := 1024 + binary.BigEndian.Uint16(identityKey.PublicKey().Bytes())%64511
+	return &pki.MixDescriptor{
+		Name:        id,
+		IdentityKey: identityKey.PublicKey(),
+		LinkKey:     DeriveLinkKeypair(id).PublicKey(),
+		MixKeys:     make(map[uint64]*ecdh.PublicKey),
+		Addresses: map[pki.Transport][]string{
+			pki.TransportTCPv4: {fmt.Sprintf("127.0.0.1:%d", port)},
+		},
+		BandwidthBytesPerSec: DefaultBandwidth,
+	}
+}
+
+// NewTestDocument returns a document for the epoch, with numMixes mixes
+// named "mix0", "mix1", ... assigned to the NumLayers layers in round robin
+// order, and numProviders providers named "provider0", "provider1", ....
+// Every node has a Sphinx key for the epoch.
+func NewTestDocument(epoch uint64, numMixes int, numProviders int) *pki.Document {
+	doc := &pki.Document{
+		Epoch:             epoch,
+		GenesisEpoch:      epoch,
+		SendRatePerMinute: 100,
+		Mu:                0.001,
+		MuMaxDelay:        9000,
+		LambdaP:           0.002,
+		LambdaPMaxDelay:   9000,
+		LambdaL:           0.0005,
+		LambdaLMaxDelay:   9000,
+		LambdaD:           0.0005,
+		LambdaDMaxDelay:   9000,
+		LambdaM:           0.0005,
+		LambdaMMaxDelay:   9000,
+		Topology:          make([][]*pki.MixDescriptor, NumLayers),
+	}
+	for i := 0; i < numMixes; i++ {
+		desc := newTestNode(fmt.Sprintf("mix%d", i), epoch)
+		desc.Layer = uint8(i % NumLayers)
+		doc.Topology[desc.Layer] = append(doc.Topology[desc.Layer], desc)
+	}
+	for i := 0; i < numProviders; i++ {
+		desc := newTestNode(fmt.Sprintf("provider%d", i), epoch)
+		desc.Layer = pki.LayerProvider
+		doc.Providers = append(doc.Providers, desc)
+	}
+	return doc
+}
+
+func newTestNode(id string, epoch uint64) *pki.MixDescriptor {
+	desc := NewTestDescriptor(id)
+	desc.MixKeys[epoch] = DeriveMixKeypair(id, epoch).PublicKey()
+	return desc
+}
+
+// SignTestDocument returns the JSON serialized doc, wrapped in a certificate
+// signed by all of the keys.  The certificate expires 600 years after the
+// Unix epoch, so that documents for arbitrary epochs may be signed.
+func SignTestDocument(doc *pki.Document, keys ...*eddsa.PrivateKey) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("testutil/pki: no signing keys")
+	}
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	expiration := time.Unix(0, 0).AddDate(600, 0, 0).Unix()
+	signed, err := cert.Sign(keys[0], payload, expiration)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys[1:] {
+		if signed, err = cert.SignMulti(k, signed); err != nil {
+			return nil, err
+		}
+	}
+	return signed, nil
+}
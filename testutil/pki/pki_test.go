@@ -0,0 +1,93 @@
+// pki_test.go - PKI test helper tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pki
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/katzenpost/core/crypto/cert"
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTestDescriptor(t *testing.T) {
+	require := require.New(t)
+
+	a, b := NewTestDescriptor("mix0"), NewTestDescriptor("mix0")
+	require.Equal(a, b, "same id, same descriptor")
+	require.NoError(pki.ValidateAddresses(a.Addresses))
+
+	c := NewTestDescriptor("mix1")
+	require.NotEqual(a.IdentityKey.Bytes(), c.IdentityKey.Bytes())
+	require.NotEqual(a.LinkKey.Bytes(), c.LinkKey.Bytes())
+	require.Equal(a.IdentityKey.Bytes(), DeriveKeypair("mix0").PublicKey().Bytes())
+}
+
+func TestNewTestDocument(t *testing.T) {
+	require := require.New(t)
+
+	const epoch = 1234
+	doc := NewTestDocument(epoch, 7, 2)
+	require.Len(doc.Topology, NumLayers)
+	require.Len(doc.Topology[0], 3)
+	require.Len(doc.Topology[1], 2)
+	require.Len(doc.Topology[2], 2)
+	require.Len(doc.Providers, 2)
+	for layer, nodes := range doc.Topology {
+		for _, desc := range nodes {
+			require.Equal(uint8(layer), desc.Layer)
+			require.Contains(desc.MixKeys, uint64(epoch))
+		}
+	}
+	for _, desc := range doc.Providers {
+		require.Equal(uint8(pki.LayerProvider), desc.Layer)
+		require.Contains(desc.MixKeys, uint64(epoch))
+	}
+
+	rawA, err := json.Marshal(doc)
+	require.NoError(err)
+	rawB, err := json.Marshal(NewTestDocument(epoch, 7, 2))
+	require.NoError(err)
+	require.Equal(rawA, rawB, "same parameters, same document")
+
+	// Sphinx keys are rotated every epoch.
+	next := NewTestDocument(epoch+1, 7, 2)
+	require.Equal(doc.Topology[0][0].IdentityKey, next.Topology[0][0].IdentityKey)
+	require.NotEqual(doc.Topology[0][0].MixKeys[epoch].Bytes(), next.Topology[0][0].MixKeys[epoch+1].Bytes())
+}
+
+func TestSignTestDocument(t *testing.T) {
+	require := require.New(t)
+
+	doc := NewTestDocument(1, 3, 1)
+	authority1, authority2 := DeriveKeypair("authority1"), DeriveKeypair("authority2")
+	signedA, err := SignTestDocument(doc, authority1, authority2)
+	require.NoError(err)
+	signedB, err := SignTestDocument(doc, authority1, authority2)
+	require.NoError(err)
+	require.Equal(signedA, signedB, "same document and keys, same signature")
+
+	payload, err := cert.VerifyAll([]cert.Verifier{authority1.PublicKey(), authority2.PublicKey()}, signedA)
+	require.NoError(err)
+	expected, err := json.Marshal(doc)
+	require.NoError(err)
+	require.Equal(expected, payload)
+
+	_, err = SignTestDocument(doc)
+	require.Error(err, "SignTestDocument(no keys)")
+}
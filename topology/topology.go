@@ -0,0 +1,94 @@
+// topology.go - Mix network topology visualization.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package topology provides visualization and validation of the mix network
+// topology.
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/katzenpost/core/pki"
+)
+
+// fingerprintLength is the number of hex characters of a node's identity
+// key included in its label.
+const fingerprintLength = 16
+
+var errNoTopology = errors.New("topology: document has no topology")
+
+// TopologyToDOT returns the mix network described by doc as a Graphviz DOT
+// digraph.  Each provider and mix is a node, labeled with its name, layer and
+// truncated identity key, and there is an edge for every hop a packet may
+// take: from each provider to each mix of the first layer, between each mix
+// of adjacent layers, and from each mix of the last layer to each provider.
+func TopologyToDOT(doc *pki.Document) (string, error) {
+	if len(doc.Topology) == 0 {
+		return "", errNoTopology
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph topology {\n")
+	b.WriteString("\trankdir=LR;\n")
+	for _, desc := range doc.Providers {
+		writeNode(&b, desc, "provider", "box")
+	}
+	for layer, nodes := range doc.Topology {
+		if len(nodes) == 0 {
+			return "", fmt.Errorf("topology: layer %v is empty", layer)
+		}
+		for _, desc := range nodes {
+			writeNode(&b, desc, fmt.Sprintf("layer %d", layer), "ellipse")
+		}
+	}
+
+	writeEdges(&b, doc.Providers, doc.Topology[0])
+	for i := 1; i < len(doc.Topology); i++ {
+		writeEdges(&b, doc.Topology[i-1], doc.Topology[i])
+	}
+	writeEdges(&b, doc.Topology[len(doc.Topology)-1], doc.Providers)
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+func writeNode(b *strings.Builder, desc *pki.MixDescriptor, layer, shape string) {
+	fingerprint := ""
+	if desc.IdentityKey != nil {
+		fingerprint = desc.IdentityKey.String()
+		if len(fingerprint) > fingerprintLength {
+			fingerprint = fingerprint[:fingerprintLength]
+		}
+	}
+	label := fmt.Sprintf("%s\\n%s\\n%s", escape(desc.Name), layer, fingerprint)
+	fmt.Fprintf(b, "\t\"%s\" [label=\"%s\", shape=%s];\n", escape(desc.Name), label, shape)
+}
+
+func writeEdges(b *strings.Builder, from, to []*pki.MixDescriptor) {
+	for _, src := range from {
+		for _, dst := range to {
+			fmt.Fprintf(b, "\t\"%s\" -> \"%s\";\n", escape(src.Name), escape(dst.Name))
+		}
+	}
+}
+
+// escape escapes s for use in a DOT quoted string.
+func escape(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return strings.Replace(s, "\"", "\\\"", -1)
+}
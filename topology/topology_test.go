@@ -0,0 +1,237 @@
+// topology_test.go - Mix network topology visualization tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+// dotGraph is the result of parsing the subset of the DOT language emitted
+// by TopologyToDOT.
+type dotGraph struct {
+	nodes map[string]map[string]string
+	edges [][2]string
+}
+
+type dotToken struct {
+	quoted bool
+	s      string
+}
+
+func dotTokenize(s string) ([]dotToken, error) {
+	var toks []dotToken
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(s[i:], "->"):
+			toks = append(toks, dotToken{s: "->"})
+			i += 2
+		case strings.ContainsRune("{}[];=,", c):
+			toks = append(toks, dotToken{s: string(c)})
+			i++
+		case c == '"':
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, errors.New("unterminated string")
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					b.WriteByte(s[i])
+					b.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			toks = append(toks, dotToken{quoted: true, s: b.String()})
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, dotToken{s: s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func parseDOT(s string) (*dotGraph, error) {
+	toks, err := dotTokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	next := func() (dotToken, error) {
+		if pos >= len(toks) {
+			return dotToken{}, errors.New("unexpected end of input")
+		}
+		pos++
+		return toks[pos-1], nil
+	}
+	expect := func(s string) error {
+		t, err := next()
+		if err != nil {
+			return err
+		}
+		if t.quoted || t.s != s {
+			return fmt.Errorf("expected %q, got %q", s, t.s)
+		}
+		return nil
+	}
+	isID := func(t dotToken) bool {
+		return t.quoted || (len(t.s) > 0 && !strings.ContainsAny(t.s, "{}[];=,->"))
+	}
+
+	if err := expect("digraph"); err != nil {
+		return nil, err
+	}
+	if t, err := next(); err != nil || !isID(t) {
+		return nil, errors.New("missing graph ID")
+	}
+	if err := expect("{"); err != nil {
+		return nil, err
+	}
+	g := &dotGraph{nodes: make(map[string]map[string]string)}
+	for {
+		t, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if !t.quoted && t.s == "}" {
+			break
+		}
+		if !isID(t) {
+			return nil, fmt.Errorf("expected statement, got %q", t.s)
+		}
+		op, err := next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case op.s == "=" && !op.quoted: // Graph attribute.
+			if v, err := next(); err != nil || !isID(v) {
+				return nil, errors.New("missing attribute value")
+			}
+			if err := expect(";"); err != nil {
+				return nil, err
+			}
+		case op.s == "->" && !op.quoted: // Edge.
+			dst, err := next()
+			if err != nil || !isID(dst) {
+				return nil, errors.New("missing edge destination")
+			}
+			if err := expect(";"); err != nil {
+				return nil, err
+			}
+			g.edges = append(g.edges, [2]string{t.s, dst.s})
+		case op.s == "[" && !op.quoted: // Node with attributes.
+			attrs := make(map[string]string)
+			for {
+				k, err := next()
+				if err != nil || !isID(k) {
+					return nil, errors.New("missing attribute name")
+				}
+				if err := expect("="); err != nil {
+					return nil, err
+				}
+				v, err := next()
+				if err != nil || !isID(v) {
+					return nil, errors.New("missing attribute value")
+				}
+				attrs[k.s] = v.s
+				sep, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if sep.s == "]" {
+					break
+				} else if sep.s != "," {
+					return nil, fmt.Errorf("expected ',' or ']', got %q", sep.s)
+				}
+			}
+			if err := expect(";"); err != nil {
+				return nil, err
+			}
+			g.nodes[t.s] = attrs
+		default:
+			return nil, fmt.Errorf("unexpected %q", op.s)
+		}
+	}
+	if pos != len(toks) {
+		return nil, errors.New("trailing input")
+	}
+	return g, nil
+}
+
+func TestTopologyToDOT(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		numMixes     = 9
+		numProviders = 2
+	)
+	doc := testpki.NewTestDocument(1, numMixes, numProviders)
+	s, err := TopologyToDOT(doc)
+	require.NoError(err, "TopologyToDOT()")
+	t.Logf("%s", s)
+
+	g, err := parseDOT(s)
+	require.NoError(err, "parseDOT()")
+	require.Len(g.nodes, numMixes+numProviders)
+
+	layers := make(map[string]int)
+	for layer, nodes := range doc.Topology {
+		for _, desc := range nodes {
+			attrs, ok := g.nodes[desc.Name]
+			require.True(ok, "missing node %v", desc.Name)
+			label := strings.Split(attrs["label"], "\\n")
+			require.Len(label, 3)
+			require.Equal(fmt.Sprintf("layer %d", layer), label[1])
+			require.True(strings.HasPrefix(desc.IdentityKey.String(), label[2]), "fingerprint")
+			require.Len(label[2], fingerprintLength)
+			layers[label[1]]++
+		}
+	}
+	require.Len(layers, len(doc.Topology))
+
+	perLayer := numMixes / testpki.NumLayers
+	require.Len(g.edges, 2*numProviders*perLayer+(len(doc.Topology)-1)*perLayer*perLayer)
+	for _, e := range g.edges {
+		require.Contains(g.nodes, e[0])
+		require.Contains(g.nodes, e[1])
+	}
+
+	_, err = TopologyToDOT(&pki.Document{})
+	require.Error(err, "TopologyToDOT(empty)")
+}
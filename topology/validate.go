@@ -0,0 +1,137 @@
+// validate.go - Mix network topology validation.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package topology
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/pki"
+)
+
+// The reasons a TopologyError may be reported for.
+const (
+	// ReasonNoTopology is a document without any mix layers.
+	ReasonNoTopology = "document has no topology"
+
+	// ReasonEmptyLayer is a mix layer without any nodes.
+	ReasonEmptyLayer = "layer is empty"
+
+	// ReasonNoProviders is a document without any providers.
+	ReasonNoProviders = "document has no providers"
+
+	// ReasonMissingDescriptor is a routing table entry without a
+	// descriptor.
+	ReasonMissingDescriptor = "missing descriptor"
+
+	// ReasonMissingIdentityKey is a descriptor without an identity key.
+	ReasonMissingIdentityKey = "missing identity key"
+
+	// ReasonLayerOutOfRange is a mix descriptor assigned to a layer beyond
+	// the document's layer count.
+	ReasonLayerOutOfRange = "layer out of range"
+
+	// ReasonLayerMismatch is a descriptor listed in a different layer than
+	// the one it is assigned to.
+	ReasonLayerMismatch = "layer mismatch"
+
+	// ReasonDuplicateNode is a descriptor listed more than once.
+	ReasonDuplicateNode = "duplicate node"
+)
+
+// TopologyError is a problem with the topology of a Document.
+type TopologyError struct {
+	// NodeID is the identity key of the offending node, or nil if the
+	// error is not specific to a node.
+	NodeID []byte
+
+	// Layer is the layer the error was found in, pki.LayerProvider for
+	// the providers, or -1 if the error is not specific to a layer.
+	Layer int
+
+	// Reason is the reason for the error, one of the Reason constants.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e TopologyError) Error() string {
+	s := "topology: " + e.Reason
+	if e.Layer >= 0 {
+		s += fmt.Sprintf(" (layer %d)", e.Layer)
+	}
+	if e.NodeID != nil {
+		s += ": " + hex.EncodeToString(e.NodeID)
+	}
+	return s
+}
+
+// ValidateTopology checks that the routing table of doc is consistent, and
+// returns every problem found, or an empty slice if there are none.  Every
+// node referenced by the mix layers and the provider list must have a
+// descriptor with an identity key, each mix must be assigned to the layer it
+// is listed in (within the document's layer count), each provider must be
+// assigned to pki.LayerProvider, and no descriptor may be listed more than
+// once.
+func ValidateTopology(doc *pki.Document) []TopologyError {
+	errs := []TopologyError{}
+	if len(doc.Topology) == 0 {
+		errs = append(errs, TopologyError{Layer: -1, Reason: ReasonNoTopology})
+	}
+	if len(doc.Providers) == 0 {
+		errs = append(errs, TopologyError{Layer: -1, Reason: ReasonNoProviders})
+	}
+
+	seen := make(map[[eddsa.PublicKeySize]byte]bool)
+	check := func(desc *pki.MixDescriptor, layer int) {
+		if desc == nil {
+			errs = append(errs, TopologyError{Layer: layer, Reason: ReasonMissingDescriptor})
+			return
+		}
+		if desc.IdentityKey == nil {
+			errs = append(errs, TopologyError{Layer: layer, Reason: ReasonMissingIdentityKey})
+			return
+		}
+		id := desc.IdentityKey.ByteArray()
+		nodeID := append([]byte{}, id[:]...)
+		if seen[id] {
+			errs = append(errs, TopologyError{NodeID: nodeID, Layer: layer, Reason: ReasonDuplicateNode})
+			return
+		}
+		seen[id] = true
+
+		switch {
+		case layer != pki.LayerProvider && desc.Layer != pki.LayerProvider && int(desc.Layer) >= len(doc.Topology):
+			errs = append(errs, TopologyError{NodeID: nodeID, Layer: int(desc.Layer), Reason: ReasonLayerOutOfRange})
+		case int(desc.Layer) != layer:
+			errs = append(errs, TopologyError{NodeID: nodeID, Layer: layer, Reason: ReasonLayerMismatch})
+		}
+	}
+
+	for layer, nodes := range doc.Topology {
+		if len(nodes) == 0 {
+			errs = append(errs, TopologyError{Layer: layer, Reason: ReasonEmptyLayer})
+		}
+		for _, desc := range nodes {
+			check(desc, layer)
+		}
+	}
+	for _, desc := range doc.Providers {
+		check(desc, pki.LayerProvider)
+	}
+	return errs
+}
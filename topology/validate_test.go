@@ -0,0 +1,141 @@
+// validate_test.go - Mix network topology validation tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package topology
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/pki"
+	testpki "github.com/katzenpost/core/testutil/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTopology(t *testing.T) {
+	require := require.New(t)
+
+	require.Empty(ValidateTopology(testpki.NewTestDocument(1, 6, 2)), "valid document")
+
+	nodeID := func(desc *pki.MixDescriptor) []byte {
+		return desc.IdentityKey.Bytes()
+	}
+
+	for _, v := range []struct {
+		name   string
+		mutate func(doc *pki.Document) []TopologyError
+	}{
+		{
+			name: "no topology",
+			mutate: func(doc *pki.Document) []TopologyError {
+				doc.Topology = nil
+				return []TopologyError{{Layer: -1, Reason: ReasonNoTopology}}
+			},
+		},
+		{
+			name: "no providers",
+			mutate: func(doc *pki.Document) []TopologyError {
+				doc.Providers = nil
+				return []TopologyError{{Layer: -1, Reason: ReasonNoProviders}}
+			},
+		},
+		{
+			name: "empty layer",
+			mutate: func(doc *pki.Document) []TopologyError {
+				doc.Topology[1] = nil
+				return []TopologyError{{Layer: 1, Reason: ReasonEmptyLayer}}
+			},
+		},
+		{
+			name: "missing descriptor",
+			mutate: func(doc *pki.Document) []TopologyError {
+				doc.Topology[2][0] = nil
+				return []TopologyError{{Layer: 2, Reason: ReasonMissingDescriptor}}
+			},
+		},
+		{
+			name: "missing provider descriptor",
+			mutate: func(doc *pki.Document) []TopologyError {
+				doc.Providers = append(doc.Providers, nil)
+				return []TopologyError{{Layer: pki.LayerProvider, Reason: ReasonMissingDescriptor}}
+			},
+		},
+		{
+			name: "missing identity key",
+			mutate: func(doc *pki.Document) []TopologyError {
+				doc.Topology[0][1].IdentityKey = nil
+				return []TopologyError{{Layer: 0, Reason: ReasonMissingIdentityKey}}
+			},
+		},
+		{
+			name: "layer out of range",
+			mutate: func(doc *pki.Document) []TopologyError {
+				desc := doc.Topology[1][0]
+				desc.Layer = uint8(len(doc.Topology))
+				return []TopologyError{{NodeID: nodeID(desc), Layer: len(doc.Topology), Reason: ReasonLayerOutOfRange}}
+			},
+		},
+		{
+			name: "layer mismatch",
+			mutate: func(doc *pki.Document) []TopologyError {
+				desc := doc.Topology[0][0]
+				desc.Layer = 2
+				return []TopologyError{{NodeID: nodeID(desc), Layer: 0, Reason: ReasonLayerMismatch}}
+			},
+		},
+		{
+			name: "orphan provider",
+			mutate: func(doc *pki.Document) []TopologyError {
+				desc := doc.Providers[0]
+				doc.Providers = doc.Providers[1:]
+				doc.Topology[2] = append(doc.Topology[2], desc)
+				return []TopologyError{{NodeID: nodeID(desc), Layer: 2, Reason: ReasonLayerMismatch}}
+			},
+		},
+		{
+			name: "mix listed as provider",
+			mutate: func(doc *pki.Document) []TopologyError {
+				desc := doc.Topology[1][1]
+				doc.Providers = append(doc.Providers, desc)
+				doc.Topology[1] = doc.Topology[1][:1]
+				return []TopologyError{{NodeID: nodeID(desc), Layer: pki.LayerProvider, Reason: ReasonLayerMismatch}}
+			},
+		},
+		{
+			name: "duplicate node",
+			mutate: func(doc *pki.Document) []TopologyError {
+				desc := doc.Topology[0][0]
+				doc.Topology[0] = append(doc.Topology[0], desc)
+				return []TopologyError{{NodeID: nodeID(desc), Layer: 0, Reason: ReasonDuplicateNode}}
+			},
+		},
+	} {
+		doc := testpki.NewTestDocument(1, 6, 2)
+		expected := v.mutate(doc)
+		require.Equal(expected, ValidateTopology(doc), v.name)
+	}
+
+	// Every problem is reported, not just the first.
+	doc := testpki.NewTestDocument(1, 6, 2)
+	doc.Topology[0][0] = nil
+	doc.Topology[1] = nil
+	doc.Providers[1].IdentityKey = nil
+	errs := ValidateTopology(doc)
+	require.Len(errs, 3)
+	require.Equal(ReasonMissingDescriptor, errs[0].Reason)
+	require.Equal(ReasonEmptyLayer, errs[1].Reason)
+	require.Equal(ReasonMissingIdentityKey, errs[2].Reason)
+	require.EqualError(errs[1], "topology: layer is empty (layer 1)")
+}
@@ -0,0 +1,71 @@
+// bandwidth.go - Wire protocol session bandwidth limiting.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import "time"
+
+// clock is a source of time, that may be substituted in tests.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SetBandwidthLimit limits the rate at which command bytes, including
+// framing and authentication overhead, are sent to bytesPerSec, with a burst
+// of up to a second's worth of bytes.  SendCommand blocks until a command
+// that exceeds the limit is permitted, without holding up other senders, and
+// Close interrupts the wait.  A limit <= 0 disables limiting.
+func (s *Session) SetBandwidthLimit(bytesPerSec int64) {
+	s.bandwidthLimiter.set(float64(bytesPerSec), int(bytesPerSec))
+}
+
+// BytesSent returns the number of bytes sent, including framing and
+// authentication overhead.
+func (s *Session) BytesSent() int64 {
+	_, out := s.stats.byteCounts()
+	return int64(out)
+}
+
+// BytesRecv returns the number of bytes received, including framing and
+// authentication overhead.
+func (s *Session) BytesRecv() int64 {
+	in, _ := s.stats.byteCounts()
+	return int64(in)
+}
+
+// ResetCounters resets the sent and received byte counters, and the
+// corresponding SessionStats counters, to zero.
+func (s *Session) ResetCounters() {
+	s.stats.resetByteCounts()
+}
+
+func (s *Session) waitBandwidth(n int) error {
+	if d := s.bandwidthLimiter.reserveN(float64(n)); d > 0 {
+		return s.sleep(d)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// circuit_breaker.go - Wire protocol session circuit breaker.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// circuitBreakerBaseBackoff is the backoff after the first failure,
+	// which doubles with each further consecutive failure.
+	circuitBreakerBaseBackoff = 100 * time.Millisecond
+)
+
+// ErrCircuitOpen is the error returned by CircuitBreakerDialer.Dial when the
+// circuit is open, and no connection was attempted.
+var ErrCircuitOpen = errors.New("wire: circuit open")
+
+// CircuitBreakerDialer establishes client sessions to a single peer, backing
+// off exponentially between consecutive failed attempts, and refusing to
+// attempt to connect at all for a period after too many.  It is safe for
+// concurrent use.
+type CircuitBreakerDialer struct {
+	// Config is the configuration of the sessions established by Dial,
+	// and MUST be set prior to calling Dial.
+	Config *SessionConfig
+
+	sync.Mutex
+
+	target       string
+	maxFailures  int
+	resetTimeout time.Duration
+
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	clock  clock
+	dialer net.Dialer
+}
+
+// NewCircuitBreakerDialer creates a CircuitBreakerDialer for the peer at the
+// TCP address target.  After maxFailures consecutive failed attempts the
+// circuit opens, and Dial fails with ErrCircuitOpen until resetTimeout has
+// elapsed.  The circuit is then half-open, and a single attempt is allowed,
+// which closes the circuit if it succeeds, and re-opens it otherwise.
+func NewCircuitBreakerDialer(target string, maxFailures int, resetTimeout time.Duration) *CircuitBreakerDialer {
+	if maxFailures <= 0 {
+		panic("wire: invalid maxFailures")
+	}
+	return &CircuitBreakerDialer{
+		target:       target,
+		maxFailures:  maxFailures,
+		resetTimeout: resetTimeout,
+		clock:        systemClock{},
+	}
+}
+
+// Dial establishes a new client session with the peer, after first waiting
+// for the backoff period if the previous attempt failed.
+func (d *CircuitBreakerDialer) Dial(ctx context.Context) (*Session, error) {
+	backoff, err := d.backoff()
+	if err != nil {
+		return nil, err
+	}
+	if backoff > 0 {
+		select {
+		case <-d.clock.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s, err := d.dial(ctx)
+	d.Lock()
+	defer d.Unlock()
+	d.probing = false
+	if err != nil {
+		d.failures++
+		if d.failures >= d.maxFailures {
+			d.openedAt = d.clock.Now()
+		}
+		return nil, err
+	}
+	d.failures = 0
+	return s, nil
+}
+
+// backoff returns how long to wait before the next attempt, or
+// ErrCircuitOpen if no attempt should be made.
+func (d *CircuitBreakerDialer) backoff() (time.Duration, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	switch {
+	case d.failures == 0:
+		return 0, nil
+	case d.failures >= d.maxFailures:
+		// Once the reset timeout has elapsed, the circuit is half-open,
+		// and a single attempt may be made immediately.
+		if d.probing || d.clock.Now().Sub(d.openedAt) < d.resetTimeout {
+			return 0, ErrCircuitOpen
+		}
+		d.probing = true
+		return 0, nil
+	}
+
+	// Exponential backoff with jitter, uniformly distributed between half
+	// and all of the nominal backoff.
+	backoff := circuitBreakerBaseBackoff << uint(d.failures-1)
+	if d.resetTimeout > 0 && backoff > d.resetTimeout {
+		backoff = d.resetTimeout
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)), nil
+}
+
+func (d *CircuitBreakerDialer) dial(ctx context.Context) (*Session, error) {
+	if d.Config == nil {
+		return nil, configError("Dial", errors.New("missing Config"))
+	}
+	return DialSession(ctx, &d.dialer, d.target, d.Config)
+}
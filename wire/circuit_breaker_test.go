@@ -0,0 +1,216 @@
+// circuit_breaker_test.go - Wire protocol session circuit breaker tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+type acceptAllAuthenticator struct{}
+
+func (acceptAllAuthenticator) IsPeerValid(*PeerCredentials) bool {
+	return true
+}
+
+// flakyServer drops the first nrFailures connections, and completes the
+// handshake on the rest.
+type flakyServer struct {
+	l          net.Listener
+	nrFailures uint32
+	conns      uint32
+}
+
+func newFlakyServer(t *testing.T, nrFailures uint32) *flakyServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Listen()")
+	authKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(t, err, "NewKeypair()")
+
+	srv := &flakyServer{l: l, nrFailures: nrFailures}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if atomic.AddUint32(&srv.conns, 1) <= srv.nrFailures {
+				conn.Close()
+				continue
+			}
+			go func() {
+				s, err := NewSession(&SessionConfig{
+					Authenticator:     acceptAllAuthenticator{},
+					AuthenticationKey: authKey,
+					RandomReader:      rand.Reader,
+				}, false)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer s.Close()
+				if s.Initialize(conn) != nil {
+					return
+				}
+				for {
+					if _, err := s.RecvCommand(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return srv
+}
+
+func (srv *flakyServer) connections() uint32 {
+	return atomic.LoadUint32(&srv.conns)
+}
+
+func TestCircuitBreakerDialer(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		nrFailures   = 5
+		maxFailures  = 3
+		resetTimeout = time.Minute
+	)
+	srv := newFlakyServer(t, nrFailures)
+	defer srv.l.Close()
+
+	authKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "NewKeypair()")
+	clk := &fakeClock{now: time.Now()}
+	d := NewCircuitBreakerDialer(srv.l.Addr().String(), maxFailures, resetTimeout)
+	d.clock = clk
+	d.Config = &SessionConfig{
+		Authenticator:     acceptAllAuthenticator{},
+		AuthenticationKey: authKey,
+		RandomReader:      rand.Reader,
+	}
+	ctx := context.Background()
+
+	// The first attempts fail, backing off exponentially.
+	start := clk.Now()
+	for i := 1; i <= maxFailures; i++ {
+		_, err = d.Dial(ctx)
+		require.Error(err, "Dial() %d", i)
+		require.NotEqual(ErrCircuitOpen, err, "Dial() %d", i)
+		require.Equal(uint32(i), srv.connections())
+	}
+	backoff := clk.Now().Sub(start)
+	require.True(backoff >= circuitBreakerBaseBackoff/2+circuitBreakerBaseBackoff, "backoff %v", backoff)
+	require.True(backoff <= circuitBreakerBaseBackoff+2*circuitBreakerBaseBackoff, "backoff %v", backoff)
+
+	// Then the circuit opens, and no connections are attempted.
+	_, err = d.Dial(ctx)
+	require.Equal(ErrCircuitOpen, err)
+	clk.Sleep(resetTimeout - time.Second)
+	_, err = d.Dial(ctx)
+	require.Equal(ErrCircuitOpen, err)
+	require.Equal(uint32(maxFailures), srv.connections())
+
+	// After the reset timeout, a failed attempt re-opens the circuit.
+	for i := maxFailures + 1; i <= nrFailures; i++ {
+		clk.Sleep(resetTimeout)
+		_, err = d.Dial(ctx)
+		require.Error(err, "Dial() %d", i)
+		require.NotEqual(ErrCircuitOpen, err, "Dial() %d", i)
+		require.Equal(uint32(i), srv.connections())
+		_, err = d.Dial(ctx)
+		require.Equal(ErrCircuitOpen, err)
+	}
+
+	// And a successful one closes it.
+	clk.Sleep(resetTimeout)
+	s, err := d.Dial(ctx)
+	require.NoError(err, "Dial() after reset")
+	s.Close()
+	s, err = d.Dial(ctx)
+	require.NoError(err, "Dial() with the circuit closed")
+	s.Close()
+	require.Equal(uint32(nrFailures+2), srv.connections())
+}
+
+func TestCircuitBreakerDialerHalfOpen(t *testing.T) {
+	require := require.New(t)
+
+	// The first connection is dropped, and the rest are held open without
+	// ever completing the handshake, until the listener is closed.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err, "Listen()")
+	var conns uint32
+	connCh := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if atomic.AddUint32(&conns, 1) == 1 {
+				conn.Close()
+				continue
+			}
+			connCh <- conn
+		}
+	}()
+	defer l.Close()
+
+	const resetTimeout = time.Minute
+	authKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "NewKeypair()")
+	clk := &fakeClock{now: time.Now()}
+	d := NewCircuitBreakerDialer(l.Addr().String(), 1, resetTimeout)
+	d.clock = clk
+	d.Config = &SessionConfig{
+		Authenticator:     acceptAllAuthenticator{},
+		AuthenticationKey: authKey,
+		RandomReader:      rand.Reader,
+	}
+	ctx := context.Background()
+	_, err = d.Dial(ctx)
+	require.Error(err, "Dial()")
+	require.NotEqual(ErrCircuitOpen, err)
+
+	// Once half-open, only a single attempt is made at a time.
+	clk.Sleep(resetTimeout)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := d.Dial(ctx)
+		errCh <- err
+	}()
+	conn := <-connCh
+	_, err = d.Dial(ctx)
+	require.Equal(ErrCircuitOpen, err, "concurrent Dial() while half-open")
+	require.Equal(uint32(2), atomic.LoadUint32(&conns))
+
+	// The probe failing re-opens the circuit.
+	conn.Close()
+	err = <-errCh
+	require.Error(err, "half-open Dial()")
+	require.NotEqual(ErrCircuitOpen, err)
+	_, err = d.Dial(ctx)
+	require.Equal(ErrCircuitOpen, err, "Dial() after the probe failed")
+	require.Equal(uint32(2), atomic.LoadUint32(&conns))
+}
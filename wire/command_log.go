@@ -0,0 +1,134 @@
+// command_log.go - Wire protocol session command log.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/utils"
+	"github.com/katzenpost/core/wire/commands"
+)
+
+const commandLogKeyLength = 32
+
+const (
+	// CommandLogDirectionSend is the direction of commands sent to the peer.
+	CommandLogDirectionSend = "send"
+
+	// CommandLogDirectionRecv is the direction of commands received from
+	// the peer.
+	CommandLogDirectionRecv = "recv"
+)
+
+// CommandLogRecord is a single newline delimited JSON record in a session
+// command log.  The command payload itself is never logged, only its
+// length and a digest keyed with a random key that is unique to the log,
+// so that identical commands may be matched within a log, but payloads
+// can neither be guessed nor linked across logs.
+type CommandLogRecord struct {
+	// Direction is either CommandLogDirectionSend or CommandLogDirectionRecv.
+	Direction string `json:"direction"`
+
+	// Timestamp is the time the command was sent or received.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Command is the command type name (eg: "SendPacket").
+	Command string `json:"command"`
+
+	// PayloadHash is the hex encoded HMAC-SHA256 digest of the serialized
+	// command, keyed with the log's key.
+	PayloadHash string `json:"payload_hash"`
+
+	// PayloadLength is the length of the serialized command in bytes.
+	PayloadLength int `json:"payload_length"`
+}
+
+type commandLog struct {
+	sync.Mutex
+
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	key []byte
+}
+
+func (l *commandLog) enable(path string) error {
+	key := make([]byte, commandLogKeyLength)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.closeLocked()
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.enc = json.NewEncoder(l.w)
+	l.key = key
+	return nil
+}
+
+func (l *commandLog) disable() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.closeLocked()
+}
+
+func (l *commandLog) closeLocked() {
+	if l.f == nil {
+		return
+	}
+	l.w.Flush()
+	l.f.Close()
+	utils.ExplicitBzero(l.key)
+	l.f, l.w, l.enc, l.key = nil, nil, nil, nil
+}
+
+// record logs the command cmd, which serializes to b.  Failures to write
+// the log are not fatal to the session, and are ignored.
+func (l *commandLog) record(direction string, cmd commands.Command, b []byte) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.f == nil {
+		return
+	}
+	m := hmac.New(sha256.New, l.key)
+	m.Write(b)
+	l.enc.Encode(&CommandLogRecord{
+		Direction:     direction,
+		Timestamp:     time.Now(),
+		Command:       string(commandType(cmd)),
+		PayloadHash:   hex.EncodeToString(m.Sum(nil)),
+		PayloadLength: len(b),
+	})
+}
@@ -52,6 +52,11 @@ const (
 	revealOverhead     = 8 + eddsa.PublicKeySize
 	revealStatusLength = 1
 
+	relayAckLength = 4 + 4 + 4
+
+	goAwayBaseLength = 4
+	throttleLength   = 4
+
 	messageTypeMessage messageType = 0
 	messageTypeACK     messageType = 1
 	messageTypeEmpty   messageType = 2
@@ -73,6 +78,9 @@ const (
 	getVote              commandID = 24
 	reveal               commandID = 25
 	revealStatus         commandID = 26
+	relayAck             commandID = 27
+	goAway               commandID = 28
+	throttle             commandID = 29
 
 	// ConsensusOk signifies that the GetConsensus request has completed
 	// successfully.
@@ -145,7 +153,11 @@ const (
 	RevealTooLate = 12
 )
 
-var errInvalidCommand = errors.New("wire: invalid wire protocol command")
+var errInvalidCommand = invalidCommand(errors.New("invalid wire protocol command"))
+
+func invalidCommand(err error) error {
+	return &WireError{Code: ErrCodeProtocolViolation, Op: "parse command", Wrapped: err}
+}
 
 type (
 	commandID   byte
@@ -217,7 +229,7 @@ func getVoteFromBytes(b []byte) (Command, error) {
 	r.PublicKey = new(eddsa.PublicKey)
 	err := r.PublicKey.FromBytes(b[8:40])
 	if err != nil {
-		return nil, err
+		return nil, invalidCommand(err)
 	}
 	return r, nil
 }
@@ -327,7 +339,7 @@ func (r *Reveal) ToBytes() []byte {
 
 func revealFromBytes(b []byte) (Command, error) {
 	if len(b) < revealOverhead {
-		return nil, errors.New(" wtf: errInvalidCommand")
+		return nil, errInvalidCommand
 	}
 
 	r := new(Reveal)
@@ -335,7 +347,7 @@ func revealFromBytes(b []byte) (Command, error) {
 	r.PublicKey = new(eddsa.PublicKey)
 	err := r.PublicKey.FromBytes(b[8:40])
 	if err != nil {
-		return nil, err
+		return nil, invalidCommand(err)
 	}
 	r.Payload = make([]byte, 0, len(b)-revealOverhead)
 	r.Payload = append(r.Payload, b[revealOverhead:]...)
@@ -349,7 +361,7 @@ type RevealStatus struct {
 
 func revealStatusFromBytes(b []byte) (Command, error) {
 	if len(b) != revealStatusLength {
-		return nil, errors.New(" wtf: errInvalidCommand")
+		return nil, errInvalidCommand
 	}
 
 	r := new(RevealStatus)
@@ -382,7 +394,7 @@ func voteFromBytes(b []byte) (Command, error) {
 	r.PublicKey = new(eddsa.PublicKey)
 	err := r.PublicKey.FromBytes(b[8:40])
 	if err != nil {
-		return nil, err
+		return nil, invalidCommand(err)
 	}
 	r.Payload = make([]byte, 0, len(b)-voteOverhead)
 	r.Payload = append(r.Payload, b[voteOverhead:]...)
@@ -424,6 +436,93 @@ func voteStatusFromBytes(b []byte) (Command, error) {
 	return r, nil
 }
 
+// RelayAck is a de-serialized relay_ack command, periodically sent by a
+// server to inform the client of how many of its packets were relayed, and
+// of the depth of the server's relay queue.
+type RelayAck struct {
+	Accepted   uint32
+	Rejected   uint32
+	QueueDepth uint32
+}
+
+// ToBytes serializes the RelayAck and returns the resulting slice.
+func (c *RelayAck) ToBytes() []byte {
+	out := make([]byte, cmdOverhead+relayAckLength)
+	out[0] = byte(relayAck)
+	binary.BigEndian.PutUint32(out[2:6], relayAckLength)
+	binary.BigEndian.PutUint32(out[6:10], c.Accepted)
+	binary.BigEndian.PutUint32(out[10:14], c.Rejected)
+	binary.BigEndian.PutUint32(out[14:18], c.QueueDepth)
+	return out
+}
+
+func relayAckFromBytes(b []byte) (Command, error) {
+	if len(b) != relayAckLength {
+		return nil, errInvalidCommand
+	}
+
+	r := new(RelayAck)
+	r.Accepted = binary.BigEndian.Uint32(b[0:4])
+	r.Rejected = binary.BigEndian.Uint32(b[4:8])
+	r.QueueDepth = binary.BigEndian.Uint32(b[8:12])
+	return r, nil
+}
+
+// GoAway is a de-serialized go_away command, sent by a server prior to
+// closing the session when it is shutting down.  Clients should not attempt
+// to reconnect until RetryAfterSeconds have elapsed.
+type GoAway struct {
+	Reason            string
+	RetryAfterSeconds uint32
+}
+
+// ToBytes serializes the GoAway and returns the resulting slice.
+func (c *GoAway) ToBytes() []byte {
+	out := make([]byte, cmdOverhead+goAwayBaseLength, cmdOverhead+goAwayBaseLength+len(c.Reason))
+	out[0] = byte(goAway)
+	binary.BigEndian.PutUint32(out[2:6], uint32(goAwayBaseLength+len(c.Reason)))
+	binary.BigEndian.PutUint32(out[6:10], c.RetryAfterSeconds)
+	out = append(out, c.Reason...)
+	return out
+}
+
+func goAwayFromBytes(b []byte) (Command, error) {
+	if len(b) < goAwayBaseLength {
+		return nil, errInvalidCommand
+	}
+
+	r := new(GoAway)
+	r.RetryAfterSeconds = binary.BigEndian.Uint32(b[0:4])
+	r.Reason = string(b[goAwayBaseLength:])
+	return r, nil
+}
+
+// Throttle is a de-serialized throttle command, sent when the peer exceeds
+// the rate at which it is allowed to send commands.  The sender will not
+// read further commands for RetryAfterMs milliseconds.
+type Throttle struct {
+	RetryAfterMs uint32
+}
+
+// ToBytes serializes the Throttle and returns the resulting slice.
+func (c *Throttle) ToBytes() []byte {
+	out := make([]byte, cmdOverhead+throttleLength)
+	out[0] = byte(throttle)
+	binary.BigEndian.PutUint32(out[2:6], throttleLength)
+	binary.BigEndian.PutUint32(out[6:10], c.RetryAfterMs)
+	return out
+}
+
+func throttleFromBytes(b []byte) (Command, error) {
+	if len(b) != throttleLength {
+		return nil, errInvalidCommand
+	}
+
+	r := new(Throttle)
+	r.RetryAfterMs = binary.BigEndian.Uint32(b[0:4])
+	return r, nil
+}
+
 // Disconnect is a de-serialized disconnect command.
 type Disconnect struct{}
 
@@ -669,6 +768,12 @@ func FromBytes(b []byte) (Command, error) {
 		return revealFromBytes(b)
 	case revealStatus:
 		return revealStatusFromBytes(b)
+	case relayAck:
+		return relayAckFromBytes(b)
+	case goAway:
+		return goAwayFromBytes(b)
+	case throttle:
+		return throttleFromBytes(b)
 	default:
 		return nil, errInvalidCommand
 	}
@@ -18,6 +18,9 @@ package commands
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 
 	"github.com/katzenpost/core/constants"
@@ -327,3 +330,83 @@ func TestRevealtatus(t *testing.T) {
 	d := c.(*RevealStatus)
 	require.Equal(d.ErrorCode, cmd.ErrorCode)
 }
+
+func TestRelayAck(t *testing.T) {
+	require := require.New(t)
+
+	cmd := &RelayAck{
+		Accepted:   0xdeadbabe,
+		Rejected:   23,
+		QueueDepth: 1024,
+	}
+	b := cmd.ToBytes()
+	require.Len(b, relayAckLength+cmdOverhead, "RelayAck: ToBytes() length")
+
+	c, err := FromBytes(b)
+	require.NoError(err, "RelayAck: FromBytes() failed")
+	require.IsType(cmd, c, "RelayAck: FromBytes() invalid type")
+	d := c.(*RelayAck)
+	require.Equal(cmd, d)
+}
+
+func TestGoAway(t *testing.T) {
+	require := require.New(t)
+
+	cmd := &GoAway{
+		Reason:            "server shutting down",
+		RetryAfterSeconds: 30,
+	}
+	b := cmd.ToBytes()
+	require.Len(b, cmdOverhead+goAwayBaseLength+len(cmd.Reason), "GoAway: ToBytes() length")
+
+	c, err := FromBytes(b)
+	require.NoError(err, "GoAway: FromBytes() failed")
+	require.IsType(cmd, c, "GoAway: FromBytes() invalid type")
+	require.Equal(cmd, c.(*GoAway))
+
+	// The reason is optional.
+	cmd.Reason = ""
+	c, err = FromBytes(cmd.ToBytes())
+	require.NoError(err, "GoAway: FromBytes() no reason")
+	require.Equal(cmd, c.(*GoAway))
+}
+
+func TestThrottle(t *testing.T) {
+	require := require.New(t)
+
+	cmd := &Throttle{RetryAfterMs: 250}
+	b := cmd.ToBytes()
+	require.Len(b, cmdOverhead+throttleLength, "Throttle: ToBytes() length")
+
+	c, err := FromBytes(b)
+	require.NoError(err, "Throttle: FromBytes() failed")
+	require.IsType(cmd, c, "Throttle: FromBytes() invalid type")
+	require.Equal(cmd, c.(*Throttle))
+}
+
+func TestWireError(t *testing.T) {
+	require := require.New(t)
+
+	// Malformed commands are protocol violations.
+	_, err := FromBytes([]byte{byte(getConsensus), 0, 0, 0, 0, 1, 0})
+	require.Error(err, "FromBytes(malformed)")
+	require.True(IsWireError(err, ErrCodeProtocolViolation), "IsWireError(ErrCodeProtocolViolation)")
+	require.False(IsWireError(err, ErrCodeTimeout), "IsWireError(ErrCodeTimeout)")
+	require.False(IsWireError(io.EOF, ErrCodeProtocolViolation), "IsWireError(io.EOF)")
+	require.False(IsWireError(nil, ErrCodeProtocolViolation), "IsWireError(nil)")
+
+	// errors.Is and errors.As see through the wrapped chain.
+	wireErr := &WireError{Code: ErrCodeTimeout, Op: "RecvCommand", Wrapped: io.ErrUnexpectedEOF}
+	wrapped := fmt.Errorf("outer: %w", wireErr)
+	require.Equal("wire: RecvCommand: timeout: unexpected EOF", wireErr.Error())
+	require.True(errors.Is(wrapped, io.ErrUnexpectedEOF), "errors.Is(wrapped)")
+	require.True(errors.Is(wrapped, wireErr), "errors.Is(wireErr)")
+	require.True(IsWireError(wrapped, ErrCodeTimeout), "IsWireError(wrapped)")
+
+	var asErr *WireError
+	require.True(errors.As(wrapped, &asErr), "errors.As(wrapped)")
+	require.Equal(wireErr, asErr)
+	require.Equal("RecvCommand", asErr.Op)
+
+	require.Equal("wire: session: invalid state", (&WireError{Code: ErrCodeInvalidState, Op: "session"}).Error())
+}
@@ -0,0 +1,110 @@
+// errors.go - Wire protocol errors.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WireErrorCode is the class of a WireError.
+type WireErrorCode int
+
+const (
+	// ErrCodeProtocolViolation signifies that the peer sent malformed or
+	// unexpected data.
+	ErrCodeProtocolViolation WireErrorCode = iota + 1
+
+	// ErrCodeAuthFailed signifies that the peer failed to authenticate.
+	ErrCodeAuthFailed
+
+	// ErrCodeTimeout signifies that a network operation timed out.
+	ErrCodeTimeout
+
+	// ErrCodeUnsupportedVersion signifies that the peer speaks an
+	// unsupported protocol version.
+	ErrCodeUnsupportedVersion
+
+	// ErrCodeInvalidState signifies that the operation is not valid in the
+	// current session state.
+	ErrCodeInvalidState
+
+	// ErrCodeInvalidConfig signifies an invalid session configuration.
+	ErrCodeInvalidConfig
+
+	// ErrCodeIO signifies a network I/O failure other than a timeout.
+	ErrCodeIO
+
+	// ErrCodeGoAway signifies that the peer closed the session after
+	// sending a GoAway command, and is not a failure.
+	ErrCodeGoAway
+)
+
+var wireErrorCodeNames = map[WireErrorCode]string{
+	ErrCodeProtocolViolation:  "protocol violation",
+	ErrCodeAuthFailed:         "authentication failed",
+	ErrCodeTimeout:            "timeout",
+	ErrCodeUnsupportedVersion: "unsupported protocol version",
+	ErrCodeInvalidState:       "invalid state",
+	ErrCodeInvalidConfig:      "invalid configuration",
+	ErrCodeIO:                 "i/o failure",
+	ErrCodeGoAway:             "peer went away",
+}
+
+// String returns the human readable description of the error code.
+func (c WireErrorCode) String() string {
+	if s, ok := wireErrorCodeNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown error code %d", int(c))
+}
+
+// WireError is the error returned by the wire protocol implementation.
+type WireError struct {
+	// Code is the class of the error.
+	Code WireErrorCode
+
+	// Op is the operation that failed.
+	Op string
+
+	// Wrapped is the underlying error, if any.
+	Wrapped error
+}
+
+// Error implements the error interface.
+func (e *WireError) Error() string {
+	s := "wire: " + e.Op + ": " + e.Code.String()
+	if e.Wrapped != nil {
+		s += ": " + e.Wrapped.Error()
+	}
+	return s
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *WireError) Unwrap() error {
+	return e.Wrapped
+}
+
+// IsWireError returns true iff err is or wraps a WireError with the given
+// code.
+func IsWireError(err error, code WireErrorCode) bool {
+	var wireErr *WireError
+	if !errors.As(err, &wireErr) {
+		return false
+	}
+	return wireErr.Code == code
+}
@@ -0,0 +1,50 @@
+// dial.go - Wire protocol client session establishment.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialSession establishes a new client session, configured with cfg, with
+// the peer at the TCP address addr using dialer.  The handshake is bounded
+// by the context's deadline, if any.  On failure the session is closed, so
+// that none of its state outlives the attempt.
+func DialSession(ctx context.Context, dialer *net.Dialer, addr string, cfg *SessionConfig) (*Session, error) {
+	s, err := NewSession(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err = s.Initialize(conn); err != nil {
+		s.Close()
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return s, nil
+}
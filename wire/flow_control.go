@@ -0,0 +1,218 @@
+// flow_control.go - Wire protocol session flow control.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/wire/commands"
+)
+
+// flowControl is a sliding window over the SendPacket commands that have
+// been sent, but not yet acknowledged by a RelayAck from the peer.
+type flowControl struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	enabled    bool
+	closed     bool
+	windowSize int
+	inFlight   int
+	queueDepth uint32
+}
+
+// window returns the current window size, which is halved while the peer
+// reports a relay queue deeper than the configured window size.
+func (f *flowControl) window() int {
+	if f.queueDepth > uint32(f.windowSize) {
+		if w := f.windowSize / 2; w > 0 {
+			return w
+		}
+		return 1
+	}
+	return f.windowSize
+}
+
+func (f *flowControl) setEnabled(enabled bool, windowSize int) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.enabled = enabled
+	f.windowSize = windowSize
+	f.inFlight = 0
+	f.queueDepth = 0
+	f.cond.Broadcast()
+}
+
+// acquire blocks until there is room in the window for another packet, and
+// returns false iff the session was closed while waiting.
+func (f *flowControl) acquire() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	for f.enabled && !f.closed && f.inFlight >= f.window() {
+		f.cond.Wait()
+	}
+	if f.closed {
+		return false
+	}
+	if f.enabled {
+		f.inFlight++
+	}
+	return true
+}
+
+// release returns a slot acquired for a packet that was not sent.
+func (f *flowControl) release() {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.enabled && f.inFlight > 0 {
+		f.inFlight--
+		f.cond.Broadcast()
+	}
+}
+
+func (f *flowControl) onRelayAck(ack *commands.RelayAck) {
+	f.Lock()
+	defer f.Unlock()
+
+	if !f.enabled {
+		return
+	}
+	f.inFlight -= int(ack.Accepted) + int(ack.Rejected)
+	if f.inFlight < 0 {
+		f.inFlight = 0
+	}
+	f.queueDepth = ack.QueueDepth
+	f.cond.Broadcast()
+}
+
+func (f *flowControl) close() {
+	f.Lock()
+	defer f.Unlock()
+
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+func newFlowControl() *flowControl {
+	f := new(flowControl)
+	f.cond = sync.NewCond(&f.Mutex)
+	return f
+}
+
+// relayAcker accumulates the results of relaying the SendPacket commands
+// received from the peer, until they are reported with a RelayAck.
+type relayAcker struct {
+	sync.Mutex
+
+	everyPackets int
+	stopCh       chan struct{}
+
+	accepted   uint32
+	rejected   uint32
+	queueDepth uint32
+}
+
+// record records the result of relaying a packet, and returns the RelayAck
+// to send if everyPackets results are pending.
+func (a *relayAcker) record(accepted bool, queueDepth uint32) *commands.RelayAck {
+	a.Lock()
+	defer a.Unlock()
+
+	if accepted {
+		a.accepted++
+	} else {
+		a.rejected++
+	}
+	a.queueDepth = queueDepth
+	if a.everyPackets <= 0 || int(a.accepted+a.rejected) < a.everyPackets {
+		return nil
+	}
+	return a.flushLocked()
+}
+
+// flush returns the RelayAck reporting the pending results, if any.
+func (a *relayAcker) flush() *commands.RelayAck {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.accepted+a.rejected == 0 {
+		return nil
+	}
+	return a.flushLocked()
+}
+
+func (a *relayAcker) flushLocked() *commands.RelayAck {
+	ack := &commands.RelayAck{
+		Accepted:   a.accepted,
+		Rejected:   a.rejected,
+		QueueDepth: a.queueDepth,
+	}
+	a.accepted, a.rejected = 0, 0
+	return ack
+}
+
+// SetRelayAckInterval configures when the results reported with
+// ReportRelayed are sent to the peer as a RelayAck: once everyPackets
+// results are pending, and every interval if any results are pending.
+// Either may be <= 0 to disable that trigger.  The peer's flow control
+// window MUST be larger than everyPackets, unless interval is set.  This
+// call MUST only be made by the responder.
+func (s *Session) SetRelayAckInterval(everyPackets int, interval time.Duration) {
+	s.relayAcker.Lock()
+	defer s.relayAcker.Unlock()
+
+	s.relayAcker.everyPackets = everyPackets
+	if s.relayAcker.stopCh != nil {
+		close(s.relayAcker.stopCh)
+		s.relayAcker.stopCh = nil
+	}
+	if interval > 0 {
+		s.relayAcker.stopCh = make(chan struct{})
+		go s.relayAckWorker(interval, s.relayAcker.stopCh)
+	}
+}
+
+// ReportRelayed records that a SendPacket command received from the peer
+// was accepted for relaying, or rejected, and the resulting depth of the
+// relay queue, sending a RelayAck if one is due as per SetRelayAckInterval.
+func (s *Session) ReportRelayed(accepted bool, queueDepth uint32) error {
+	if ack := s.relayAcker.record(accepted, queueDepth); ack != nil {
+		return s.SendCommand(ack)
+	}
+	return nil
+}
+
+func (s *Session) relayAckWorker(interval time.Duration, stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-s.clock.After(interval):
+		case <-stopCh:
+			return
+		case <-s.closeCh:
+			return
+		}
+		if ack := s.relayAcker.flush(); ack != nil {
+			if err := s.SendCommand(ack); err != nil {
+				return
+			}
+		}
+	}
+}
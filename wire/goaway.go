@@ -0,0 +1,59 @@
+// goaway.go - Wire protocol session graceful shutdown.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/wire/commands"
+)
+
+type goAwayState struct {
+	sync.Mutex
+
+	cmd           *commands.GoAway
+	reconnectTime time.Time
+}
+
+func (g *goAwayState) onGoAway(cmd *commands.GoAway) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.cmd = cmd
+	g.reconnectTime = time.Now().Add(time.Duration(cmd.RetryAfterSeconds) * time.Second)
+}
+
+func (g *goAwayState) received() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.cmd != nil
+}
+
+func (g *goAwayState) reconnectDelay() time.Duration {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.cmd == nil {
+		return 0
+	}
+	if d := time.Until(g.reconnectTime); d > 0 {
+		return d
+	}
+	return 0
+}
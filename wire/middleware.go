@@ -0,0 +1,73 @@
+// middleware.go - Wire protocol received command middleware.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"sync"
+
+	"github.com/katzenpost/core/wire/commands"
+)
+
+// CommandHandler processes a received command, returning the command to be
+// returned by RecvCommand.  A handler that returns a nil command and error
+// drops the command.
+type CommandHandler func(cmd commands.Command) (commands.Command, error)
+
+// CommandMiddleware wraps a CommandHandler, to pre or post process the
+// commands passed to it.
+type CommandMiddleware func(next CommandHandler) CommandHandler
+
+func identityHandler(cmd commands.Command) (commands.Command, error) {
+	return cmd, nil
+}
+
+// NewMiddlewareChain returns a CommandHandler that passes each command
+// through the middleware in order, such that the first middleware sees the
+// command first.
+func NewMiddlewareChain(middleware ...CommandMiddleware) CommandHandler {
+	h := CommandHandler(identityHandler)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+type middlewareChain struct {
+	sync.Mutex
+
+	middleware []CommandMiddleware
+	handler    CommandHandler
+}
+
+func (c *middlewareChain) use(m CommandMiddleware) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.middleware = append(c.middleware, m)
+	c.handler = NewMiddlewareChain(c.middleware...)
+}
+
+func (c *middlewareChain) handle(cmd commands.Command) (commands.Command, error) {
+	c.Lock()
+	h := c.handler
+	c.Unlock()
+
+	if h == nil {
+		return cmd, nil
+	}
+	return h(cmd)
+}
@@ -0,0 +1,126 @@
+// pool.go - Wire protocol session pool.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package pool provides a pool of wire protocol sessions to a single peer,
+// amortizing the cost of the handshake over many requests.
+package pool
+
+import (
+	"context"
+	"net"
+
+	"github.com/katzenpost/core/wire"
+)
+
+// SessionPool is a bounded pool of client sessions to a single address.  It
+// is safe for concurrent use.
+type SessionPool struct {
+	addr string
+	cfg  wire.SessionConfig
+
+	// slots holds a token for every open session, idle or acquired, and
+	// idle holds the sessions available for reuse.
+	slots chan struct{}
+	idle  chan *wire.Session
+
+	dialer net.Dialer
+}
+
+// NewSessionPool creates a new SessionPool of at most maxConns sessions to
+// the peer at the TCP address addr, each configured with cfg.
+func NewSessionPool(addr string, maxConns int, cfg wire.SessionConfig) *SessionPool {
+	if maxConns <= 0 {
+		panic("wire/pool: invalid maxConns")
+	}
+	return &SessionPool{
+		addr:  addr,
+		cfg:   cfg,
+		slots: make(chan struct{}, maxConns),
+		idle:  make(chan *wire.Session, maxConns),
+	}
+}
+
+// Acquire returns an idle session that passes its health check, or if there
+// are none, a newly established session.  If the pool is at capacity, it
+// blocks until a session is released or ctx is done.
+func (p *SessionPool) Acquire(ctx context.Context) (*wire.Session, error) {
+	for {
+		// Prefer reusing an idle session over establishing a new one.
+		select {
+		case s := <-p.idle:
+			if p.isHealthy(s) {
+				return s, nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case s := <-p.idle:
+			if p.isHealthy(s) {
+				return s, nil
+			}
+		case p.slots <- struct{}{}:
+			s, err := p.dial(ctx)
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			return s, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release returns the acquired session s to the pool.  Sessions that fail
+// their health check are closed instead.
+func (p *SessionPool) Release(s *wire.Session) {
+	if !p.isHealthy(s) {
+		return
+	}
+	p.idle <- s
+}
+
+// Drain closes all of the idle sessions.
+func (p *SessionPool) Drain() {
+	for {
+		select {
+		case s := <-p.idle:
+			p.discard(s)
+		default:
+			return
+		}
+	}
+}
+
+func (p *SessionPool) isHealthy(s *wire.Session) bool {
+	if s.HealthCheck() != nil {
+		p.discard(s)
+		return false
+	}
+	return true
+}
+
+func (p *SessionPool) discard(s *wire.Session) {
+	s.Close()
+	<-p.slots
+}
+
+func (p *SessionPool) dial(ctx context.Context) (*wire.Session, error) {
+	cfg := p.cfg
+	return wire.DialSession(ctx, &p.dialer, p.addr, &cfg)
+}
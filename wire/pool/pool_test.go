@@ -0,0 +1,168 @@
+// pool_test.go - Wire protocol session pool tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pool
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/wire"
+	"github.com/stretchr/testify/require"
+)
+
+type acceptAllAuthenticator struct{}
+
+func (a *acceptAllAuthenticator) IsPeerValid(*wire.PeerCredentials) bool {
+	return true
+}
+
+// fakeServer accepts wire sessions, counting the handshakes.
+type fakeServer struct {
+	l        net.Listener
+	accepted uint32
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Listen()")
+	authKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(t, err, "NewKeypair()")
+
+	srv := &fakeServer{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				s, err := wire.NewSession(&wire.SessionConfig{
+					Authenticator:     new(acceptAllAuthenticator),
+					AdditionalData:    []byte("server"),
+					AuthenticationKey: authKey,
+					RandomReader:      rand.Reader,
+				}, false)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer s.Close()
+				if s.Initialize(conn) != nil {
+					return
+				}
+				atomic.AddUint32(&srv.accepted, 1)
+				for {
+					if _, err := s.RecvCommand(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeServer) handshakes() uint32 {
+	return atomic.LoadUint32(&srv.accepted)
+}
+
+func newTestPool(t *testing.T, srv *fakeServer, maxConns int) *SessionPool {
+	authKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(t, err, "NewKeypair()")
+	return NewSessionPool(srv.l.Addr().String(), maxConns, wire.SessionConfig{
+		Authenticator:     new(acceptAllAuthenticator),
+		AdditionalData:    []byte("client"),
+		AuthenticationKey: authKey,
+		RandomReader:      rand.Reader,
+	})
+}
+
+func TestSessionPoolReuse(t *testing.T) {
+	require := require.New(t)
+
+	srv := newFakeServer(t)
+	defer srv.l.Close()
+	p := newTestPool(t, srv, 2)
+	defer p.Drain()
+	ctx := context.Background()
+
+	s1, err := p.Acquire(ctx)
+	require.NoError(err, "Acquire()")
+	p.Release(s1)
+	s2, err := p.Acquire(ctx)
+	require.NoError(err, "Acquire(), reuse")
+	require.True(s1 == s2, "session not reused")
+	require.Eventually(func() bool { return srv.handshakes() == 1 }, time.Second, time.Millisecond)
+
+	// A session that fails its health check while idle is replaced.
+	p.Release(s2)
+	s2.Close()
+	s3, err := p.Acquire(ctx)
+	require.NoError(err, "Acquire(), unhealthy")
+	require.False(s2 == s3, "unhealthy session reused")
+	require.Eventually(func() bool { return srv.handshakes() == 2 }, time.Second, time.Millisecond)
+
+	// As is one that fails on release.
+	s3.Close()
+	p.Release(s3)
+	s4, err := p.Acquire(ctx)
+	require.NoError(err, "Acquire(), released unhealthy")
+	require.False(s3 == s4, "unhealthy session reused")
+	p.Release(s4)
+
+	// Draining closes the idle sessions.
+	p.Drain()
+	require.Error(s4.HealthCheck())
+}
+
+func TestSessionPoolMaxConns(t *testing.T) {
+	require := require.New(t)
+
+	srv := newFakeServer(t)
+	defer srv.l.Close()
+	p := newTestPool(t, srv, 2)
+	defer p.Drain()
+
+	s1, err := p.Acquire(context.Background())
+	require.NoError(err, "Acquire() 1")
+	s2, err := p.Acquire(context.Background())
+	require.NoError(err, "Acquire() 2")
+	require.False(s1 == s2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.Acquire(ctx)
+	require.Equal(context.DeadlineExceeded, err, "Acquire() over capacity")
+
+	// Releasing a session unblocks a waiting Acquire.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Release(s1)
+	}()
+	s3, err := p.Acquire(context.Background())
+	require.NoError(err, "Acquire() 3")
+	require.True(s1 == s3)
+	require.Eventually(func() bool { return srv.handshakes() == 2 }, time.Second, time.Millisecond)
+
+	p.Release(s2)
+	p.Release(s3)
+}
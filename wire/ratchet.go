@@ -0,0 +1,291 @@
+// ratchet.go - Wire protocol session Double Ratchet.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/utils"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	ratchetKeyLen    = 32
+	ratchetHeaderLen = ecdh.GroupElementLength + 4 + 4
+	ratchetOverhead  = ratchetHeaderLen + macLen
+)
+
+var (
+	ratchetInitInfo      = []byte("katzenpost-wire-ratchet-v0-init")
+	ratchetBootstrapInfo = []byte("katzenpost-wire-ratchet-v0-bootstrap")
+	ratchetRootInfo      = []byte("katzenpost-wire-ratchet-v0-root")
+
+	errRatchetMessage = errors.New("invalid ratchet message")
+)
+
+// RatchetSnapshot is the serializable state of a session's Double Ratchet.
+type RatchetSnapshot struct {
+	// RootKey is the root chain key.
+	RootKey []byte
+
+	// SendChainKey and RecvChainKey are the sending and receiving chain
+	// keys.
+	SendChainKey []byte
+	RecvChainKey []byte
+
+	// SendRatchetKey is the private key of the local ratchet key pair,
+	// and RecvRatchetKey is the peer's ratchet public key, if known.
+	SendRatchetKey []byte
+	RecvRatchetKey []byte
+
+	// SendCount and RecvCount are the number of messages sent and
+	// received in the current chains, and PrevSendCount is the number of
+	// messages sent in the previous sending chain.
+	SendCount     uint32
+	RecvCount     uint32
+	PrevSendCount uint32
+}
+
+// ratchet is a Double Ratchet, per "The Double Ratchet Algorithm" (Perrin
+// and Marlinspike), layered under the Noise transport encryption.  As the
+// wire protocol is carried over a reliable, ordered transport, there are
+// never skipped messages to account for.
+//
+// The responder's ratchet key is initially its handshake ephemeral key.  So
+// that the responder may send before hearing from the initiator (eg: the
+// NoOp that completes the handshake), both parties derive a bootstrap chain
+// from the shared secret for the responder's initial sending chain.
+type ratchet struct {
+	sync.Mutex
+
+	randReader io.Reader
+	st         ratchetState
+}
+
+type ratchetState struct {
+	rootKey   [ratchetKeyLen]byte
+	sendChain [ratchetKeyLen]byte
+	recvChain [ratchetKeyLen]byte
+
+	dhs *ecdh.PrivateKey
+	dhr *ecdh.PublicKey
+
+	ns, nr, pn uint32
+}
+
+// newRatchet initializes a ratchet from the handshake's ephemeral keys and
+// channel binding.
+func newRatchet(r io.Reader, isInitiator bool, localEphemeral *ecdh.PrivateKey, peerEphemeral *ecdh.PublicKey, channelBinding []byte) (*ratchet, error) {
+	var ee [ecdh.GroupElementLength]byte
+	defer utils.ExplicitBzero(ee[:])
+	localEphemeral.Exp(&ee, peerEphemeral)
+
+	var sk [ratchetKeyLen]byte
+	defer utils.ExplicitBzero(sk[:])
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ee[:], channelBinding, ratchetInitInfo), sk[:]); err != nil {
+		return nil, err
+	}
+
+	rt := &ratchet{randReader: r}
+	st := &rt.st
+	st.rootKey = sk
+	var bootstrap [ratchetKeyLen]byte
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sk[:], nil, ratchetBootstrapInfo), bootstrap[:]); err != nil {
+		return nil, err
+	}
+	if !isInitiator {
+		st.dhs = localEphemeral
+		st.sendChain = bootstrap
+		return rt, nil
+	}
+
+	// The initiator's first sending chain is the result of a DH ratchet
+	// step against the responder's ephemeral key.
+	var err error
+	st.dhr = peerEphemeral
+	st.recvChain = bootstrap
+	if st.dhs, err = ecdh.NewKeypair(r); err != nil {
+		return nil, err
+	}
+	if err = st.kdfRoot(&st.sendChain); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// kdfRoot advances the root chain with DH(dhs, dhr), deriving the new chain
+// key ck.
+func (st *ratchetState) kdfRoot(ck *[ratchetKeyLen]byte) error {
+	var dh [ecdh.GroupElementLength]byte
+	defer utils.ExplicitBzero(dh[:])
+	st.dhs.Exp(&dh, st.dhr)
+
+	var tmp [2 * ratchetKeyLen]byte
+	defer utils.ExplicitBzero(tmp[:])
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dh[:], st.rootKey[:], ratchetRootInfo), tmp[:]); err != nil {
+		return err
+	}
+	copy(st.rootKey[:], tmp[:ratchetKeyLen])
+	copy(ck[:], tmp[ratchetKeyLen:])
+	return nil
+}
+
+// kdfChain advances the chain key ck, and returns the message key.
+func kdfChain(ck *[ratchetKeyLen]byte) []byte {
+	m := hmac.New(sha256.New, ck[:])
+	m.Write([]byte{0x01})
+	mk := m.Sum(nil)
+	m = hmac.New(sha256.New, ck[:])
+	m.Write([]byte{0x02})
+	m.Sum(ck[:0])
+	return mk
+}
+
+func ratchetSeal(mk, header, pt []byte) []byte {
+	defer utils.ExplicitBzero(mk)
+	aead, err := chacha20poly1305.New(mk)
+	if err != nil {
+		panic("wire/session: BUG: invalid ratchet message key: " + err.Error())
+	}
+
+	// Each message key is only ever used once, so the nonce is fixed.
+	var nonce [chacha20poly1305.NonceSize]byte
+	return aead.Seal(header, nonce[:], pt, header)
+}
+
+func ratchetOpen(mk, header, ct []byte) ([]byte, error) {
+	defer utils.ExplicitBzero(mk)
+	aead, err := chacha20poly1305.New(mk)
+	if err != nil {
+		panic("wire/session: BUG: invalid ratchet message key: " + err.Error())
+	}
+	var nonce [chacha20poly1305.NonceSize]byte
+	return aead.Open(nil, nonce[:], ct, header)
+}
+
+// encrypt returns the ratchet message carrying pt.
+func (rt *ratchet) encrypt(pt []byte) []byte {
+	rt.Lock()
+	defer rt.Unlock()
+
+	st := &rt.st
+	header := make([]byte, ratchetHeaderLen, ratchetOverhead+len(pt))
+	copy(header, st.dhs.PublicKey().Bytes())
+	binary.BigEndian.PutUint32(header[ecdh.GroupElementLength:], st.pn)
+	binary.BigEndian.PutUint32(header[ecdh.GroupElementLength+4:], st.ns)
+	st.ns++
+
+	return ratchetSeal(kdfChain(&st.sendChain), header, pt)
+}
+
+// decrypt returns the plaintext of the ratchet message b.
+func (rt *ratchet) decrypt(b []byte) ([]byte, error) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if len(b) < ratchetOverhead {
+		return nil, errRatchetMessage
+	}
+	header, ct := b[:ratchetHeaderLen], b[ratchetHeaderLen:]
+	dh := new(ecdh.PublicKey)
+	if err := dh.FromBytes(header[:ecdh.GroupElementLength]); err != nil {
+		return nil, errRatchetMessage
+	}
+	pn := binary.BigEndian.Uint32(header[ecdh.GroupElementLength:])
+	n := binary.BigEndian.Uint32(header[ecdh.GroupElementLength+4:])
+
+	// Work on a copy of the state, so that it is only updated if the
+	// message is authentic.
+	st := &rt.st
+	next := *st
+	if st.dhr == nil || !dh.Equal(st.dhr) {
+		// The peer has a new ratchet key, do a DH ratchet step.  The
+		// transport is ordered, so every message in the previous
+		// chain has been received.
+		if st.dhr != nil && pn != st.nr {
+			return nil, errRatchetMessage
+		}
+		next.pn, next.ns, next.nr = st.ns, 0, 0
+		next.dhr = dh
+		if err := next.kdfRoot(&next.recvChain); err != nil {
+			return nil, err
+		}
+		var err error
+		if next.dhs, err = ecdh.NewKeypair(rt.randReader); err != nil {
+			return nil, err
+		}
+		if err = next.kdfRoot(&next.sendChain); err != nil {
+			return nil, err
+		}
+	}
+	if n != next.nr {
+		return nil, errRatchetMessage
+	}
+	pt, err := ratchetOpen(kdfChain(&next.recvChain), header, ct)
+	if err != nil {
+		return nil, errRatchetMessage
+	}
+	next.nr++
+
+	if next.dhs != st.dhs {
+		st.dhs.Reset()
+	}
+	*st = next
+	utils.ExplicitBzero(next.rootKey[:])
+	utils.ExplicitBzero(next.sendChain[:])
+	utils.ExplicitBzero(next.recvChain[:])
+	return pt, nil
+}
+
+// snapshot returns the ratchet's state.
+func (rt *ratchet) snapshot() RatchetSnapshot {
+	rt.Lock()
+	defer rt.Unlock()
+
+	st := &rt.st
+	s := RatchetSnapshot{
+		RootKey:        append([]byte{}, st.rootKey[:]...),
+		SendChainKey:   append([]byte{}, st.sendChain[:]...),
+		RecvChainKey:   append([]byte{}, st.recvChain[:]...),
+		SendRatchetKey: append([]byte{}, st.dhs.Bytes()...),
+		SendCount:      st.ns,
+		RecvCount:      st.nr,
+		PrevSendCount:  st.pn,
+	}
+	if st.dhr != nil {
+		s.RecvRatchetKey = st.dhr.Bytes()
+	}
+	return s
+}
+
+func (rt *ratchet) reset() {
+	rt.Lock()
+	defer rt.Unlock()
+
+	st := &rt.st
+	utils.ExplicitBzero(st.rootKey[:])
+	utils.ExplicitBzero(st.sendChain[:])
+	utils.ExplicitBzero(st.recvChain[:])
+	st.dhs.Reset()
+}
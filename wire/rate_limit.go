@@ -0,0 +1,94 @@
+// rate_limit.go - Wire protocol session rate limiting.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the rate at which commands are
+// received from the peer, or bytes are sent to it.
+type rateLimiter struct {
+	sync.Mutex
+
+	clock clock // nil means the system clock.
+
+	limit  float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) set(limit float64, burst int) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.limit = limit
+	l.burst = float64(burst)
+	l.tokens = l.burst
+	l.last = l.now()
+}
+
+func (l *rateLimiter) now() time.Time {
+	if l.clock == nil {
+		return time.Now()
+	}
+	return l.clock.Now()
+}
+
+// reserve takes a token from the bucket, and returns how long the caller
+// must wait before the token may be used, and how long until the bucket is
+// full again.
+func (l *rateLimiter) reserve() (time.Duration, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	d := l.reserveLocked(1)
+	if l.limit <= 0 {
+		return d, 0
+	}
+	return d, time.Duration((l.burst - l.tokens) / l.limit * float64(time.Second))
+}
+
+// reserveN takes n tokens from the bucket, and returns how long the caller
+// must wait before the tokens may be used.
+func (l *rateLimiter) reserveN(n float64) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.reserveLocked(n)
+}
+
+func (l *rateLimiter) reserveLocked(n float64) time.Duration {
+	if l.limit <= 0 {
+		return 0
+	}
+
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limit
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.limit * float64(time.Second))
+}
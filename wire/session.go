@@ -28,8 +28,10 @@ import (
 	"time"
 
 	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/eddsa"
 	"github.com/katzenpost/core/wire/commands"
 	"github.com/katzenpost/noise"
+	"gopkg.in/op/go-logging.v1"
 )
 
 const (
@@ -42,6 +44,12 @@ const (
 	authLen   = 1 + MaxAdditionalDataLength + 4
 )
 
+// featureThrottle is the optional feature flag advertised by initiators that
+// understand the Throttle command.  The initiator's authenticate message has
+// no use for a timestamp, so it carries the flags in its place, which
+// responders that predate feature negotiation ignore.
+const featureThrottle uint32 = 1 << 0
+
 const (
 	stateInit        uint32 = 0
 	stateEstablished uint32 = 1
@@ -49,11 +57,37 @@ const (
 )
 
 var (
-	errInvalidState         = errors.New("wire/session: invalid state")
-	errAuthenticationFailed = errors.New("wire/session: authentication failed")
-	errMsgSize              = errors.New("wire/session: invalid message size")
+	// ErrKeyMismatch is the error returned when the peer's static key does
+	// not match any of the keys pinned with PinRemoteKey.
+	ErrKeyMismatch = &commands.WireError{Code: commands.ErrCodeAuthFailed, Op: "handshake", Wrapped: errors.New("peer key is not pinned")}
+
+	errInvalidState         = &commands.WireError{Code: commands.ErrCodeInvalidState, Op: "session"}
+	errAuthenticationFailed = &commands.WireError{Code: commands.ErrCodeAuthFailed, Op: "handshake"}
+	errMsgSize              = protocolError("session", errors.New("invalid message size"))
 )
 
+// ioError wraps the network I/O error err in a WireError.
+func ioError(op string, err error) error {
+	code := commands.ErrCodeIO
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		code = commands.ErrCodeTimeout
+	}
+	return &commands.WireError{Code: code, Op: op, Wrapped: err}
+}
+
+// protocolError wraps err, which signifies a violation of the protocol, in
+// a WireError.
+func protocolError(op string, err error) error {
+	return &commands.WireError{Code: commands.ErrCodeProtocolViolation, Op: op, Wrapped: err}
+}
+
+// configError wraps err, which signifies an invalid configuration, in a
+// WireError.
+func configError(op string, err error) error {
+	return &commands.WireError{Code: commands.ErrCodeInvalidConfig, Op: op, Wrapped: err}
+}
+
 type authenticateMessage struct {
 	ad       []byte
 	unixTime uint32
@@ -111,7 +145,7 @@ type PeerAuthenticator interface {
 // SessionInterface is the interface used to initialize or teardown a Session
 // and send and receive command.Commands.
 type SessionInterface interface {
-	Initialize(conn net.Conn) error
+	Initialize(conn Transport) error
 	SendCommand(cmd commands.Command) error
 	RecvCommand() (commands.Command, error)
 	Close()
@@ -121,10 +155,11 @@ type SessionInterface interface {
 
 // Session is a wire protocol session.
 type Session struct {
-	conn net.Conn
+	conn Transport
 
 	peerCredentials *PeerCredentials
 	authenticator   PeerAuthenticator
+	pinnedKeys      []*ecdh.PublicKey
 
 	additionalData    []byte
 	authenticationKey *ecdh.PrivateKey
@@ -136,9 +171,32 @@ type Session struct {
 	rxKeyMutex *sync.RWMutex
 	txKeyMutex *sync.RWMutex
 
-	clockSkew   time.Duration
-	state       uint32
-	isInitiator bool
+	flowControl *flowControl
+	relayAcker  *relayAcker
+	commandLog  *commandLog
+	stats       *sessionStats
+	middleware  *middlewareChain
+	ratchet     *ratchet
+	goAway      *goAwayState
+	rateLimiter *rateLimiter
+	log         *logging.Logger
+
+	clock            clock
+	bandwidthLimiter *rateLimiter
+
+	sendLock sync.Mutex
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	features       uint32
+	peerFeatures   uint32
+	throttledUntil time.Time
+
+	clockSkew      time.Duration
+	state          uint32
+	isInitiator    bool
+	ratchetEnabled bool
 }
 
 func (s *Session) handshake() error {
@@ -166,7 +224,7 @@ func (s *Session) handshake() error {
 		MaxMsgLen:     maxMsgLen,
 	})
 	if err != nil {
-		return err
+		return configError("handshake", err)
 	}
 	const (
 		prologueLen = 1
@@ -183,29 +241,32 @@ func (s *Session) handshake() error {
 		msg1 = append(msg1, prologue...)
 		msg1, _, _, err = hs.WriteMessage(msg1, nil)
 		if err != nil {
-			return err
+			return protocolError("handshake", err)
 		}
 		if _, err = s.conn.Write(msg1); err != nil {
-			return err
+			return ioError("handshake", err)
 		}
 
 		// <- e, ee, ekem1, s, es, (auth)
 		msg2 := make([]byte, msg2Len)
 		if _, err = io.ReadFull(s.conn, msg2); err != nil {
-			return err
+			return ioError("handshake", err)
 		}
 		now := time.Now()
 		rawAuth := make([]byte, 0, authLen)
 		rawAuth, _, _, err = hs.ReadMessage(rawAuth, msg2)
 		if err != nil {
-			return err
+			return protocolError("handshake", err)
 		}
 		peerAuth := authenticateMessageFromBytes(rawAuth)
 
 		// Authenticate the peer.
 		peerAuthenticationKey := new(ecdh.PublicKey)
 		if err = peerAuthenticationKey.FromBytes(hs.PeerStatic()); err != nil {
-			return err
+			return protocolError("handshake", err)
+		}
+		if !s.isPeerKeyPinned(peerAuthenticationKey) {
+			return ErrKeyMismatch
 		}
 		s.peerCredentials = &PeerCredentials{
 			AdditionalData: peerAuth.ad,
@@ -220,29 +281,32 @@ func (s *Session) handshake() error {
 		s.clockSkew = now.Sub(peerClock)
 
 		// -> s, se, (auth)
-		ourAuth := &authenticateMessage{ad: s.additionalData}
+		ourAuth := &authenticateMessage{
+			ad:       s.additionalData,
+			unixTime: s.features,
+		}
 		rawAuth = make([]byte, 0, authLen)
 		rawAuth = ourAuth.ToBytes(rawAuth)
 		msg3 := make([]byte, 0, msg3Len)
 		msg3, s.tx, s.rx, err = hs.WriteMessage(msg3, rawAuth)
 		if err != nil {
-			return err
+			return protocolError("handshake", err)
 		}
 		if _, err = s.conn.Write(msg3); err != nil {
-			return err
+			return ioError("handshake", err)
 		}
 	} else {
 		// -> (prologue), e, e1
 		msg1 := make([]byte, msg1Len)
 		if _, err = io.ReadFull(s.conn, msg1); err != nil {
-			return err
+			return ioError("handshake", err)
 		}
 		if subtle.ConstantTimeCompare(prologue, msg1[0:1]) != 1 {
-			return errors.New("wire/session: unsupported protocol version")
+			return &commands.WireError{Code: commands.ErrCodeUnsupportedVersion, Op: "handshake"}
 		}
 		msg1 = msg1[1:]
 		if _, _, _, err = hs.ReadMessage(nil, msg1); err != nil {
-			return err
+			return protocolError("handshake", err)
 		}
 
 		// <- e, ee, ekem1, s, es, (auth)
@@ -255,28 +319,31 @@ func (s *Session) handshake() error {
 		msg2 := make([]byte, 0, msg2Len)
 		msg2, _, _, err = hs.WriteMessage(msg2, rawAuth)
 		if err != nil {
-			return err
+			return protocolError("handshake", err)
 		}
 		if _, err = s.conn.Write(msg2); err != nil {
-			return err
+			return ioError("handshake", err)
 		}
 
 		// -> s, se, (auth)
 		msg3 := make([]byte, msg3Len)
 		rawAuth = make([]byte, 0, authLen)
 		if _, err = io.ReadFull(s.conn, msg3); err != nil {
-			return err
+			return ioError("handshake", err)
 		}
 		rawAuth, s.rx, s.tx, err = hs.ReadMessage(rawAuth, msg3)
 		if err != nil {
-			return err
+			return protocolError("handshake", err)
 		}
 		peerAuth := authenticateMessageFromBytes(rawAuth)
 
 		// Authenticate the peer.
 		peerAuthenticationKey := new(ecdh.PublicKey)
 		if err = peerAuthenticationKey.FromBytes(hs.PeerStatic()); err != nil {
-			return err
+			return protocolError("handshake", err)
+		}
+		if !s.isPeerKeyPinned(peerAuthenticationKey) {
+			return ErrKeyMismatch
 		}
 		s.peerCredentials = &PeerCredentials{
 			AdditionalData: peerAuth.ad,
@@ -285,23 +352,58 @@ func (s *Session) handshake() error {
 		if !s.authenticator.IsPeerValid(s.peerCredentials) {
 			return errAuthenticationFailed
 		}
+		s.peerFeatures = peerAuth.unixTime
+	}
+
+	if s.ratchetEnabled {
+		if err = s.initRatchet(hs); err != nil {
+			return protocolError("handshake", err)
+		}
 	}
 
 	atomic.StoreUint32(&s.state, stateEstablished)
 	return nil
 }
 
+func (s *Session) initRatchet(hs *noise.HandshakeState) error {
+	localEphemeral := new(ecdh.PrivateKey)
+	if err := localEphemeral.FromBytes(hs.LocalEphemeral().Private); err != nil {
+		return err
+	}
+	peerEphemeral := new(ecdh.PublicKey)
+	if err := peerEphemeral.FromBytes(hs.PeerEphemeral()); err != nil {
+		return err
+	}
+	var err error
+	s.ratchet, err = newRatchet(s.randReader, s.isInitiator, localEphemeral, peerEphemeral, hs.ChannelBinding())
+	return err
+}
+
+// isPeerKeyPinned returns true iff the peer's static key matches one of the
+// pinned keys, or if no keys are pinned.
+func (s *Session) isPeerKeyPinned(k *ecdh.PublicKey) bool {
+	if len(s.pinnedKeys) == 0 {
+		return true
+	}
+	for _, pinned := range s.pinnedKeys {
+		if pinned.Equal(k) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Session) finalizeHandshake() error {
 	if s.isInitiator {
 		// Initiator: The peer will send a NoOp command immediately upon
 		// completing the handshake.
-		cmd, err := s.RecvCommand()
+		cmd, err := s.recvCommand()
 		if err != nil {
 			return err
 		}
 		if _, ok := cmd.(*commands.NoOp); !ok {
 			// Protocol violation, the peer sent something other than a NoOp.
-			return errInvalidState
+			return protocolError("handshake", errors.New("unexpected command"))
 		}
 		return nil
 	}
@@ -312,9 +414,9 @@ func (s *Session) finalizeHandshake() error {
 	return s.SendCommand(noOpCmd)
 }
 
-// Initialize takes an establised net.Conn, and binds it to a Session, and
-// conducts the wire protocol handshake.
-func (s *Session) Initialize(conn net.Conn) error {
+// Initialize takes an establised Transport (eg: a net.Conn), and binds it to
+// a Session, and conducts the wire protocol handshake.
+func (s *Session) Initialize(conn Transport) error {
 	if atomic.LoadUint32(&s.state) != stateInit {
 		return errInvalidState
 	}
@@ -330,6 +432,66 @@ func (s *Session) Initialize(conn net.Conn) error {
 	return nil
 }
 
+// PinRemoteKey pins the peer's identity key, such that the handshake fails
+// with ErrKeyMismatch unless the peer's static key is the X25519 form of one
+// of the pinned keys.  This call MUST be made prior to Initialize.
+func (s *Session) PinRemoteKey(key *eddsa.PublicKey) {
+	s.pinnedKeys = append(s.pinnedKeys, key.ToECDH())
+}
+
+// PinRemoteKeys pins each of the keys, as with PinRemoteKey.
+func (s *Session) PinRemoteKeys(keys []*eddsa.PublicKey) {
+	for _, key := range keys {
+		s.PinRemoteKey(key)
+	}
+}
+
+// SetFlowControlEnabled enables or disables flow control of the SendPacket
+// commands sent over the session.  When enabled, SendCommand blocks while
+// windowSize SendPacket commands are awaiting acknowledgment via RelayAck
+// commands received from the peer, and the window is halved while the peer
+// reports a relay queue deeper than windowSize.  RelayAck commands are only
+// processed when received with RecvCommand, which must be called
+// concurrently with a blocking SendCommand.  The responder sends RelayAck
+// commands as per SetRelayAckInterval.
+func (s *Session) SetFlowControlEnabled(enabled bool, windowSize int) {
+	if enabled && windowSize <= 0 {
+		panic("wire/session: invalid flow control window size")
+	}
+	s.flowControl.setEnabled(enabled, windowSize)
+}
+
+// SetRateLimit limits the rate at which commands are received from the peer
+// to limit commands per second, with bursts of up to burst commands.  When
+// the peer exceeds the limit, RecvCommand pauses until the command that
+// exceeded the limit is allowed, rather than failing, and if the peer
+// advertised support for it during the handshake, sends it a Throttle command
+// at most once until the limit has fully recovered.  Only initiators advertise
+// support, so only responders ever send Throttle.  Close interrupts the pause.
+// A limit of zero disables rate limiting.
+func (s *Session) SetRateLimit(limit float64, burst int) {
+	if limit > 0 && burst <= 0 {
+		panic("wire/session: invalid rate limit burst")
+	}
+	s.rateLimiter.set(limit, burst)
+}
+
+// EnableCommandLog enables logging every command sent and received on the
+// session to the file at path as newline delimited JSON CommandLogRecords,
+// replacing any previously enabled log.  The command payloads themselves
+// are never logged.
+func (s *Session) EnableCommandLog(path string) error {
+	if err := s.commandLog.enable(path); err != nil {
+		return &commands.WireError{Code: commands.ErrCodeIO, Op: "EnableCommandLog", Wrapped: err}
+	}
+	return nil
+}
+
+// DisableCommandLog flushes and closes the command log, if any.
+func (s *Session) DisableCommandLog() {
+	s.commandLog.disable()
+}
+
 // SendCommand sends the wire protocol command cmd.
 func (s *Session) SendCommand(cmd commands.Command) error {
 	if atomic.LoadUint32(&s.state) != stateEstablished {
@@ -341,12 +503,40 @@ func (s *Session) SendCommand(cmd commands.Command) error {
 	// command's various responses all have identical sizes.
 
 	// Derive the Ciphertext length.
-	pt := cmd.ToBytes()
-	ctLen := macLen + len(pt)
+	cmdBytes := cmd.ToBytes()
+	ctLen := macLen + len(cmdBytes)
+	if s.ratchet != nil {
+		ctLen += ratchetOverhead
+	}
 	if ctLen > maxMsgLen {
 		return errMsgSize
 	}
 
+	if _, ok := cmd.(*commands.SendPacket); ok {
+		if !s.flowControl.acquire() {
+			return errInvalidState
+		}
+	}
+
+	// Wait for the bandwidth limit before serializing with other senders,
+	// so that a rate limited command doesn't hold them up.
+	if err := s.waitBandwidth(macLen + 4 + ctLen); err != nil {
+		if _, ok := cmd.(*commands.SendPacket); ok {
+			s.flowControl.release()
+		}
+		return err
+	}
+
+	// Commands may be sent by RecvCommand (eg: Throttle), so serialize the
+	// encryption and transmission of each command.
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	pt := cmdBytes
+	if s.ratchet != nil {
+		pt = s.ratchet.encrypt(pt)
+	}
+
 	// Build the CiphertextHeader.
 	var ctHdr [4]byte
 	binary.BigEndian.PutUint32(ctHdr[:], uint32(ctLen))
@@ -367,18 +557,110 @@ func (s *Session) SendCommand(cmd commands.Command) error {
 	if err != nil {
 		// All write errors are fatal.
 		atomic.StoreUint32(&s.state, stateInvalid)
+		s.flowControl.close()
+		return ioError("SendCommand", err)
 	}
-	return err
+	s.commandLog.record(CommandLogDirectionSend, cmd, cmdBytes)
+	s.stats.record(cmd, len(cmdBytes), len(toSend), true)
+	return nil
+}
+
+// SendGoAway sends a GoAway command informing the peer that the session is
+// about to be closed, and that it should wait retryAfter (rounded up to the
+// nearest second) before reconnecting.  The caller is responsible for
+// closing the session afterwards.  This call MUST only be made by the
+// responder.
+func (s *Session) SendGoAway(reason string, retryAfter time.Duration) error {
+	if s.isInitiator {
+		return &commands.WireError{Code: commands.ErrCodeInvalidState, Op: "SendGoAway"}
+	}
+	retryAfterSeconds := (retryAfter + time.Second - 1) / time.Second
+	return s.SendCommand(&commands.GoAway{
+		Reason:            reason,
+		RetryAfterSeconds: uint32(retryAfterSeconds),
+	})
+}
+
+// ReconnectDelay returns how long to wait before reconnecting to the peer,
+// as requested by a GoAway command received with RecvCommand, or zero if
+// none was received.  Once the peer has sent a GoAway, RecvCommand returns
+// an error with the code commands.ErrCodeGoAway rather than
+// commands.ErrCodeIO when the peer closes the connection.
+func (s *Session) ReconnectDelay() time.Duration {
+	return s.goAway.reconnectDelay()
 }
 
 // RecvCommand receives a wire protocol command off the network.
 func (s *Session) RecvCommand() (commands.Command, error) {
+	for {
+		cmd, err := s.recvCommand()
+		if err != nil {
+			return nil, err
+		}
+		if cmd, err = s.middleware.handle(cmd); cmd != nil || err != nil {
+			return cmd, err
+		}
+	}
+}
+
+// UseMiddleware appends m to the middleware that every command returned by
+// RecvCommand is passed through, after the session's own processing of the
+// command.  Errors returned by middleware are returned by RecvCommand, and
+// unlike other receive errors, are not fatal to the session.
+func (s *Session) UseMiddleware(m CommandMiddleware) {
+	s.middleware.use(m)
+}
+
+func (s *Session) recvCommand() (commands.Command, error) {
 	cmd, err := s.recvCommandImpl()
 	if err != nil {
 		// All receive errors are fatal.
 		atomic.StoreUint32(&s.state, stateInvalid)
+		s.flowControl.close()
+
+		// The peer closing the connection after sending a GoAway is a
+		// graceful shutdown.
+		var wireErr *commands.WireError
+		if s.goAway.received() && errors.As(err, &wireErr) && wireErr.Code == commands.ErrCodeIO {
+			return nil, &commands.WireError{Code: commands.ErrCodeGoAway, Op: wireErr.Op, Wrapped: wireErr.Wrapped}
+		}
+		return nil, err
+	}
+	if d, period := s.rateLimiter.reserve(); d > 0 {
+		// The peer is sending commands too fast, tell it to back off (once
+		// per throttled period) and stop reading until it is allowed to send
+		// again.
+		if now := time.Now(); s.peerFeatures&featureThrottle != 0 && !now.Before(s.throttledUntil) {
+			s.throttledUntil = now.Add(period)
+			retryAfterMs := (period + time.Millisecond - 1) / time.Millisecond
+			if err := s.SendCommand(&commands.Throttle{RetryAfterMs: uint32(retryAfterMs)}); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.sleep(d); err != nil {
+			return nil, err
+		}
+	}
+	switch c := cmd.(type) {
+	case *commands.RelayAck:
+		s.flowControl.onRelayAck(c)
+	case *commands.GoAway:
+		s.goAway.onGoAway(c)
+		if s.log != nil {
+			s.log.Noticef("Peer going away: '%v', retry after %v seconds.", c.Reason, c.RetryAfterSeconds)
+		}
+	}
+	return cmd, nil
+}
+
+// sleep pauses for d, returning early if the session is closed.
+func (s *Session) sleep(d time.Duration) error {
+	select {
+	case <-s.clock.After(d):
+		return nil
+	case <-s.closeCh:
+		return errInvalidState
 	}
-	return cmd, err
 }
 
 func (s *Session) recvCommandImpl() (commands.Command, error) {
@@ -389,13 +671,13 @@ func (s *Session) recvCommandImpl() (commands.Command, error) {
 	// Read, decrypt and parse the CiphertextHeader.
 	var ctHdrCt [macLen + 4]byte
 	if _, err := io.ReadFull(s.conn, ctHdrCt[:]); err != nil {
-		return nil, err
+		return nil, ioError("RecvCommand", err)
 	}
 	s.rxKeyMutex.RLock()
 	ctHdr, err := s.rx.Decrypt(nil, nil, ctHdrCt[:])
 	s.rxKeyMutex.RUnlock()
 	if err != nil {
-		return nil, err
+		return nil, protocolError("RecvCommand", err)
 	}
 	ctLen := binary.BigEndian.Uint32(ctHdr[:])
 	if ctLen < macLen || ctLen > maxMsgLen {
@@ -405,20 +687,31 @@ func (s *Session) recvCommandImpl() (commands.Command, error) {
 	// Read and decrypt the Ciphertext.
 	ct := make([]byte, ctLen)
 	if _, err := io.ReadFull(s.conn, ct); err != nil {
-		return nil, err
+		return nil, ioError("RecvCommand", err)
 	}
 	s.rxKeyMutex.RLock()
 	pt, err := s.rx.Decrypt(nil, nil, ct)
 	s.rxKeyMutex.RUnlock()
 	if err != nil {
-		return nil, err
+		return nil, protocolError("RecvCommand", err)
 	}
 	s.rxKeyMutex.Lock()
 	s.rx.Rekey()
 	s.rxKeyMutex.Unlock()
+	if s.ratchet != nil {
+		if pt, err = s.ratchet.decrypt(pt); err != nil {
+			return nil, protocolError("RecvCommand", err)
+		}
+	}
 
 	// Parse and return the command.
-	return commands.FromBytes(pt)
+	cmd, err := commands.FromBytes(pt)
+	if err != nil {
+		return nil, err
+	}
+	s.commandLog.record(CommandLogDirectionRecv, cmd, pt)
+	s.stats.record(cmd, len(pt), len(ctHdrCt)+len(ct), false)
+	return cmd, nil
 }
 
 // Close terminates a session.
@@ -437,17 +730,55 @@ func (s *Session) Close() {
 		s.rxKeyMutex.Unlock()
 	}
 	s.authenticationKey.Reset()
+	if s.ratchet != nil {
+		s.ratchet.reset()
+	}
 	if s.conn != nil {
 		s.conn.Close()
 	}
 	atomic.StoreUint32(&s.state, stateInvalid)
+	s.closeOnce.Do(func() { close(s.closeCh) })
+	s.flowControl.close()
+	s.commandLog.disable()
+}
+
+// EnableRatchet enables the Double Ratchet, which layers an additional
+// encryption under the Noise transport encryption, deriving a new key for
+// every message and a new chain every time the direction of traffic
+// changes.  Both peers MUST enable the ratchet, and this call MUST be made
+// prior to Initialize.
+func (s *Session) EnableRatchet() {
+	s.ratchetEnabled = true
+}
+
+// RatchetState returns a snapshot of the session's Double Ratchet state, or
+// the zero RatchetSnapshot if the ratchet is not enabled.  The snapshot
+// contains secret key material.
+func (s *Session) RatchetState() RatchetSnapshot {
+	if s.ratchet == nil {
+		return RatchetSnapshot{}
+	}
+	return s.ratchet.snapshot()
+}
+
+// HealthCheck returns nil iff the session is established and usable.
+func (s *Session) HealthCheck() error {
+	if atomic.LoadUint32(&s.state) != stateEstablished {
+		return errInvalidState
+	}
+	return nil
+}
+
+// SessionStats returns a snapshot of the session's statistics.
+func (s *Session) SessionStats() Stats {
+	return s.stats.snapshot()
 }
 
 // PeerCredentials returns the peer's credentials.  This call MUST only be
 // called from a session that has successfully completed Initialize().
 func (s *Session) PeerCredentials() (*PeerCredentials, error) {
 	if atomic.LoadUint32(&s.state) != stateEstablished {
-		return nil, errors.New("wire/session: PeerCredentials() call in invalid state")
+		return nil, errInvalidState
 	}
 	return s.peerCredentials, nil
 }
@@ -469,16 +800,16 @@ func (s *Session) ClockSkew() time.Duration {
 // NewSession creates a new Session.
 func NewSession(cfg *SessionConfig, isInitiator bool) (*Session, error) {
 	if cfg.Authenticator == nil {
-		return nil, errors.New("wire/session: missing Authenticator")
+		return nil, configError("NewSession", errors.New("missing Authenticator"))
 	}
 	if len(cfg.AdditionalData) > MaxAdditionalDataLength {
-		return nil, errors.New("wire/session: oversized AdditionalData")
+		return nil, configError("NewSession", errors.New("oversized AdditionalData"))
 	}
 	if cfg.AuthenticationKey == nil {
-		return nil, errors.New("wire/session: missing AuthenticationKey")
+		return nil, configError("NewSession", errors.New("missing AuthenticationKey"))
 	}
 	if cfg.RandomReader == nil {
-		return nil, errors.New("wire/session: missing RandomReader")
+		return nil, configError("NewSession", errors.New("missing RandomReader"))
 	}
 
 	s := &Session{
@@ -490,6 +821,18 @@ func NewSession(cfg *SessionConfig, isInitiator bool) (*Session, error) {
 		state:             stateInit,
 		rxKeyMutex:        new(sync.RWMutex),
 		txKeyMutex:        new(sync.RWMutex),
+		flowControl:       newFlowControl(),
+		relayAcker:        new(relayAcker),
+		commandLog:        new(commandLog),
+		stats:             new(sessionStats),
+		middleware:        new(middlewareChain),
+		goAway:            new(goAwayState),
+		rateLimiter:       new(rateLimiter),
+		clock:             systemClock{},
+		bandwidthLimiter:  new(rateLimiter),
+		closeCh:           make(chan struct{}),
+		features:          featureThrottle,
+		log:               cfg.Log,
 	}
 	if err := s.authenticationKey.FromBytes(cfg.AuthenticationKey.Bytes()); err != nil {
 		panic("wire/session: BUG: failed to copy authentication key: " + err.Error())
@@ -515,4 +858,8 @@ type SessionConfig struct {
 
 	// RandomReader is a cryptographic entropy source.
 	RandomReader io.Reader
+
+	// Log is the optional logger used to log session events (eg: the peer
+	// going away).
+	Log *logging.Logger
 }
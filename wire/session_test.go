@@ -17,13 +17,26 @@
 package wire
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/eddsa"
 	"github.com/katzenpost/core/wire/commands"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -152,3 +165,855 @@ func TestSessionIntegration(t *testing.T) {
 
 	wg.Wait()
 }
+
+// newTestSessionPair returns an initialized client (initiator) and server
+// (responder) Session pair connected over the loopback interface.
+func newTestSessionPair(t *testing.T) (*Session, *Session) {
+	return newTestSessionPairWith(t, nil)
+}
+
+// newTestSessionPairWith is newTestSessionPair, calling setup (if any) on
+// the sessions prior to initializing them.
+func newTestSessionPairWith(t *testing.T, setup func(client, server *Session)) (*Session, *Session) {
+	require := require.New(t)
+
+	authKeyClient, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "client NewKeypair()")
+	credsClient := &PeerCredentials{
+		AdditionalData: []byte("alice@example.com"),
+		PublicKey:      authKeyClient.PublicKey(),
+	}
+	authKeyServer, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "server NewKeypair()")
+	credsServer := &PeerCredentials{
+		AdditionalData: []byte("katzenpost.example.com"),
+		PublicKey:      authKeyServer.PublicKey(),
+	}
+
+	client, err := NewSession(&SessionConfig{
+		Authenticator:     &stubAuthenticator{creds: credsServer},
+		AdditionalData:    credsClient.AdditionalData,
+		AuthenticationKey: authKeyClient,
+		RandomReader:      rand.Reader,
+	}, true)
+	require.NoError(err, "client NewSession()")
+	server, err := NewSession(&SessionConfig{
+		Authenticator:     &stubAuthenticator{creds: credsClient},
+		AdditionalData:    credsServer.AdditionalData,
+		AuthenticationKey: authKeyServer,
+		RandomReader:      rand.Reader,
+	}, false)
+	require.NoError(err, "server NewSession()")
+	if setup != nil {
+		setup(client, server)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err, "Listen()")
+	defer l.Close()
+
+	serverErrCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			serverErrCh <- err
+			return
+		}
+		serverErrCh <- server.Initialize(conn)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(err, "Dial()")
+	require.NoError(client.Initialize(conn), "client Initialize()")
+	require.NoError(<-serverErrCh, "server Initialize()")
+
+	return client, server
+}
+
+func TestSessionFlowControl(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		windowSize = 4
+		nrPackets  = 5 * windowSize
+	)
+
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	client.SetFlowControlEnabled(true, windowSize)
+
+	// The client must process the RelayAcks sent by the server.
+	go func() {
+		for {
+			if _, err := client.RecvCommand(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var sent uint32
+	sendErrCh := make(chan error, 1)
+	go func() {
+		for i := 0; i < nrPackets; i++ {
+			if err := client.SendCommand(&commands.SendPacket{SphinxPacket: []byte{byte(i)}}); err != nil {
+				sendErrCh <- err
+				return
+			}
+			atomic.AddUint32(&sent, 1)
+		}
+		sendErrCh <- nil
+	}()
+
+	waitForSent := func(n uint32) {
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadUint32(&sent) < n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Equal(n, atomic.LoadUint32(&sent), "packets sent")
+	}
+
+	// The client pauses once the window is full, despite the server not
+	// having read anything off the network yet.
+	waitForSent(windowSize)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(uint32(windowSize), atomic.LoadUint32(&sent), "client did not pause")
+
+	// Acknowledging each window lets the client resume.
+	for i := 0; i < nrPackets; i++ {
+		cmd, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand()")
+		require.Equal(&commands.SendPacket{SphinxPacket: []byte{byte(i)}}, cmd)
+		if (i+1)%windowSize == 0 {
+			err = server.SendCommand(&commands.RelayAck{Accepted: windowSize})
+			require.NoError(err, "server SendCommand(RelayAck)")
+		}
+	}
+	require.NoError(<-sendErrCh, "client SendCommand()")
+	require.Equal(uint32(nrPackets), atomic.LoadUint32(&sent), "client did not resume")
+}
+
+func TestSessionRelayAck(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		windowSize = 4
+		nrPackets  = 5 * windowSize
+	)
+
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	client.SetFlowControlEnabled(true, windowSize)
+	server.SetRelayAckInterval(windowSize-1, 0)
+
+	acks := make(chan *commands.RelayAck, nrPackets)
+	go func() {
+		for {
+			cmd, err := client.RecvCommand()
+			if err != nil {
+				return
+			}
+			if ack, ok := cmd.(*commands.RelayAck); ok {
+				acks <- ack
+			}
+		}
+	}()
+
+	// Packets that fail to be sent do not consume the window.
+	for i := 0; i < 2*windowSize; i++ {
+		err := client.SendCommand(&commands.SendPacket{SphinxPacket: make([]byte, maxMsgLen)})
+		require.Error(err, "client SendCommand(oversized)")
+	}
+
+	var sent uint32
+	sendErrCh := make(chan error, 1)
+	go func() {
+		for i := 0; i < nrPackets; i++ {
+			if err := client.SendCommand(&commands.SendPacket{SphinxPacket: []byte{byte(i)}}); err != nil {
+				sendErrCh <- err
+				return
+			}
+			atomic.AddUint32(&sent, 1)
+		}
+		sendErrCh <- nil
+	}()
+
+	// The client exhausts the window, and pauses until the server reports
+	// the packets as relayed.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint32(&sent) < windowSize && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(uint32(windowSize), atomic.LoadUint32(&sent), "client did not pause")
+
+	for i := 0; i < nrPackets; i++ {
+		cmd, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand()")
+		require.Equal(&commands.SendPacket{SphinxPacket: []byte{byte(i)}}, cmd)
+		require.NoError(server.ReportRelayed(i%2 == 0, uint32(i)), "server ReportRelayed()")
+	}
+	require.NoError(<-sendErrCh, "client SendCommand()")
+	require.Equal(uint32(nrPackets), atomic.LoadUint32(&sent), "client did not resume")
+
+	ack := <-acks
+	require.Equal(&commands.RelayAck{Accepted: 2, Rejected: 1, QueueDepth: 2}, ack)
+
+	// The remainder is reported periodically.
+	server.SetRelayAckInterval(0, 10*time.Millisecond)
+	accepted, rejected := ack.Accepted, ack.Rejected
+	for accepted+rejected < nrPackets {
+		select {
+		case ack = <-acks:
+			accepted += ack.Accepted
+			rejected += ack.Rejected
+		case <-time.After(5 * time.Second):
+			require.FailNow("RelayAck not sent")
+		}
+	}
+	require.Equal(uint32(nrPackets/2), accepted, "Accepted")
+	require.Equal(uint32(nrPackets/2), rejected, "Rejected")
+	require.Equal(uint32(nrPackets-1), ack.QueueDepth, "QueueDepth")
+}
+
+func TestSessionCommandLog(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "wire_command_log")
+	require.NoError(err, "TempDir()")
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "commands.log")
+
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	err = client.EnableCommandLog(logPath)
+	require.NoError(err, "EnableCommandLog()")
+
+	payload := []byte("this must never end up in the log")
+	sent := []commands.Command{
+		&commands.NoOp{},
+		&commands.SendPacket{SphinxPacket: payload},
+		&commands.RetrieveMessage{Sequence: 1},
+	}
+	received := []commands.Command{
+		&commands.MessageEmpty{Sequence: 1},
+		&commands.Disconnect{},
+	}
+	for _, cmd := range sent {
+		require.NoError(client.SendCommand(cmd), "client SendCommand()")
+		_, err = server.RecvCommand()
+		require.NoError(err, "server RecvCommand()")
+	}
+	for _, cmd := range received {
+		require.NoError(server.SendCommand(cmd), "server SendCommand()")
+		_, err = client.RecvCommand()
+		require.NoError(err, "client RecvCommand()")
+	}
+	client.commandLog.Lock()
+	key := append([]byte{}, client.commandLog.key...)
+	client.commandLog.Unlock()
+	client.DisableCommandLog()
+
+	// Commands after the log is disabled are not recorded.
+	require.NoError(client.SendCommand(&commands.NoOp{}), "client SendCommand()")
+
+	b, err := ioutil.ReadFile(logPath)
+	require.NoError(err, "ReadFile()")
+	require.NotContains(string(b), string(payload), "log contains a plaintext payload")
+
+	expected := []struct {
+		direction string
+		name      string
+		cmd       commands.Command
+	}{
+		{CommandLogDirectionSend, "NoOp", sent[0]},
+		{CommandLogDirectionSend, "SendPacket", sent[1]},
+		{CommandLogDirectionSend, "RetrieveMessage", sent[2]},
+		{CommandLogDirectionRecv, "MessageEmpty", received[0]},
+		{CommandLogDirectionRecv, "Disconnect", received[1]},
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var records []CommandLogRecord
+	for dec.More() {
+		var rec CommandLogRecord
+		require.NoError(dec.Decode(&rec), "Decode()")
+		records = append(records, rec)
+	}
+	require.Len(records, len(expected), "number of log records")
+	for i, rec := range records {
+		b := expected[i].cmd.ToBytes()
+		m := hmac.New(sha256.New, key)
+		m.Write(b)
+		digest := sha256.Sum256(b)
+		require.Equal(expected[i].direction, rec.Direction, "record %d direction", i)
+		require.Equal(expected[i].name, rec.Command, "record %d command", i)
+		require.Equal(hex.EncodeToString(m.Sum(nil)), rec.PayloadHash, "record %d payload hash", i)
+		require.NotEqual(hex.EncodeToString(digest[:]), rec.PayloadHash, "record %d payload hash is unkeyed", i)
+		require.Equal(len(b), rec.PayloadLength, "record %d payload length", i)
+		require.False(rec.Timestamp.IsZero(), "record %d timestamp", i)
+	}
+
+	// Every log has its own key, so the same command is not linkable across
+	// logs.
+	logPath2 := filepath.Join(dir, "commands2.log")
+	require.NoError(client.EnableCommandLog(logPath2), "EnableCommandLog()")
+	require.NoError(client.SendCommand(&commands.NoOp{}), "client SendCommand()")
+	client.DisableCommandLog()
+	b, err = ioutil.ReadFile(logPath2)
+	require.NoError(err, "ReadFile()")
+	var rec CommandLogRecord
+	require.NoError(json.Unmarshal(b, &rec), "Unmarshal()")
+	require.Equal("NoOp", rec.Command)
+	require.NotEqual(records[0].PayloadHash, rec.PayloadHash, "payload hash is linkable across logs")
+}
+
+func TestSessionWireErrors(t *testing.T) {
+	require := require.New(t)
+
+	authKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "NewKeypair()")
+	_, err = NewSession(&SessionConfig{
+		AuthenticationKey: authKey,
+		RandomReader:      rand.Reader,
+	}, true)
+	require.True(commands.IsWireError(err, commands.ErrCodeInvalidConfig), "NewSession(missing Authenticator)")
+
+	client, server := newTestSessionPair(t)
+	defer server.Close()
+
+	err = client.conn.SetDeadline(time.Now())
+	require.NoError(err, "SetDeadline()")
+	_, err = client.RecvCommand()
+	require.True(commands.IsWireError(err, commands.ErrCodeTimeout), "RecvCommand() timeout: %v", err)
+	var netErr net.Error
+	require.True(errors.As(err, &netErr), "errors.As(net.Error)")
+
+	// The session is unusable after a failure.
+	err = client.SendCommand(&commands.NoOp{})
+	require.True(commands.IsWireError(err, commands.ErrCodeInvalidState), "SendCommand() after failure")
+	client.Close()
+}
+
+func TestSessionGoAway(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPair(t)
+	err := client.SendGoAway("client", time.Second)
+	require.True(commands.IsWireError(err, commands.ErrCodeInvalidState), "client SendGoAway()")
+	require.Zero(client.ReconnectDelay(), "ReconnectDelay() before GoAway")
+
+	const retryAfter = 1500 * time.Millisecond
+	go func() {
+		server.SendGoAway("server shutting down", retryAfter)
+		server.Close()
+	}()
+
+	start := time.Now()
+	cmd, err := client.RecvCommand()
+	require.NoError(err, "RecvCommand() GoAway")
+	require.Equal(&commands.GoAway{Reason: "server shutting down", RetryAfterSeconds: 2}, cmd)
+
+	// The server closing the connection is not a failure.
+	_, err = client.RecvCommand()
+	require.True(commands.IsWireError(err, commands.ErrCodeGoAway), "RecvCommand() after GoAway: %v", err)
+	client.Close()
+
+	// Reconnecting waits for the requested duration.
+	delay := client.ReconnectDelay()
+	require.True(delay > retryAfter-time.Since(start), "ReconnectDelay(): %v", delay)
+	time.Sleep(delay)
+	require.True(time.Since(start) >= 2*time.Second, "reconnected too early")
+	require.Zero(client.ReconnectDelay(), "ReconnectDelay() after waiting")
+	client2, server2 := newTestSessionPair(t)
+	client2.Close()
+	server2.Close()
+}
+
+func TestSessionRateLimit(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPair(t)
+	const limit, burst = 1000, 10
+	server.SetRateLimit(limit, burst)
+
+	// The client floods the server with commands.
+	const nrCommands = 1000
+	go func() {
+		for i := 0; i < nrCommands; i++ {
+			if err := client.SendCommand(&commands.NoOp{}); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The server receives every command it reads, throttling the client
+	// once the burst is exhausted.
+	start := time.Now()
+	const nrReceived = 100
+	for i := 0; i < nrReceived; i++ {
+		cmd, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand() %d", i)
+		require.IsType(&commands.NoOp{}, cmd)
+	}
+	elapsed := time.Since(start)
+	require.True(elapsed >= (nrReceived-burst)*time.Second/limit, "rate limit not enforced")
+	server.Close()
+
+	// The client is told to back off before the connection is closed, once
+	// per throttled period rather than once per command.
+	nrThrottles := 0
+	for {
+		cmd, err := client.RecvCommand()
+		if err != nil {
+			break
+		}
+		require.IsType(&commands.Throttle{}, cmd)
+		retryAfter := time.Duration(cmd.(*commands.Throttle).RetryAfterMs) * time.Millisecond
+		require.True(retryAfter >= burst*time.Second/limit, "Throttle RetryAfterMs: %v", retryAfter)
+		nrThrottles++
+	}
+	require.True(nrThrottles > 0, "no Throttle received")
+	require.True(nrThrottles <= int(elapsed/(burst*time.Second/limit))+1, "Throttle sent too often: %d", nrThrottles)
+	client.Close()
+}
+
+func TestSessionRateLimitClose(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPair(t)
+	server.SetRateLimit(0.1, 1)
+	require.NoError(client.SendCommand(&commands.NoOp{}), "client SendCommand()")
+	require.NoError(client.SendCommand(&commands.NoOp{}), "client SendCommand()")
+	_, err := server.RecvCommand()
+	require.NoError(err, "server RecvCommand()")
+
+	// The second command would block for 10 seconds, but closing the
+	// session interrupts the wait.
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := server.RecvCommand()
+		errCh <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	server.Close()
+	select {
+	case err = <-errCh:
+		require.True(commands.IsWireError(err, commands.ErrCodeInvalidState), "server RecvCommand() after Close(): %v", err)
+	case <-time.After(5 * time.Second):
+		require.FailNow("Close() did not interrupt the rate limit")
+	}
+	client.Close()
+}
+
+func TestSessionRateLimitNoThrottle(t *testing.T) {
+	require := require.New(t)
+
+	// A client that doesn't advertise Throttle support is never sent one.
+	client, server := newTestSessionPairWith(t, func(client, server *Session) {
+		client.features = 0
+	})
+	server.SetRateLimit(1000, 1)
+	for i := 0; i < 10; i++ {
+		require.NoError(client.SendCommand(&commands.NoOp{}), "client SendCommand() %d", i)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand() %d", i)
+	}
+	server.Close()
+
+	_, err := client.RecvCommand()
+	require.Error(err, "client RecvCommand()")
+	client.Close()
+}
+
+func TestSessionPinRemoteKey(t *testing.T) {
+	require := require.New(t)
+
+	serverKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err, "server eddsa.NewKeypair()")
+	otherKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err, "other eddsa.NewKeypair()")
+
+	handshake := func(pins []*eddsa.PublicKey) (error, error) {
+		authKeyClient, err := ecdh.NewKeypair(rand.Reader)
+		require.NoError(err, "client NewKeypair()")
+		credsClient := &PeerCredentials{
+			AdditionalData: []byte("alice@example.com"),
+			PublicKey:      authKeyClient.PublicKey(),
+		}
+		authKeyServer := serverKey.ToECDH()
+		credsServer := &PeerCredentials{
+			AdditionalData: []byte("katzenpost.example.com"),
+			PublicKey:      authKeyServer.PublicKey(),
+		}
+
+		client, err := NewSession(&SessionConfig{
+			Authenticator:     &stubAuthenticator{creds: credsServer},
+			AdditionalData:    credsClient.AdditionalData,
+			AuthenticationKey: authKeyClient,
+			RandomReader:      rand.Reader,
+		}, true)
+		require.NoError(err, "client NewSession()")
+		defer client.Close()
+		client.PinRemoteKeys(pins)
+		server, err := NewSession(&SessionConfig{
+			Authenticator:     &stubAuthenticator{creds: credsClient},
+			AdditionalData:    credsServer.AdditionalData,
+			AuthenticationKey: authKeyServer,
+			RandomReader:      rand.Reader,
+		}, false)
+		require.NoError(err, "server NewSession()")
+		defer server.Close()
+
+		clientConn, serverConn := net.Pipe()
+		serverErrCh := make(chan error, 1)
+		go func() {
+			serverErrCh <- server.Initialize(serverConn)
+		}()
+		clientErr := client.Initialize(clientConn)
+		if clientErr != nil {
+			clientConn.Close()
+		}
+		return clientErr, <-serverErrCh
+	}
+
+	// Pinned to a different key, the handshake fails.
+	clientErr, serverErr := handshake([]*eddsa.PublicKey{otherKey.PublicKey()})
+	require.Equal(ErrKeyMismatch, clientErr, "client Initialize(), mismatched pin")
+	require.Error(serverErr, "server Initialize(), mismatched pin")
+
+	// Pinned to the server's key, amongst others, the handshake succeeds.
+	clientErr, serverErr = handshake([]*eddsa.PublicKey{otherKey.PublicKey(), serverKey.PublicKey()})
+	require.NoError(clientErr, "client Initialize(), pinned")
+	require.NoError(serverErr, "server Initialize(), pinned")
+}
+
+func TestSessionStats(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	cmds := []commands.Command{
+		&commands.NoOp{},
+		&commands.GetConsensus{Epoch: 1},
+		&commands.Disconnect{},
+		&commands.NoOp{},
+		&commands.GetConsensus{Epoch: 2},
+		&commands.Disconnect{},
+		&commands.NoOp{},
+		&commands.GetConsensus{Epoch: 3},
+		&commands.Disconnect{},
+		&commands.NoOp{},
+	}
+	expected := make(map[CommandType]CommandSizes)
+	var expectedBytes uint64
+	for _, cmd := range cmds {
+		n := len(cmd.ToBytes())
+		t := commandType(cmd)
+		sizes := expected[t]
+		sizes.add(n)
+		expected[t] = sizes
+		expectedBytes += uint64(macLen + 4 + macLen + n)
+	}
+	require.Len(expected, 3)
+
+	// The NoOp sent by the responder upon completing the handshake is
+	// accounted for as well.
+	noOpLen := len((&commands.NoOp{}).ToBytes())
+	handshakeBytes := uint64(macLen + 4 + macLen + noOpLen)
+	noOps := expected["NoOp"]
+	noOps.add(noOpLen)
+	expected["NoOp"] = noOps
+	require.EqualValues(5, expected["NoOp"].Count)
+
+	go func() {
+		for _, cmd := range cmds {
+			client.SendCommand(cmd)
+		}
+	}()
+	for i := range cmds {
+		cmd, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand() %d", i)
+		require.Equal(cmds[i], cmd)
+	}
+
+	clientStats := client.SessionStats()
+	require.Equal(expected, clientStats.CommandSizeHistogram)
+	require.Equal(expectedBytes, clientStats.BytesOut)
+	require.Equal(handshakeBytes, clientStats.BytesIn)
+
+	serverStats := server.SessionStats()
+	require.Equal(expected, serverStats.CommandSizeHistogram)
+	require.Equal(expectedBytes, serverStats.BytesIn)
+	require.Equal(handshakeBytes, serverStats.BytesOut)
+	require.Equal(float64(len((&commands.GetConsensus{}).ToBytes())), serverStats.CommandSizeHistogram["GetConsensus"].Mean())
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	var events []string
+	logging := func(next CommandHandler) CommandHandler {
+		return func(cmd commands.Command) (commands.Command, error) {
+			events = append(events, "log "+string(commandType(cmd)))
+			cmd, err := next(cmd)
+			events = append(events, "log done")
+			return cmd, err
+		}
+	}
+	const limit = 5
+	nrCommands := 0
+	rateLimiting := func(next CommandHandler) CommandHandler {
+		return func(cmd commands.Command) (commands.Command, error) {
+			events = append(events, "rate limit")
+			nrCommands++
+			if nrCommands > limit {
+				return nil, errors.New("rate limited")
+			}
+			return next(cmd)
+		}
+	}
+	server.UseMiddleware(logging)
+	server.UseMiddleware(rateLimiting)
+
+	go func() {
+		for i := 0; i < limit; i++ {
+			client.SendCommand(&commands.GetConsensus{Epoch: uint64(i)})
+		}
+	}()
+	for i := 0; i < limit; i++ {
+		cmd, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand() %d", i)
+		require.Equal(&commands.GetConsensus{Epoch: uint64(i)}, cmd)
+		require.Equal([]string{"log GetConsensus", "rate limit", "log done"}, events)
+		events = nil
+	}
+
+	// Middleware errors are returned, but are not fatal.
+	go client.SendCommand(&commands.NoOp{})
+	_, err := server.RecvCommand()
+	require.EqualError(err, "rate limited")
+	require.NoError(server.HealthCheck())
+
+	// Middleware may drop commands.
+	nrCommands = 0
+	server.UseMiddleware(func(next CommandHandler) CommandHandler {
+		return func(cmd commands.Command) (commands.Command, error) {
+			if _, ok := cmd.(*commands.NoOp); ok {
+				return nil, nil
+			}
+			return next(cmd)
+		}
+	})
+	go func() {
+		client.SendCommand(&commands.NoOp{})
+		client.SendCommand(&commands.Disconnect{})
+	}()
+	cmd, err := server.RecvCommand()
+	require.NoError(err, "server RecvCommand()")
+	require.IsType(&commands.Disconnect{}, cmd)
+}
+
+func TestSessionRatchet(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPairWith(t, func(client, server *Session) {
+		client.EnableRatchet()
+		server.EnableRatchet()
+	})
+	defer client.Close()
+	defer server.Close()
+
+	// Send 100 commands, changing direction every 10 so that the ratchet
+	// steps.
+	const nrCommands, batchSize = 100, 10
+	var prevKey []byte
+	for i := 0; i < nrCommands; i += batchSize {
+		sender, receiver := client, server
+		if (i/batchSize)%2 == 1 {
+			sender, receiver = server, client
+		}
+		go func(i int) {
+			for j := i; j < i+batchSize; j++ {
+				sender.SendCommand(&commands.GetConsensus{Epoch: uint64(j)})
+			}
+		}(i)
+		for j := i; j < i+batchSize; j++ {
+			cmd, err := receiver.RecvCommand()
+			require.NoError(err, "RecvCommand() %d", j)
+			require.Equal(&commands.GetConsensus{Epoch: uint64(j)}, cmd)
+		}
+
+		// Receiving from a new ratchet key generates a new one.
+		st := receiver.RatchetState()
+		require.NotEqual(prevKey, st.SendRatchetKey, "ratchet did not step")
+		require.EqualValues(batchSize, st.RecvCount)
+		prevKey = st.SendRatchetKey
+	}
+}
+
+func TestRatchetForwardSecrecy(t *testing.T) {
+	require := require.New(t)
+
+	aliceEphemeral, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err)
+	bobEphemeral, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err)
+	channelBinding := []byte("test channel binding")
+	alice, err := newRatchet(rand.Reader, true, aliceEphemeral, bobEphemeral.PublicKey(), channelBinding)
+	require.NoError(err)
+	bob, err := newRatchet(rand.Reader, false, bobEphemeral, aliceEphemeral.PublicKey(), channelBinding)
+	require.NoError(err)
+
+	const nrMessages, compromised = 100, 50
+	var msgs [][]byte
+	var snapshot RatchetSnapshot
+	for i := 1; i <= nrMessages; i++ {
+		if i == compromised {
+			snapshot = alice.snapshot()
+		}
+		msgs = append(msgs, alice.encrypt([]byte(fmt.Sprintf("message %d", i))))
+	}
+	for i, msg := range msgs {
+		pt, err := bob.decrypt(msg)
+		require.NoError(err, "decrypt() %d", i+1)
+		require.Equal(fmt.Sprintf("message %d", i+1), string(pt))
+	}
+
+	// An adversary that learns the chain state prior to message 50 can
+	// derive the key for message 50 and every following message of the
+	// chain, but none of the keys for messages 1-49.
+	var ck [ratchetKeyLen]byte
+	copy(ck[:], snapshot.SendChainKey)
+	var compromisedKeys [][]byte
+	for i := compromised; i <= nrMessages; i++ {
+		compromisedKeys = append(compromisedKeys, kdfChain(&ck))
+	}
+	open := func(mk, msg []byte) error {
+		_, err := ratchetOpen(append([]byte{}, mk...), msg[:ratchetHeaderLen], msg[ratchetHeaderLen:])
+		return err
+	}
+	require.NoError(open(compromisedKeys[0], msgs[compromised-1]), "message key 50")
+	for i := 0; i < compromised-1; i++ {
+		for _, mk := range compromisedKeys {
+			require.Error(open(mk, msgs[i]), "message %d", i+1)
+		}
+	}
+}
+
+// fakeClock is a clock whose After (and Sleep) advance the time without
+// blocking.
+type fakeClock struct {
+	sync.Mutex
+
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func TestSessionBandwidthLimit(t *testing.T) {
+	require := require.New(t)
+
+	clk := &fakeClock{now: time.Now()}
+	client, server := newTestSessionPairWith(t, func(client, server *Session) {
+		client.clock = clk
+		client.bandwidthLimiter = &rateLimiter{clock: clk}
+	})
+	defer client.Close()
+	defer server.Close()
+
+	// The NoOp sent by the responder upon completing the handshake is
+	// accounted for.
+	require.True(server.BytesSent() > 0, "server BytesSent() after handshake")
+	require.Equal(server.BytesSent(), client.BytesRecv(), "client BytesRecv() after handshake")
+	client.ResetCounters()
+	require.Zero(client.BytesRecv())
+	require.Zero(client.SessionStats().BytesIn)
+	server.ResetCounters()
+	require.Zero(server.BytesSent())
+
+	// Sending 10 KB at 1 KB/s takes 9 s, after the initial 1 KB burst.
+	const (
+		limit       = 1024
+		nrCommands  = 10
+		payloadSize = limit - 64
+	)
+	client.SetBandwidthLimit(limit)
+	start := clk.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		for i := 0; i < nrCommands; i++ {
+			if err := client.SendCommand(&commands.SendPacket{SphinxPacket: make([]byte, payloadSize)}); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+	for i := 0; i < nrCommands; i++ {
+		_, err := server.RecvCommand()
+		require.NoError(err, "server RecvCommand() %d", i)
+	}
+	require.NoError(<-errCh, "client SendCommand()")
+
+	sent := client.BytesSent()
+	require.InDelta(nrCommands*limit, sent, nrCommands*limit/10, "BytesSent()")
+	require.Equal(sent, server.BytesRecv(), "server BytesRecv()")
+	require.EqualValues(sent, client.SessionStats().BytesOut, "BytesOut")
+	elapsed := clk.Now().Sub(start)
+	require.InDelta(float64(9*time.Second), float64(elapsed), float64(900*time.Millisecond), "elapsed %v", elapsed)
+}
+
+func TestSessionBandwidthLimitClose(t *testing.T) {
+	require := require.New(t)
+
+	client, server := newTestSessionPair(t)
+	defer server.Close()
+
+	// The second command would block for 10 seconds, but neither blocks
+	// other senders nor Close.
+	client.SetBandwidthLimit(100)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.SendCommand(&commands.SendPacket{SphinxPacket: make([]byte, 1000)})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	client.SetBandwidthLimit(0)
+	require.NoError(client.SendCommand(&commands.NoOp{}), "client SendCommand() while limited")
+	client.Close()
+	select {
+	case err := <-errCh:
+		require.True(commands.IsWireError(err, commands.ErrCodeInvalidState), "SendCommand() after Close(): %v", err)
+	case <-time.After(5 * time.Second):
+		require.FailNow("Close() did not interrupt the bandwidth limit")
+	}
+}
@@ -0,0 +1,134 @@
+// stats.go - Wire protocol session statistics.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/katzenpost/core/wire/commands"
+)
+
+// CommandType is a command type name (eg: "SendPacket").
+type CommandType string
+
+func commandType(cmd commands.Command) CommandType {
+	return CommandType(reflect.Indirect(reflect.ValueOf(cmd)).Type().Name())
+}
+
+// CommandSizes is the distribution of the serialized sizes of the commands
+// of a given type.
+type CommandSizes struct {
+	// Count is the number of commands.
+	Count uint64
+
+	// TotalBytes is the sum of the sizes of the commands.
+	TotalBytes uint64
+
+	// MinBytes and MaxBytes are the smallest and largest command sizes.
+	MinBytes int
+	MaxBytes int
+}
+
+// Mean returns the mean command size.
+func (c CommandSizes) Mean() float64 {
+	if c.Count == 0 {
+		return 0
+	}
+	return float64(c.TotalBytes) / float64(c.Count)
+}
+
+func (c *CommandSizes) add(n int) {
+	if c.Count == 0 || n < c.MinBytes {
+		c.MinBytes = n
+	}
+	if n > c.MaxBytes {
+		c.MaxBytes = n
+	}
+	c.Count++
+	c.TotalBytes += uint64(n)
+}
+
+// Stats is a snapshot of the session statistics.
+type Stats struct {
+	// BytesIn and BytesOut are the number of bytes received and sent,
+	// including framing and authentication overhead.
+	BytesIn  uint64
+	BytesOut uint64
+
+	// CommandSizeHistogram is the distribution of the serialized sizes of
+	// the commands sent and received, by command type.
+	CommandSizeHistogram map[CommandType]CommandSizes
+}
+
+type sessionStats struct {
+	sync.Mutex
+
+	bytesIn   uint64
+	bytesOut  uint64
+	histogram map[CommandType]*CommandSizes
+}
+
+func (s *sessionStats) record(cmd commands.Command, cmdLen, wireLen int, isSend bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if isSend {
+		s.bytesOut += uint64(wireLen)
+	} else {
+		s.bytesIn += uint64(wireLen)
+	}
+	if s.histogram == nil {
+		s.histogram = make(map[CommandType]*CommandSizes)
+	}
+	t := commandType(cmd)
+	sizes, ok := s.histogram[t]
+	if !ok {
+		sizes = new(CommandSizes)
+		s.histogram[t] = sizes
+	}
+	sizes.add(cmdLen)
+}
+
+func (s *sessionStats) byteCounts() (in, out uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.bytesIn, s.bytesOut
+}
+
+func (s *sessionStats) resetByteCounts() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.bytesIn, s.bytesOut = 0, 0
+}
+
+func (s *sessionStats) snapshot() Stats {
+	s.Lock()
+	defer s.Unlock()
+
+	st := Stats{
+		BytesIn:              s.bytesIn,
+		BytesOut:             s.bytesOut,
+		CommandSizeHistogram: make(map[CommandType]CommandSizes, len(s.histogram)),
+	}
+	for t, sizes := range s.histogram {
+		st.CommandSizeHistogram[t] = *sizes
+	}
+	return st
+}
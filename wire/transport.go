@@ -0,0 +1,36 @@
+// transport.go - Wire protocol session transport.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import "time"
+
+// Transport is the underlying stream a Session is conducted over.  It is
+// satisfied by net.Conn, and may be implemented by adapters for other
+// stream oriented transports.
+type Transport interface {
+	// Read reads data from the transport.
+	Read(b []byte) (int, error)
+
+	// Write writes data to the transport.
+	Write(b []byte) (int, error)
+
+	// Close closes the transport.
+	Close() error
+
+	// SetDeadline sets the read and write deadlines of the transport.
+	SetDeadline(t time.Time) error
+}
@@ -0,0 +1,100 @@
+// transport_test.go - Wire protocol session transport tests.
+// Copyright (C) 2021  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/wire/commands"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeTransport exposes only the Transport methods of a net.Conn, so that
+// the Session can not depend on anything else.
+type pipeTransport struct {
+	conn net.Conn
+}
+
+func (t *pipeTransport) Read(b []byte) (int, error)           { return t.conn.Read(b) }
+func (t *pipeTransport) Write(b []byte) (int, error)          { return t.conn.Write(b) }
+func (t *pipeTransport) Close() error                         { return t.conn.Close() }
+func (t *pipeTransport) SetDeadline(deadline time.Time) error { return t.conn.SetDeadline(deadline) }
+
+func TestSessionTransport(t *testing.T) {
+	require := require.New(t)
+
+	authKeyClient, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "client NewKeypair()")
+	authKeyServer, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err, "server NewKeypair()")
+	credsClient := &PeerCredentials{
+		AdditionalData: []byte("alice@example.com"),
+		PublicKey:      authKeyClient.PublicKey(),
+	}
+	credsServer := &PeerCredentials{
+		AdditionalData: []byte("katzenpost.example.com"),
+		PublicKey:      authKeyServer.PublicKey(),
+	}
+
+	client, err := NewSession(&SessionConfig{
+		Authenticator:     &stubAuthenticator{creds: credsServer},
+		AdditionalData:    credsClient.AdditionalData,
+		AuthenticationKey: authKeyClient,
+		RandomReader:      rand.Reader,
+	}, true)
+	require.NoError(err, "client NewSession()")
+	server, err := NewSession(&SessionConfig{
+		Authenticator:     &stubAuthenticator{creds: credsClient},
+		AdditionalData:    credsServer.AdditionalData,
+		AuthenticationKey: authKeyServer,
+		RandomReader:      rand.Reader,
+	}, false)
+	require.NoError(err, "server NewSession()")
+
+	connClient, connServer := net.Pipe()
+	serverErrCh := make(chan error)
+	go func() {
+		serverErrCh <- server.Initialize(&pipeTransport{conn: connServer})
+	}()
+	require.NoError(client.Initialize(&pipeTransport{conn: connClient}), "client Initialize()")
+	require.NoError(<-serverErrCh, "server Initialize()")
+	defer server.Close()
+
+	creds, err := server.PeerCredentials()
+	require.NoError(err, "server PeerCredentials()")
+	require.Equal(credsClient, creds, "server PeerCredentials()")
+
+	payload := []byte("The wire protocol does not care what it is carried over.")
+	go func() {
+		serverErrCh <- client.SendCommand(&commands.SendPacket{SphinxPacket: payload})
+	}()
+	cmd, err := server.RecvCommand()
+	require.NoError(err, "server RecvCommand()")
+	require.NoError(<-serverErrCh, "client SendCommand()")
+	require.IsType(&commands.SendPacket{}, cmd)
+	require.Equal(payload, cmd.(*commands.SendPacket).SphinxPacket)
+
+	// Deadlines are plumbed through to the underlying connection.
+	require.NoError(client.conn.SetDeadline(time.Now()), "SetDeadline()")
+	_, err = client.RecvCommand()
+	require.True(commands.IsWireError(err, commands.ErrCodeTimeout), "RecvCommand() timeout: %v", err)
+	client.Close()
+}